@@ -0,0 +1,122 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ackFileName is the name of the project vulnerability acknowledgements
+// file, found in the working directory or one of its parents.
+const ackFileName = ".ugbt-acknowledgements.json"
+
+// acknowledgements is a set of acknowledged vulnerabilities that are not
+// reported by audit.
+type acknowledgements struct {
+	Acknowledgements []acknowledgement `json:"acknowledgements"`
+}
+
+// acknowledgement is an acknowledged vulnerability.
+type acknowledgement struct {
+	// ID is the OSV, GHSA, CVE or other ID or alias
+	// of the vulnerability.
+	ID string `json:"id"`
+
+	// Module, if not empty, limits the acknowledgement
+	// to findings in the module.
+	Module string `json:"module,omitempty"`
+
+	// Expires is the date, in YYYY-MM-DD format, after
+	// which the acknowledgement no longer applies.
+	Expires string `json:"expires"`
+
+	// Reason is why the vulnerability is acknowledged.
+	Reason string `json:"reason,omitempty"`
+
+	// expires is the parsed expiry time and file is
+	// the file holding the acknowledgement.
+	expires time.Time
+	file    string
+}
+
+// loadAcknowledgements returns the acknowledgements held in the user's
+// ugbt/acknowledgements.json file in the user configuration directory and
+// in the project acknowledgements file nearest to the working directory.
+// Missing files are not an error.
+func (u *ugbt) loadAcknowledgements() ([]acknowledgement, error) {
+	var paths []string
+	dir, err := os.UserConfigDir()
+	if err == nil {
+		paths = append(paths, filepath.Join(dir, "ugbt", "acknowledgements.json"))
+	}
+	for dir := u.wd; dir != ""; {
+		path := filepath.Join(dir, ackFileName)
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+
+	var acks []acknowledgement
+	for _, path := range paths {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		var a acknowledgements
+		err = json.Unmarshal(buf, &a)
+		if err != nil {
+			return nil, fmt.Errorf("invalid acknowledgements file %s: %w", path, err)
+		}
+		for _, ack := range a.Acknowledgements {
+			if ack.ID == "" {
+				return nil, fmt.Errorf("invalid acknowledgements file %s: missing id", path)
+			}
+			t, err := time.ParseInLocation("2006-01-02", ack.Expires, time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("invalid acknowledgements file %s: invalid expiry for %s: %q", path, ack.ID, ack.Expires)
+			}
+			// The acknowledgement holds through the
+			// expiry date.
+			ack.expires = t.AddDate(0, 0, 1)
+			ack.file = path
+			acks = append(acks, ack)
+		}
+	}
+	return acks, nil
+}
+
+// acknowledged returns whether the finding in the module mod is covered by
+// an unexpired acknowledgement. Expired acknowledgements that would cover
+// the finding are returned so that they can be reported.
+func acknowledged(acks []acknowledgement, mod string, f finding, now time.Time) (ok bool, expired []acknowledgement) {
+	for _, ack := range acks {
+		if ack.Module != "" && ack.Module != mod {
+			continue
+		}
+		if !f.hasID(ack.ID) {
+			continue
+		}
+		if now.Before(ack.expires) {
+			return true, nil
+		}
+		expired = append(expired, ack)
+	}
+	return false, expired
+}
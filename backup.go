@@ -0,0 +1,323 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kortschak/ugbt/internal/tool"
+)
+
+// backups implements the backups command.
+type backups struct {
+	*ugbt
+}
+
+func (*backups) Name() string      { return "backups" }
+func (*backups) Usage() string     { return "<list|prune>" }
+func (*backups) ShortHelp() string { return text("list or prune backups of replaced executables") }
+func (b *backups) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The backups command manages the backups of executables that are made before
+they are replaced by the install and update commands when backups are
+enabled in the ugbt config. Backups are retained according to the "keep"
+and "max_age" fields of the "backup" section of the config; pruning is
+performed after each install and by the prune sub command.
+
+Available sub commands are:
+`))
+	for _, c := range b.commands() {
+		fmt.Fprintf(f.Output(), "  %s: %v\n", c.Name(), c.ShortHelp())
+	}
+	fmt.Fprintln(f.Output())
+	f.PrintDefaults()
+}
+
+// Run runs the ugbt backups command.
+func (b *backups) Run(ctx context.Context, args ...string) error {
+	if len(args) == 0 {
+		return tool.CommandLineErrorf("backups requires a sub command")
+	}
+	command, args := args[0], args[1:]
+	for _, c := range b.commands() {
+		if c.Name() == command {
+			return tool.Run(ctx, c, args)
+		}
+	}
+	return tool.CommandLineErrorf("Unknown backups command %v", command)
+}
+
+// commands returns the set of sub commands supported by the backups command.
+func (b *backups) commands() []tool.Application {
+	return []tool.Application{
+		&backupsList{ugbt: b.ugbt},
+		&backupsPrune{ugbt: b.ugbt},
+	}
+}
+
+// backupsList implements the backups list command.
+type backupsList struct {
+	*ugbt
+}
+
+func (*backupsList) Name() string      { return "list" }
+func (*backupsList) Usage() string     { return "[executable-name]" }
+func (*backupsList) ShortHelp() string { return text("list backups of replaced executables") }
+func (*backupsList) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The list sub command prints the retained backups, newest first, optionally
+restricted to backups of the named executable.
+
+`))
+	f.PrintDefaults()
+}
+
+// Run runs the ugbt backups list command.
+func (l *backupsList) Run(ctx context.Context, args ...string) error {
+	var name string
+	switch len(args) {
+	case 0:
+	case 1:
+		name = args[0]
+	default:
+		return errors.New("backups list requires zero or one argument")
+	}
+
+	all, err := l.backups()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, b := range all {
+		if name != "" && b.name() != name {
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", b.name(), b.Version, b.Time.Local().Format("_2 Jan 2006 15:04"), b.Path)
+	}
+	return w.Flush()
+}
+
+// backupsPrune implements the backups prune command.
+type backupsPrune struct {
+	*ugbt
+}
+
+func (*backupsPrune) Name() string  { return "prune" }
+func (*backupsPrune) Usage() string { return "" }
+func (*backupsPrune) ShortHelp() string {
+	return text("remove backups that are outside the retention policy")
+}
+func (*backupsPrune) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The prune sub command removes backups that are outside the retention policy
+given by the "keep" and "max_age" fields of the "backup" section of the
+ugbt config, and prints the backups that were removed.
+
+`))
+	f.PrintDefaults()
+}
+
+// Run runs the ugbt backups prune command.
+func (p *backupsPrune) Run(ctx context.Context, args ...string) error {
+	if len(args) != 0 {
+		return errors.New("backups prune requires no arguments")
+	}
+	removed, err := p.pruneBackups(time.Now())
+	for _, b := range removed {
+		fmt.Fprintf(os.Stderr, "removed %s %s\n", b.name(), b.Version)
+	}
+	return err
+}
+
+// backup is a backed up executable.
+type backup struct {
+	// Path is the path that the executable was installed at.
+	Path string `json:"path"`
+	// Module is the module path of the executable, or
+	// empty if the executable has no build information.
+	Module string `json:"module"`
+	// Version is the module version of the executable.
+	Version string `json:"version"`
+	// Time is the time the backup was made.
+	Time time.Time `json:"time"`
+
+	// dir is the directory holding the backup.
+	dir string
+}
+
+// name returns the name of the backed up executable.
+func (b backup) name() string {
+	return filepath.Base(b.Path)
+}
+
+// exe returns the path to the backed up executable.
+func (b backup) exe() string {
+	return filepath.Join(b.dir, b.name())
+}
+
+// backupMetadata is the name of the file holding backup metadata
+// within a backup directory.
+const backupMetadata = "backup.json"
+
+// backupDir returns the root directory for backups.
+func (u *ugbt) backupDir() (string, error) {
+	if u.config.Backup.Dir != "" {
+		return u.config.Backup.Dir, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ugbt", "backups"), nil
+}
+
+// backup makes a backup of the executable that will be replaced by
+// installing the package path and returns it. If there is no executable
+// to replace, backup is a no-op and returns nil. An executable without
+// readable build information is backed up without a module and version.
+func (u *ugbt) backup(ctx context.Context, pkg string) (*backup, error) {
+	dst, err := u.installPath(ctx, pkg)
+	if err != nil {
+		return nil, err
+	}
+	_, err = os.Stat(dst)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	b := backup{Path: dst, Time: time.Now().UTC()}
+	_, b.Module, b.Version, err = u.version(ctx, dst)
+	if err != nil {
+		// The executable being replaced may not have readable
+		// build information, but it is still worth keeping.
+		u.debugf("backup %s without version: %v", dst, err)
+		b.Module, b.Version = "", ""
+	}
+	root, err := u.backupDir()
+	if err != nil {
+		return nil, err
+	}
+	b.dir = filepath.Join(root, b.name(), b.Time.Format("20060102T150405.000000000Z"))
+	err = os.MkdirAll(b.dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	err = copyFile(b.exe(), dst)
+	if err != nil {
+		os.RemoveAll(b.dir)
+		return nil, err
+	}
+	meta, err := json.MarshalIndent(b, "", "\t")
+	if err != nil {
+		os.RemoveAll(b.dir)
+		return nil, err
+	}
+	err = os.WriteFile(filepath.Join(b.dir, backupMetadata), meta, 0o644)
+	if err != nil {
+		os.RemoveAll(b.dir)
+		return nil, err
+	}
+	return &b, nil
+}
+
+// backups returns all the retained backups sorted by executable name
+// and then newest first.
+func (u *ugbt) backups() ([]backup, error) {
+	root, err := u.backupDir()
+	if err != nil {
+		return nil, err
+	}
+	dirs, err := filepath.Glob(filepath.Join(root, "*", "*", backupMetadata))
+	if err != nil {
+		return nil, err
+	}
+	var all []backup
+	for _, path := range dirs {
+		meta, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var b backup
+		err = json.Unmarshal(meta, &b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid backup metadata %s: %w", path, err)
+		}
+		b.dir = filepath.Dir(path)
+		all = append(all, b)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].name() != all[j].name() {
+			return all[i].name() < all[j].name()
+		}
+		return all[i].Time.After(all[j].Time)
+	})
+	return all, nil
+}
+
+// pruneBackups removes backups that are outside the configured retention
+// policy at the time now, returning the removed backups.
+func (u *ugbt) pruneBackups(now time.Time) ([]backup, error) {
+	all, err := u.backups()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		removed []backup
+		n       int
+	)
+	keep := u.config.Backup.Keep
+	maxAge := time.Duration(u.config.Backup.MaxAge)
+	for i, b := range all {
+		if i == 0 || b.name() != all[i-1].name() {
+			n = 0
+		}
+		n++
+		if (keep <= 0 || n <= keep) && (maxAge <= 0 || now.Sub(b.Time) <= maxAge) {
+			continue
+		}
+		err = os.RemoveAll(b.dir)
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, b)
+	}
+	return removed, nil
+}
+
+// copyFile copies the file at src to dst, preserving its permissions.
+func copyFile(dst, src string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	fi, err := r.Stat()
+	if err != nil {
+		return err
+	}
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
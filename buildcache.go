@@ -0,0 +1,149 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"debug/buildinfo"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// buildInfoCache is a cache of the build information of executables, keyed
+// by executable path, so that bulk scans of unchanged executables do not
+// read and parse them again.
+type buildInfoCache struct {
+	mu      sync.Mutex
+	loaded  bool
+	dirty   bool
+	entries map[string]buildInfoEntry
+}
+
+// buildInfoEntry is the cached build information of an executable. The
+// entry is valid while the size and modification time of the executable
+// are unchanged.
+type buildInfoEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+
+	// Info is the text form of the build information
+	// and GoVersion is the Go version, which is not
+	// restored by debug.ParseBuildInfo. Err is the error
+	// reading it if the file is not a Go executable.
+	Info      string `json:"info,omitempty"`
+	GoVersion string `json:"go_version,omitempty"`
+	Err       string `json:"err,omitempty"`
+}
+
+// buildInfoCachePath returns the path of the build information cache file.
+func buildInfoCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ugbt", "buildinfo.json"), nil
+}
+
+// exeBuildInfo returns the build information of the executable at path.
+// The build information is read from the cache if the executable is
+// unchanged since it was cached, and is otherwise read from the
+// executable and cached.
+func (u *ugbt) exeBuildInfo(path string) (*debug.BuildInfo, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := u.buildInfos
+	c.mu.Lock()
+	c.load()
+	e, ok := c.entries[path]
+	c.mu.Unlock()
+	if ok && e.Size == fi.Size() && e.ModTime.Equal(fi.ModTime()) {
+		if e.Err != "" {
+			return nil, errors.New(e.Err)
+		}
+		info, err := debug.ParseBuildInfo(e.Info)
+		if err == nil {
+			info.GoVersion = e.GoVersion
+			return info, nil
+		}
+		// Fall back to reading the executable
+		// if the entry is not valid.
+	}
+
+	e = buildInfoEntry{Size: fi.Size(), ModTime: fi.ModTime()}
+	info, err := buildinfo.ReadFile(path)
+	if err != nil {
+		e.Err = err.Error()
+	} else {
+		e.Info, e.GoVersion = info.String(), info.GoVersion
+	}
+	c.mu.Lock()
+	c.entries[path] = e
+	c.dirty = true
+	c.mu.Unlock()
+	return info, err
+}
+
+// load reads the cache file if it has not already been read. A missing or
+// invalid cache file results in an empty cache.
+func (c *buildInfoCache) load() {
+	if c.loaded {
+		return
+	}
+	c.loaded = true
+	c.entries = make(map[string]buildInfoEntry)
+	path, err := buildInfoCachePath()
+	if err != nil {
+		return
+	}
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if json.Unmarshal(buf, &c.entries) != nil {
+		c.entries = make(map[string]buildInfoEntry)
+	}
+}
+
+// saveBuildInfoCache writes the build information cache to the cache file
+// if it has changed, dropping the entries of executables that no longer
+// exist.
+func (u *ugbt) saveBuildInfoCache() error {
+	c := u.buildInfos
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	for path := range c.entries {
+		if _, err := os.Stat(path); err != nil {
+			delete(c.entries, path)
+		}
+	}
+	path, err := buildInfoCachePath()
+	if err != nil {
+		return err
+	}
+	buf, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	err = writeCacheFile(path, buf)
+	if err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
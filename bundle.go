@@ -0,0 +1,569 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"debug/buildinfo"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kortschak/ugbt/internal/tool"
+)
+
+// toolManifest is a list of tools to be bundled.
+type toolManifest struct {
+	Tools []manifestTool `json:"tools"`
+}
+
+// manifestTool is a tool in a toolManifest.
+type manifestTool struct {
+	// Package is the package path of the tool.
+	Package string `json:"package"`
+	// Version is the version of the tool's module, or
+	// a version query such as "latest".
+	Version string `json:"version"`
+}
+
+// sbomName is the name of the SBOM written into a bundle.
+const sbomName = "sbom.cdx.json"
+
+// bundle implements the bundle command.
+type bundle struct {
+	*ugbt
+
+	Manifest string `flag:"manifest" required:"true" help:"path or http or https URL of the manifest listing the tools to bundle."`
+	Output   string `flag:"o" help:"directory or .tar.gz or .tgz file to write the bundle to."`
+	GOOS     string `flag:"goos" help:"operating system to build the tools for."`
+	GOARCH   string `flag:"goarch" help:"architecture to build the tools for."`
+
+	OCI        string `flag:"oci" help:"directory to write an OCI image layout holding the tools to, with an optional :tag."`
+	Dockerfile bool   `flag:"dockerfile" help:"write a Dockerfile that copies the tools into an image with the -o bundle."`
+
+	BuildFlags
+}
+
+func (*bundle) Name() string  { return "bundle" }
+func (*bundle) Usage() string { return "" }
+func (*bundle) ShortHelp() string {
+	return text("build the tools of a manifest into a distributable bundle")
+}
+func (*bundle) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The bundle command builds all the tools listed in a manifest for the
+platform given by -goos and -goarch and writes them, with a CycloneDX SBOM
+describing the executables and their module dependencies, into the -o
+directory or, if -o ends in .tar.gz or .tgz, into a gzipped tarball. The
+bundle is intended for shipping a pinned toolset into CI images or offline
+environments. The user's install directory is not changed.
+
+The manifest is a JSON file listing the package path and version of each
+tool. For example
+
+	{
+		"tools": [
+			{"package": "golang.org/x/tools/gopls", "version": "v0.16.0"},
+			{"package": "honnef.co/go/tools/cmd/staticcheck", "version": "2024.1.1"}
+		]
+	}
+
+Versions may be any version query accepted by go install, but the SBOM
+always records the resolved version. Tools pinned to a Go release in the
+"tools" section of the ugbt config are built with that release. If the
+"bundle" section of the ugbt config gives a signature format, the manifest
+is only used if its detached signature is valid, as for the team policy.
+
+If the -oci flag is given, an OCI image layout is written to the provided
+directory, which must not exist, holding a single layer image with the
+tools in /usr/local/bin and the SBOM in /usr/local/share/ugbt. The image is
+tagged with the tag following a colon in the flag value, or "latest". The
+image can be published with tools that copy OCI layouts to registries, for
+example
+
+	skopeo copy oci:tools-image:v1 docker://registry.example.com/tools:v1
+
+If the -dockerfile flag is given, a Dockerfile is added to the -o bundle
+that copies the tools and SBOM to the same locations in a scratch image,
+so that the bundle can be used as a docker build context, or the image used
+as a source of tools with COPY --from in other Dockerfiles.
+
+`))
+	f.PrintDefaults()
+}
+
+// Run runs the ugbt bundle command.
+func (b *bundle) Run(ctx context.Context, args ...string) error {
+	if len(args) != 0 {
+		return tool.CommandLineErrorf("bundle does not accept arguments")
+	}
+	if b.Output == "" && b.OCI == "" {
+		return tool.CommandLineErrorf("bundle requires an -o or -oci output")
+	}
+	if b.Dockerfile && b.Output == "" {
+		return tool.CommandLineErrorf("bundle -dockerfile requires an -o output")
+	}
+	if b.GOOS == "" {
+		b.GOOS = runtime.GOOS
+	}
+	if b.GOARCH == "" {
+		b.GOARCH = runtime.GOARCH
+	}
+	if b.Sandbox != "" && (b.GOOS != runtime.GOOS || b.GOARCH != runtime.GOARCH) {
+		return errors.New("bundle -sandbox can not be used when cross-compiling")
+	}
+	var layout, tag string
+	if b.OCI != "" {
+		layout, tag = splitOCIRef(b.OCI)
+		if !filepath.IsAbs(layout) {
+			layout = filepath.Join(b.wd, layout)
+		}
+		if _, err := os.Stat(layout); err == nil {
+			return fmt.Errorf("%s already exists", layout)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	manifest, err := b.readManifest(ctx)
+	if err != nil {
+		return err
+	}
+
+	out := b.Output
+	if out != "" && !filepath.IsAbs(out) {
+		out = filepath.Join(b.wd, out)
+	}
+	archive := strings.HasSuffix(out, ".tar.gz") || strings.HasSuffix(out, ".tgz")
+	dir := out
+	if out == "" || archive {
+		dir, err = os.MkdirTemp("", "ugbt-bundle-*")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+	} else {
+		err = os.MkdirAll(dir, 0o755)
+		if err != nil {
+			return err
+		}
+	}
+
+	files, err := b.build(ctx, dir, manifest)
+	if err != nil {
+		return err
+	}
+	if layout != "" {
+		err = writeOCILayout(layout, tag, dir, files, b.GOOS, b.GOARCH)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "wrote OCI image %s:%s for %s/%s\n", layout, tag, b.GOOS, b.GOARCH)
+	}
+	if b.Dockerfile {
+		err = writeDockerfile(dir, b.Manifest, files)
+		if err != nil {
+			return err
+		}
+		files = append(files, "Dockerfile")
+	}
+	switch {
+	case out == "":
+		return nil
+	case archive:
+		err = writeTarball(out, dir, files)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "bundled %d tools for %s/%s in %s\n", len(manifest.Tools), b.GOOS, b.GOARCH, out)
+	return nil
+}
+
+// readManifest returns the tool manifest named by the -manifest flag.
+// If a signature format is configured, the manifest is only used if its
+// detached signature is valid.
+func (b *bundle) readManifest(ctx context.Context) (*toolManifest, error) {
+	cfg := b.config.Bundle.Signature
+	err := cfg.check()
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle signature config: %w", err)
+	}
+	var sigSrc string
+	if cfg.Format != "" {
+		sigSrc = cfg.location(b.Manifest)
+	}
+	buf, sig, err := b.readSigned(ctx, b.Manifest, sigSrc)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	if cfg.Format != "" {
+		err = cfg.verify(ctx, buf, sig)
+		if err != nil {
+			return nil, fmt.Errorf("manifest %s: %w", b.Manifest, err)
+		}
+	}
+	var m toolManifest
+	err = json.Unmarshal(buf, &m)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", b.Manifest, err)
+	}
+	if len(m.Tools) == 0 {
+		return nil, fmt.Errorf("no tools in manifest %s", b.Manifest)
+	}
+	names := make(map[string]string)
+	for _, t := range m.Tools {
+		if t.Package == "" || t.Version == "" {
+			return nil, fmt.Errorf("invalid manifest %s: tools require a package and a version", b.Manifest)
+		}
+		name := exeName(t.Package)
+		if other, ok := names[name]; ok {
+			return nil, fmt.Errorf("invalid manifest %s: %s and %s are both installed as %s", b.Manifest, other, t.Package, name)
+		}
+		names[name] = t.Package
+	}
+	return &m, nil
+}
+
+// build builds the manifest tools into dir and writes the SBOM for them.
+// It returns the names of the files written to dir.
+func (b *bundle) build(ctx context.Context, dir string, manifest *toolManifest) ([]string, error) {
+	modcache, err := b.goenv(ctx, "GOMODCACHE")
+	if err != nil {
+		return nil, err
+	}
+	gopath, err := os.MkdirTemp("", "ugbt-bundle-gopath-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(gopath)
+	var (
+		files []string
+		built []bundled
+	)
+	for _, t := range manifest.Tools {
+		fmt.Fprintf(os.Stderr, "build %s@%s for %s/%s\n", t.Package, t.Version, b.GOOS, b.GOARCH)
+		path, err := b.buildTool(ctx, t, gopath, modcache)
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.Base(path)
+		dst := filepath.Join(dir, name)
+		err = moveFile(dst, path)
+		if err != nil {
+			return nil, err
+		}
+		exe, err := describeBundled(dst)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, name)
+		built = append(built, exe)
+	}
+	sbom, err := b.sbom(ctx, built)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(filepath.Join(dir, sbomName), sbom, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return append(files, sbomName), nil
+}
+
+// buildTool builds the tool for the bundle target platform and returns the
+// path to the executable in the bin directory of gopath.
+//
+// The tool is built by go install with an empty GOBIN and the temporary
+// GOPATH since go install does not allow cross-compiled executables to be
+// written to GOBIN. The module cache of the user is retained.
+func (b *bundle) buildTool(ctx context.Context, t manifestTool, gopath, modcache string) (string, error) {
+	var buf bytes.Buffer
+	stderr := io.Writer(&buf)
+	if b.Verbose || b.Commands {
+		stderr = io.MultiWriter(os.Stderr, stderr)
+	}
+	cmd := b.cmd(ctx, nil, stderr, append(b.installArgs(), t.Package+"@"+t.Version)...)
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	gobin := filepath.Join(gopath, "bin")
+	cmd.Env = append(cmd.Env,
+		"GOBIN=",
+		"GOPATH="+gopath,
+		"GOMODCACHE="+modcache,
+		"GOOS="+b.GOOS,
+		"GOARCH="+b.GOARCH,
+	)
+	if pin := b.pin(t.Package); pin != "" {
+		err := b.usePinned(ctx, cmd, pin, b.BuildFlags)
+		if err != nil {
+			return "", err
+		}
+	}
+	if b.Sandbox != "" {
+		cleanup, err := b.sandbox(ctx, cmd, b.Sandbox, gobin, "")
+		if err != nil {
+			return "", err
+		}
+		defer cleanup()
+	}
+	err := cmd.Run()
+	if err != nil {
+		if b.Verbose || b.Commands {
+			return "", fmt.Errorf("go install: %w", err)
+		}
+		return "", b.sumDBError(ctx, t.Package, buf.String())
+	}
+	if b.GOOS != runtime.GOOS || b.GOARCH != runtime.GOARCH {
+		gobin = filepath.Join(gobin, b.GOOS+"_"+b.GOARCH)
+	}
+	name := exeName(t.Package)
+	if b.GOOS == "windows" {
+		name += ".exe"
+	}
+	return filepath.Join(gobin, name), nil
+}
+
+// moveFile moves the file at src to dst. The file is copied if it can not
+// be renamed.
+func moveFile(dst, src string) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return writeFile(dst, r, 0o755)
+}
+
+// bundled is a description of an executable written to a bundle.
+type bundled struct {
+	name   string
+	sha256 string
+	info   *buildinfo.BuildInfo
+}
+
+// describeBundled returns the description of the bundled executable at
+// path.
+func describeBundled(path string) (bundled, error) {
+	info, err := buildinfo.ReadFile(path)
+	if err != nil {
+		return bundled{}, err
+	}
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return bundled{}, err
+	}
+	return bundled{name: filepath.Base(path), sha256: sum, info: info}, nil
+}
+
+// cdxBOM is the subset of a CycloneDX bill of materials written for a
+// bundle.
+type cdxBOM struct {
+	BOMFormat    string          `json:"bomFormat"`
+	SpecVersion  string          `json:"specVersion"`
+	Version      int             `json:"version"`
+	Metadata     cdxMetadata     `json:"metadata"`
+	Components   []cdxComponent  `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies"`
+}
+
+type cdxMetadata struct {
+	Timestamp string      `json:"timestamp"`
+	Tools     []cdxTool   `json:"tools"`
+	Component cdxMetaComp `json:"component"`
+}
+
+type cdxTool struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type cdxMetaComp struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+type cdxComponent struct {
+	Type       string        `json:"type"`
+	BOMRef     string        `json:"bom-ref"`
+	Name       string        `json:"name"`
+	Version    string        `json:"version,omitempty"`
+	PURL       string        `json:"purl,omitempty"`
+	Hashes     []cdxHash     `json:"hashes,omitempty"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// sbom returns a CycloneDX JSON SBOM for the bundled executables. Each
+// executable is an application component depending on library components
+// for the modules it was built from.
+func (b *bundle) sbom(ctx context.Context, tools []bundled) ([]byte, error) {
+	var version string
+	if info, err := b.readBuildInfo(ctx, ""); err == nil {
+		version = builtModule(info).Version
+	}
+	bom := cdxBOM{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.5",
+		Version:     1,
+		Metadata: cdxMetadata{
+			Timestamp: time.Now().UTC().Format(time.RFC3339),
+			Tools:     []cdxTool{{Name: "ugbt", Version: version}},
+			Component: cdxMetaComp{Type: "application", Name: "tools-" + b.GOOS + "-" + b.GOARCH},
+		},
+	}
+	libs := make(map[string]cdxComponent)
+	for _, t := range tools {
+		main := t.info.Main
+		ref := "exe:" + t.name
+		props := []cdxProperty{
+			{Name: "ugbt:package", Value: t.info.Path},
+			{Name: "ugbt:go", Value: t.info.GoVersion},
+		}
+		for _, s := range t.info.Settings {
+			switch s.Key {
+			case "GOOS", "GOARCH", "-trimpath", "-ldflags":
+				props = append(props, cdxProperty{Name: "ugbt:" + strings.TrimPrefix(s.Key, "-"), Value: s.Value})
+			}
+		}
+		bom.Components = append(bom.Components, cdxComponent{
+			Type:       "application",
+			BOMRef:     ref,
+			Name:       t.name,
+			Version:    main.Version,
+			PURL:       goPURL(main.Path, main.Version),
+			Hashes:     []cdxHash{{Alg: "SHA-256", Content: t.sha256}},
+			Properties: props,
+		})
+		dep := cdxDependency{Ref: ref}
+		for _, m := range append([]*debug.Module{&main}, t.info.Deps...) {
+			if m.Replace != nil {
+				m = m.Replace
+			}
+			purl := goPURL(m.Path, m.Version)
+			if _, ok := libs[purl]; !ok {
+				libs[purl] = cdxComponent{
+					Type:    "library",
+					BOMRef:  purl,
+					Name:    m.Path,
+					Version: m.Version,
+					PURL:    purl,
+				}
+			}
+			dep.DependsOn = append(dep.DependsOn, purl)
+		}
+		bom.Dependencies = append(bom.Dependencies, dep)
+	}
+	refs := make([]string, 0, len(libs))
+	for ref := range libs {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	for _, ref := range refs {
+		bom.Components = append(bom.Components, libs[ref])
+	}
+	return json.MarshalIndent(bom, "", "\t")
+}
+
+// goPURL returns the package URL for the Go module path at the version.
+func goPURL(path, version string) string {
+	purl := "pkg:golang/" + path
+	if version != "" && version != "(devel)" {
+		purl += "@" + version
+	}
+	return purl
+}
+
+// writeTarball writes the files in dir to a gzipped tarball at path.
+func writeTarball(path, dir string, files []string) (err error) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(tmp)
+		}
+	}()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, name := range files {
+		err = addTarFile(tw, filepath.Join(dir, name), name)
+		if err != nil {
+			return err
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	err = gz.Close()
+	if err != nil {
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// addTarFile adds the file at path to tw with the provided name.
+func addTarFile(tw *tar.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	hdr.Uid, hdr.Gid = 0, 0
+	hdr.Uname, hdr.Gname = "", ""
+	err = tw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
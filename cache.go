@@ -0,0 +1,102 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// listCache is a cached proxy @v/list response.
+type listCache struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Fetched      time.Time `json:"fetched"`
+	Body         []byte    `json:"body"`
+}
+
+// listCachePath returns the path of the cache file for the @v/list URL.
+func listCachePath(url string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(dir, "ugbt", "lists", hex.EncodeToString(sum[:])+".json"), nil
+}
+
+// getList returns the version list at the @v/list URL. The response is
+// cached with its ETag and Last-Modified validators, and subsequent
+// requests are made conditional on the cached validators. A not modified
+// response is satisfied from the cache. If the cached list was fetched
+// within the -max-age duration, it is used without a request. The -refresh
+// flag ignores the cache.
+func (u *ugbt) getList(ctx context.Context, url string) ([]byte, error) {
+	path, err := listCachePath(url)
+	if err != nil {
+		return u.get(ctx, url)
+	}
+	var cached listCache
+	buf, err := os.ReadFile(path)
+	switch {
+	case u.Refresh, errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		err = json.Unmarshal(buf, &cached)
+		if err != nil || cached.URL != url {
+			cached = listCache{}
+		}
+	}
+	if cached.URL != "" && u.MaxAge > 0 && time.Since(cached.Fetched) < u.MaxAge {
+		u.debugf("using cached %s fetched %s", url, cached.Fetched.Format(time.RFC3339))
+		return cached.Body, nil
+	}
+
+	header := make(http.Header)
+	if cached.ETag != "" {
+		header.Set("If-None-Match", cached.ETag)
+	}
+	if cached.LastModified != "" {
+		header.Set("If-Modified-Since", cached.LastModified)
+	}
+	body, resp, err := u.fetch(ctx, url, header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		u.debugf("using cached %s", url)
+		cached.Fetched = time.Now()
+		// Failing to update the cache is not fatal.
+		_ = writeListCache(path, cached)
+		return cached.Body, nil
+	}
+	_ = writeListCache(path, listCache{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Fetched:      time.Now(),
+		Body:         body,
+	})
+	return body, nil
+}
+
+// writeListCache writes the cached list to path.
+func writeListCache(path string, c listCache) error {
+	buf, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return writeCacheFile(path, buf)
+}
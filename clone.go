@@ -0,0 +1,213 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	"golang.org/x/sys/execabs"
+
+	"github.com/kortschak/ugbt/internal/modrepo"
+	"github.com/kortschak/ugbt/internal/tool"
+)
+
+// clone implements the clone command.
+type clone struct {
+	*ugbt
+
+	DryRun bool `flag:"dry-run,n" help:"don't clone anything, just print the git commands that would be run."`
+	SSH    bool `flag:"ssh" help:"clone using the repository's ssh URL."`
+}
+
+func (*clone) Name() string  { return "clone" }
+func (*clone) Usage() string { return "</path/to/go/executable> [dir]" }
+func (*clone) ShortHelp() string {
+	return text("clone the source of an executable at its installed version")
+}
+func (*clone) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The clone command clones the source code repository of the executable's
+module into dir and checks out the revision the executable was built from,
+ready for debugging or patching. If dir is not provided, the repository is
+cloned into a directory named for the repository in the current directory.
+
+The revision is the commit recorded by the module proxy for the installed
+version if the proxy provides it, otherwise the version's tag, or the commit
+of a pseudo-version. Executables built from a local checkout are checked out
+at the commit recorded in their build information. The clone is left with a
+detached HEAD.
+
+The repository is cloned from the URL recorded by the module proxy if it
+provides one, otherwise from its https URL. The -ssh flag clones from the
+repository's ssh URL instead, for forges where it is known.
+
+`))
+	f.PrintDefaults()
+}
+
+// origin is the origin of a module version as reported by a module proxy.
+type origin struct {
+	VCS    string
+	URL    string
+	Subdir string
+	Hash   string
+	Ref    string
+}
+
+// Run runs the ugbt clone command.
+func (c *clone) Run(ctx context.Context, args ...string) error {
+	var exe, dir string
+	switch len(args) {
+	case 1:
+		exe = args[0]
+	case 2:
+		exe, dir = args[0], args[1]
+	default:
+		return tool.CommandLineErrorf("clone requires an executable path and an optional directory")
+	}
+
+	info, err := c.buildInfo(ctx, exe)
+	if err != nil {
+		return err
+	}
+	pkg, mod, version, err := c.exeVersion(ctx, info, exe)
+	if err != nil {
+		return err
+	}
+	remote, err := modrepo.CloneURLs(ctx, doFunc(c.do), mod)
+	if err != nil {
+		return err
+	}
+	repo := remote.HTTPS
+	if c.SSH {
+		if remote.SSH == "" {
+			return fmt.Errorf("no ssh clone URL known for %s", repo)
+		}
+		repo = remote.SSH
+	}
+
+	var o origin
+	switch {
+	case mod == "std":
+		o.Ref, o.Subdir = version, "src"
+	case semver.IsValid(version):
+		buf, ok, err := c.proxyFile(ctx, mod, version, ".info")
+		if err != nil {
+			return err
+		}
+		if ok {
+			var v struct{ Origin *origin }
+			err = json.Unmarshal(buf, &v)
+			if err != nil {
+				return fmt.Errorf("invalid version info: %w", err)
+			}
+			if v.Origin != nil && v.Origin.VCS == "git" {
+				o = *v.Origin
+			}
+		}
+		if o.Hash == "" && o.Ref == "" {
+			o.Ref, o.Subdir = versionRef(mod, remote.Root, version)
+		}
+	default:
+		// Built from a local checkout.
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				o.Hash = s.Value
+			}
+		}
+		if o.Hash == "" {
+			return fmt.Errorf("%s was built from %s %s without a recorded revision", exeBase(exe), mod, version)
+		}
+		_, o.Subdir = versionRef(mod, remote.Root, "")
+	}
+	if o.URL != "" && !c.SSH {
+		repo = o.URL
+	}
+	rev := o.Hash
+	if rev == "" {
+		rev = strings.TrimPrefix(o.Ref, "refs/tags/")
+	}
+	// The origin is provided by the module proxy, so do not
+	// allow it to be interpreted as git options.
+	if strings.HasPrefix(rev, "-") {
+		return fmt.Errorf("invalid revision %q for %s", rev, mod)
+	}
+
+	if dir == "" {
+		dir = strings.TrimSuffix(path.Base(repo), ".git")
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(c.wd, dir)
+	}
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("%s already exists", dir)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	for _, step := range []struct {
+		name string
+		args []string
+	}{
+		{name: "clone", args: []string{"clone", "--", repo, dir}},
+		{name: "checkout " + rev, args: []string{"-C", dir, "-c", "advice.detachedHead=false", "checkout", "--detach", rev, "--"}},
+	} {
+		fmt.Fprintf(os.Stderr, "git %s\n", strings.Join(step.args, " "))
+		if c.DryRun {
+			continue
+		}
+		cmd := execabs.CommandContext(ctx, "git", step.args...)
+		cmd.Dir = c.wd
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		err = cmd.Run()
+		if err != nil {
+			return fmt.Errorf("git %s: %w", step.name, err)
+		}
+	}
+
+	// Print the package directory in the clone.
+	rel := strings.TrimPrefix(strings.TrimPrefix(pkg, mod), "/")
+	if mod == "std" {
+		rel = pkg
+	}
+	fmt.Println(filepath.Join(dir, filepath.FromSlash(o.Subdir), filepath.FromSlash(rel)))
+	return nil
+}
+
+// versionRef returns the git tag for the version of the module mod held in
+// the repository with the import path prefix root, and the module's
+// directory within the repository. For pseudo-versions the ref is the
+// commit prefix encoded in the version.
+func versionRef(mod, root, version string) (ref, subdir string) {
+	prefix, _, _ := module.SplitPathVersion(mod)
+	if strings.HasPrefix(prefix, root+"/") {
+		subdir = strings.TrimPrefix(prefix, root+"/")
+	}
+	if version == "" {
+		return "", subdir
+	}
+	if module.IsPseudoVersion(version) {
+		rev, err := module.PseudoVersionRev(version)
+		if err == nil {
+			return rev, subdir
+		}
+	}
+	ref = strings.TrimSuffix(version, "+incompatible")
+	if subdir != "" {
+		ref = subdir + "/" + ref
+	}
+	return ref, subdir
+}
@@ -8,20 +8,25 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"debug/buildinfo"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
 	"regexp"
+	"runtime"
 	"runtime/debug"
 	"sort"
 	"strings"
+	"sync"
 	"text/tabwriter"
 	"time"
 
@@ -40,6 +45,15 @@ import (
 type ugbt struct {
 	// Core application flags
 	Timeout time.Duration `flag:"timeout" help:"set timeout for operations (0 for no timeout)."`
+	Config  string        `flag:"config" help:"path to the ugbt config file (default $UGBT_CONFIG or ugbt/config.json in the user config directory)."`
+	GOPROXY string        `flag:"goproxy" help:"module proxy list to use instead of the go env GOPROXY value."`
+	MaxAge  time.Duration `flag:"max-age" help:"use cached module version lists fetched within this duration without querying the proxy."`
+	Refresh bool          `flag:"refresh" help:"ignore cached module version lists."`
+	Bin     string        `flag:"bin" help:"directory to install executables to instead of the go env GOBIN directory."`
+	Debug   bool          `flag:"debug,d" help:"print debugging information to stderr."`
+
+	Concurrency int  `flag:"concurrency" help:"maximum number of concurrent module proxy queries."`
+	JSONErrors  bool `flag:"json-errors" help:"report a command failure as a JSON object on stderr."`
 	tool.Profile
 
 	// The name of the binary, used in help and telemetry.
@@ -50,6 +64,43 @@ type ugbt struct {
 
 	// The environment variables to use.
 	env []string
+
+	// client is the HTTP client used for all network
+	// requests so that connections are reused.
+	client *http.Client
+
+	// The user configuration.
+	config config
+
+	// goenvCache holds the go env variables
+	// obtained by goenv.
+	goenvMu    sync.Mutex
+	goenvCache map[string]string
+
+	// proxyWarning ensures that the absence of a usable
+	// proxy is only reported once.
+	proxyWarning sync.Once
+
+	// queried holds the paths of the executables whose
+	// build information has been read by the command,
+	// keyed by executable name, for update hints.
+	queried *queriedSet
+
+	// policy holds the team policy loaded by teamPolicy.
+	policyOnce sync.Once
+	policy     *policy
+	policyErr  error
+
+	// buildInfos caches the build information of
+	// executables read by exeBuildInfo.
+	buildInfos *buildInfoCache
+
+	// queries limits the number of concurrent module
+	// proxy queries to the -concurrency flag value.
+	//
+	// queried, buildInfos and queries are shared with
+	// the copies made by withGOBIN.
+	queries *queryLimit
 }
 
 // newUggboot returns a new ugbt ready to run.
@@ -61,10 +112,26 @@ func newUggboot(name, wd string, env []string) *ugbt {
 		name:    name,
 		wd:      wd,
 		env:     env,
+		client:  newClient(),
 		Timeout: 10 * time.Minute,
+
+		queried:    &queriedSet{},
+		buildInfos: &buildInfoCache{},
+		queries:    &queryLimit{},
+
+		Concurrency: runtime.NumCPU(),
 	}
 }
 
+// newClient returns the HTTP client used by ugbt. Proxies are configured
+// from the environment as for http.DefaultTransport, and enough idle
+// connections are kept for concurrent queries to each host to reuse them.
+func newClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = runtime.NumCPU()
+	return &http.Client{Transport: transport}
+}
+
 // Name implements tool.Application returning the binary name.
 func (u *ugbt) Name() string { return u.name }
 
@@ -73,21 +140,21 @@ func (*ugbt) Usage() string { return "<command> [command-flags] [command-args]"
 
 // ShortHelp implements tool.Application returning the main binary help.
 func (*ugbt) ShortHelp() string {
-	return "The Ugg boot tool."
+	return text("The Ugg boot tool.")
 }
 
 // DetailedHelp implements tool.Application returning the main binary help.
 // This includes the short help for all the sub commands.
 func (u *ugbt) DetailedHelp(f *flag.FlagSet) {
-	fmt.Fprint(f.Output(), `
+	fmt.Fprint(f.Output(), text(`
 Available commands are:
-`)
+`))
 	for _, c := range u.commands() {
 		fmt.Fprintf(f.Output(), "  %s: %v\n", c.Name(), c.ShortHelp())
 	}
-	fmt.Fprint(f.Output(), `
+	fmt.Fprint(f.Output(), text(`
 ugbt flags are:
-`)
+`))
 	f.PrintDefaults()
 }
 
@@ -95,19 +162,51 @@ ugbt flags are:
 // sub command as specified by the first argument.
 // If no arguments are passed it will invoke the server sub command, as a
 // temporary measure for compatibility.
+//
+// A failing command's error is classified by kind, which determines the
+// exit status.
 func (u *ugbt) Run(ctx context.Context, args ...string) error {
 	if len(args) == 0 {
-		return tool.Run(ctx, &help{}, args)
+		return tool.Run(ctx, &help{ugbt: u}, args)
+	}
+	return classify(args[0], u.run(ctx, args[0], args[1:]), u.JSONErrors)
+}
+
+// run runs the named command with the provided arguments.
+func (u *ugbt) run(ctx context.Context, command string, args []string) error {
+	var err error
+	u.config, err = loadConfig(u.Config)
+	if err != nil {
+		return err
+	}
+	if u.GOPROXY == "" {
+		u.GOPROXY = u.config.Proxy.GOPROXY
+	}
+	if u.Bin != "" {
+		// GOBIN must be absolute for go install.
+		bin := u.Bin
+		if !filepath.IsAbs(bin) {
+			bin = filepath.Join(u.wd, bin)
+		}
+		u.env = append(u.env[:len(u.env):len(u.env)], "GOBIN="+filepath.Clean(bin))
 	}
 	if u.Timeout > 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, u.Timeout)
 		defer cancel()
 	}
-	command, args := args[0], args[1:]
+	defer u.reportCrash(command)
 	for _, c := range u.commands() {
 		if c.Name() == command {
-			return tool.Run(ctx, c, args)
+			err = tool.Run(ctx, c, args)
+			if cerr := u.saveBuildInfoCache(); cerr != nil {
+				u.debugf("save build info cache: %v", cerr)
+			}
+			u.count(command, err)
+			if err == nil {
+				u.notify(ctx, os.Stderr, command)
+			}
+			return err
 		}
 	}
 	return tool.CommandLineErrorf("Unknown command %v", command)
@@ -119,12 +218,35 @@ func (u *ugbt) Run(ctx context.Context, args ...string) error {
 func (u *ugbt) commands() []tool.Application {
 	return []tool.Application{
 		&list{ugbt: u},
-		&install{ugbt: u},
-		&update{ugbt: u, PreRelease: "^$"},
+		&install{ugbt: u, BuildFlags: u.config.buildFlags()},
+		&update{ugbt: u, BuildFlags: u.config.buildFlags(), PreRelease: "^$", Toolchain: "auto"},
+		&outdated{ugbt: u, PreRelease: "^$"},
+		&stale{ugbt: u, BuildFlags: u.config.buildFlags()},
+		&rebuild{ugbt: u, BuildFlags: u.config.buildFlags()},
+		&prefetch{ugbt: u, PreRelease: "^$"},
 		&repo{ugbt: u},
 		&bugs{ugbt: u},
+		&download{ugbt: u},
+		&clone{ugbt: u},
+		&src{ugbt: u},
+		&trace{ugbt: u},
+		&retractions{ugbt: u},
+		&backups{ugbt: u},
+		&undo{ugbt: u},
+		&last{ugbt: u},
+		&sdk{ugbt: u},
+		&env{ugbt: u},
+		&bundle{ugbt: u, BuildFlags: u.config.buildFlags()},
+		&editor{ugbt: u, BuildFlags: u.config.buildFlags()},
+		&size{ugbt: u, BuildFlags: u.config.buildFlags()},
+		&stats{ugbt: u},
+		&prompt{ugbt: u, Format: "%d⇡", Interval: time.Hour},
+		&audit{ugbt: u, Severity: u.config.Vuln.Severity, FailSeverity: u.config.Vuln.FailSeverity, FailFixed: u.config.Vuln.FailFixed},
+		&verify{ugbt: u},
+		&doctor{ugbt: u, Probes: 5},
+		&telemetry{ugbt: u},
 		&version{ugbt: u},
-		&help{},
+		&help{ugbt: u},
 	}
 }
 
@@ -132,28 +254,112 @@ func (u *ugbt) commands() []tool.Application {
 type list struct {
 	*ugbt
 
-	All        bool   `flag:"all" help:"list all versions not just unretracted and newer than the installed executable"`
-	PreRelease string `flag:"suffix" help:"only print versions with a pre-release matching the regexp pattern"`
+	All        bool   `flag:"all,a" help:"list all versions not just unretracted and newer than the installed executable"`
+	PreRelease string `flag:"suffix,s" help:"only print versions with a pre-release matching the regexp pattern"`
+	Verify     bool   `flag:"verify" help:"mark versions recorded in the checksum database"`
+	N          int    `flag:"n" help:"print at most n versions, newest first"`
+	Since      string `flag:"since" help:"only print versions published at or after the date (2006-01-02 or RFC 3339)"`
+	Until      string `flag:"until" help:"only print versions published at or before the date (2006-01-02 or RFC 3339)"`
+	Page       bool   `flag:"page" help:"page the output through $PAGER when writing to a terminal"`
+	JSON       bool   `flag:"json" help:"print each version as a JSON object"`
+
+	ModulePrefix string `flag:"module-prefix" help:"print the latest version of each command module with the module path prefix"`
 }
 
-func (*list) Name() string      { return "list" }
-func (*list) Usage() string     { return "[/path/to/go/executable]" }
-func (*list) ShortHelp() string { return "runs the ugbt list command" }
+func (*list) Name() string  { return "list" }
+func (*list) Usage() string { return "[/path/to/go/executable]" }
+func (*list) ShortHelp() string {
+	return text("print a list of available versions for a Go executable")
+}
 func (*list) DetailedHelp(f *flag.FlagSet) {
-	fmt.Fprint(f.Output(), `
+	fmt.Fprint(f.Output(), text(`
 The list command prints a list of available versions for the queried
 executable including any retraction details. If the -all flag is given,
 all versions including versions older that the current executable are
 printed. If an executable path is not provided, ugbt will print ugbt
-version information.
+version information. When listing versions of the Go toolchain, releases
+that are already present locally as the go command's toolchain, as
+golang.org/dl wrappers or as SDKs in $HOME/sdk are marked as installed,
+and releases that include security fixes are marked as security releases.
+Versions of other modules whose source is already held in the module cache
+are marked as cached; installing them does not need a download.
 Executables in modules matching GOPRIVATE or GONOPROXY are not handled.
-
-`)
+If GOPROXY is off or direct, only the versions held in the module cache
+are listed. The -goproxy ugbt flag can be used to query a proxy instead.
+
+If the -verify flag is given, each listed version is looked up in the
+GOSUMDB checksum database and marked as verified if its hashes are
+recorded. When the version is also held in the module cache, the cached
+hashes are compared with the recorded hashes and any difference is marked
+as a checksum mismatch. Modules matching GONOSUMDB are not looked up.
+
+Versions are printed newest first, and the version information is only
+fetched for the versions that are printed, so the -n flag makes listing
+modules with long histories faster. If the -page flag is given and the
+output is a terminal, the output is written to $PAGER and the versions are
+fetched a page at a time as the pager reads them.
+
+The -since and -until flags restrict the output to versions published in a
+date range, for example to find the versions released since an audit. Dates
+are inclusive. Versions are not always published in version order, since
+fixes may be backported to older release branches, so the publication time
+of every candidate version is checked.
+
+If the -json flag is given, each version is printed as a JSON object on its
+own line with the fields "version", "time" and "retracted", and the
+"rationale" for retracted versions. The "installed", "cached", "security"
+and "checksum" fields hold the markings described above and are omitted
+when they do not apply.
+
+If the -module-prefix flag is given, no executable is provided and the
+latest version of each module with a path starting with the prefix that
+holds a main package is printed with the names of its commands, for
+example to derive a set of approved tool versions for an organisation.
+The modules are read from the prefix's entry in the "namespaces" section of
+the ugbt config if present, and otherwise discovered from the module index
+at index.golang.org. The index is read incrementally and the modules found
+are cached, so the first scan of a prefix reads the index from the -since
+date, or from a year ago, and later scans only read new entries.
+
+`))
 	f.PrintDefaults()
 }
 
+// parseDate parses the value of a -since or -until date flag, either a
+// date in the local time zone or an RFC 3339 time. If end is true, a date
+// is taken to mean the end of the day. The zero time is returned for an
+// empty value.
+func parseDate(flag, value string, end bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err == nil {
+		if end {
+			t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		return t, nil
+	}
+	t, err = time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, tool.CommandLineErrorf("invalid -%s date %q: must be 2006-01-02 or RFC 3339", flag, value)
+	}
+	return t, nil
+}
+
+// listPage is the number of versions fetched for each page of paged list
+// output.
+const listPage = 50
+
 // Run runs the ugbt list command.
 func (l *list) Run(ctx context.Context, args ...string) error {
+	if l.ModulePrefix != "" {
+		if l.JSON {
+			return tool.CommandLineErrorf("-json can not be used with -module-prefix")
+		}
+		return l.runNamespace(ctx, args...)
+	}
+
 	var exe string
 	switch len(args) {
 	case 0:
@@ -172,237 +378,1224 @@ func (l *list) Run(ctx context.Context, args ...string) error {
 	const defaultFormat = "_2 Jan 2006 15:04"
 	format := defaultFormat
 
-	_, mod, current, err := l.version(ctx, exe)
+	info, err := l.buildInfo(ctx, exe)
 	if err != nil {
 		return err
 	}
-	versions, err := l.availableVersions(ctx, mod, current, l.All)
+	_, mod, current, err := l.exeVersion(ctx, info, exe)
 	if err != nil {
 		return err
 	}
-	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.DiscardEmptyColumns)
-	var n int
-	for _, v := range versions {
-		if !l.All && semverCompare(v.Version, current) <= 0 {
-			break
+	warnReplaced(os.Stderr, exe, info)
+	warnModified(os.Stderr, exe, info)
+	var installed, cached map[string]bool
+	if mod == "std" {
+		installed, err = l.installedSDKs(ctx)
+	} else {
+		cached, err = l.cachedZips(ctx, mod)
+	}
+	if err != nil {
+		return err
+	}
+	var sum *sumDBChecker
+	if l.Verify && mod != "std" {
+		sum, err = l.sumDB(ctx)
+		if err != nil {
+			return err
 		}
-		if !l.All && v.isRetracted {
-			continue
+	}
+	filter := versionFilter{
+		keep: func(version string) bool {
+			if !l.All && semverCompare(version, current) <= 0 {
+				return false
+			}
+			return suffix.MatchString(semver.Prerelease(version))
+		},
+		unretracted: !l.All,
+		limit:       l.N,
+	}
+	filter.since, err = parseDate("since", l.Since, false)
+	if err != nil {
+		return err
+	}
+	filter.until, err = parseDate("until", l.Until, true)
+	if err != nil {
+		return err
+	}
+	dated := !filter.since.IsZero() || !filter.until.IsZero()
+	if dated {
+		// Versions outside the date range are only known
+		// after their metadata is fetched, so the limit
+		// is applied to the printed versions.
+		filter.limit = 0
+	}
+	var (
+		out   io.Writer = os.Stdout
+		paged bool
+	)
+	if l.Page && l.N == 0 {
+		pager, wait, err := l.pager()
+		if err != nil {
+			return err
 		}
-		if !suffix.MatchString(semver.Prerelease(v.Version)) {
-			continue
+		if pager != nil {
+			defer wait()
+			out, paged = pager, true
+			if !dated {
+				filter.limit = listPage
+			}
 		}
-		fmt.Fprintf(w, "%s", v.Version)
-		if !v.Time.IsZero() {
-			fmt.Fprintf(w, "\t%s", v.Time.Format(format))
+	}
+	var n int
+	for {
+		versions, err := l.availableVersions(ctx, mod, current, l.All, filter)
+		if err != nil {
+			return err
 		}
-		if v.isRetracted {
-			if v.retractionRationale != "" {
-				fmt.Fprintf(w, "\tretracted: %s", v.retractionRationale)
-			} else {
-				fmt.Fprint(w, "\tretracted")
+		if len(versions) == 0 {
+			break
+		}
+		w := tabwriter.NewWriter(out, 0, 8, 2, ' ', tabwriter.DiscardEmptyColumns)
+		for _, v := range versions {
+			if l.N > 0 && n == l.N {
+				break
+			}
+			if !l.All && semverCompare(v.Version, current) <= 0 {
+				break
+			}
+			if !l.All && v.isRetracted {
+				continue
+			}
+			if !suffix.MatchString(semver.Prerelease(v.Version)) {
+				continue
+			}
+			if l.JSON {
+				status, err := l.checksumStatus(ctx, sum, mod, v.Version)
+				if err != nil {
+					return err
+				}
+				err = writeVersionJSON(out, v, installed[v.Version], cached[v.Version], status)
+				if err != nil {
+					if paged {
+						// The user has quit the pager.
+						return nil
+					}
+					return err
+				}
+				n++
+				continue
+			}
+			fmt.Fprintf(w, "%s", v.Version)
+			if !v.Time.IsZero() {
+				fmt.Fprintf(w, "\t%s", v.Time.Format(format))
+			}
+			if installed[v.Version] {
+				fmt.Fprint(w, "\tinstalled")
+			}
+			if cached[v.Version] {
+				fmt.Fprint(w, "\tcached")
+			}
+			if v.isSecurity {
+				fmt.Fprint(w, "\tsecurity")
+			}
+			status, err := l.checksumStatus(ctx, sum, mod, v.Version)
+			if err != nil {
+				return err
 			}
+			if status != "" {
+				fmt.Fprintf(w, "\t%s", status)
+			}
+			if v.isRetracted {
+				if v.retractionRationale != "" {
+					fmt.Fprintf(w, "\tretracted: %s", v.retractionRationale)
+				} else {
+					fmt.Fprint(w, "\tretracted")
+				}
+			}
+			fmt.Fprintln(w)
+			n++
+		}
+		err = w.Flush()
+		if err != nil {
+			if paged {
+				// The user has quit the pager.
+				return nil
+			}
+			return err
+		}
+		if !paged || dated {
+			break
 		}
-		fmt.Fprintln(w)
-		n++
+		filter.offset += filter.limit
 	}
 	if n == 0 {
-		fmt.Fprintln(os.Stderr, "no new version")
+		fmt.Fprintln(os.Stderr, text("no new version"))
+	}
+	return nil
+}
+
+// listVersion is the JSON representation of a version printed by list.
+type listVersion struct {
+	Version   string     `json:"version"`
+	Time      *time.Time `json:"time,omitempty"`
+	Retracted bool       `json:"retracted"`
+	Rationale string     `json:"rationale,omitempty"`
+	Installed bool       `json:"installed,omitempty"`
+	Cached    bool       `json:"cached,omitempty"`
+	Security  bool       `json:"security,omitempty"`
+	Checksum  string     `json:"checksum,omitempty"`
+}
+
+// writeVersionJSON writes the version to w as a single line JSON object.
+// The installed, cached and checksum status parameters are the markings
+// of the version in the list output.
+func writeVersionJSON(w io.Writer, v info, installed, cached bool, status string) error {
+	j := listVersion{
+		Version:   v.Version,
+		Retracted: v.isRetracted,
+		Rationale: v.retractionRationale,
+		Installed: installed,
+		Cached:    cached,
+		Security:  v.isSecurity,
+		Checksum:  status,
+	}
+	if !v.Time.IsZero() {
+		j.Time = &v.Time
+	}
+	b, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+// pager returns a writer to the $PAGER command if the standard output is
+// a terminal, and a function that closes the writer and waits for the
+// pager to exit. If there is no pager to use, the returned writer is nil.
+func (l *list) pager() (io.Writer, func() error, error) {
+	args := strings.Fields(l.getenv("PAGER"))
+	if len(args) == 0 {
+		return nil, nil, nil
+	}
+	if !isTerminal(os.Stdout) {
+		return nil, nil, nil
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, err
 	}
-	return w.Flush()
+	err = cmd.Start()
+	if err != nil {
+		return nil, nil, fmt.Errorf("start pager: %w", err)
+	}
+	return in, func() error {
+		in.Close()
+		return cmd.Wait()
+	}, nil
 }
 
 // update implements the update command.
 type update struct {
 	*ugbt
 
-	PreRelease string `flag:"suffix" help:"only update to versions with a pre-release matching the regexp pattern"`
-	Verbose    bool   `flag:"v" help:"print the names of packages as they are compiled."`
-	Commands   bool   `flag:"x" help:"print the commands run by the go tool."`
-	DryRun     bool   `flag:"dry-run" help:"don't install anything, just print what would be installed."`
+	All          bool   `flag:"all,a" help:"update all Go executables in the install directory."`
+	PreRelease   string `flag:"suffix,s" help:"only update to versions with a pre-release matching the regexp pattern"`
+	DryRun       bool   `flag:"dry-run,n" help:"don't install anything, just print what would be installed."`
+	DownloadOnly bool   `flag:"download-only" help:"download the modules of all targets in parallel before building any of them."`
+	Follow       bool   `flag:"follow,f" help:"install the successor of a module that has moved to a new module path."`
+	Remove       bool   `flag:"remove" help:"remove Go SDKs that are superseded by an update."`
+	Summary      bool   `flag:"summary" help:"print a table of the results at the end instead of progress messages."`
+	SecurityOnly bool   `flag:"security-only" help:"only update executables when the new version fixes a known vulnerability."`
+	Toolchain    string `flag:"toolchain" help:"rebuild executables without a new version when built with an older Go: auto, ignore or always."`
+	Selection
+	BuildFlags
 }
 
 func (*update) Name() string      { return "update" }
-func (*update) Usage() string     { return "[/path/to/go/executable]" }
-func (*update) ShortHelp() string { return "runs the ugbt update command" }
+func (*update) Usage() string     { return "[-all | /path/to/go/executable ...]" }
+func (*update) ShortHelp() string { return text("update an executable to its latest release") }
 func (*update) DetailedHelp(f *flag.FlagSet) {
-	fmt.Fprint(f.Output(), `
-The update command updates the executable to the latest version matching
+	fmt.Fprint(f.Output(), text(`
+The update command updates the executables to the latest version matching
 the pre-release suffix pattern. If no newer version is available update
-is a no-op. By default it will update to the latest release. If no
-executable is specified ugbt will be updated.
-
-`)
+is a no-op. By default it will update to the latest release. If the -all
+flag is given, all Go executables in the install directory, the bin
+directories of the GOPATH elements and the "dirs" of the "scan" section of
+the ugbt config are updated. Executables in the GOPATH bin directories and
+the configured scan directories are updated in place, unless the ugbt -bin
+flag is given, in which case all updated executables are installed into the
+-bin directory. If no executable is specified ugbt will be updated.
+
+`+selectionHelp+`
+If the -summary flag is given, progress messages are not printed. Instead,
+a table is printed at the end giving for each executable whether it was
+updated, with its old and new versions, was already current, was skipped,
+or failed, with the reason. Failed updates do not stop the remaining
+updates and the command fails after printing the table. The table is also
+printed after the progress messages when the -all flag is given, and
+failures likewise do not stop the remaining updates. The results of
+updating more than one executable are recorded whether or not -summary is
+given, and the table can be printed again later with the last command.
+
+Executables that are asdf or mise version manager shims are resolved to
+the versioned executable that the shim runs with the version manager's
+which command, and the versioned executable is updated in place. The
+version manager may need to be told to reshim after the update.
+
+When more than one executable is updated, the -download-only flag can be
+used to fetch the source of all the modules needed to build the target
+versions before any of them are built, so that the network and compilation
+phases of the update do not interleave.
+
+If an executable has no newer version, update checks whether its module
+has been deprecated in favour of another module. If the module is
+deprecated or the -follow flag is given, update also checks whether its
+vanity import path now redirects to a different repository root. If the
+-follow flag is given, the same command from the successor module is
+installed provided that it has the same executable name. A failure to
+look up the successor is reported as a warning.
+
+Updating a Go toolchain or a golang.org/dl wrapper such as go1.22.1
+installs the newest patch release in the same minor release series. The
+gotip wrapper is updated to the current development tip. If the -remove
+flag is given, the wrapper and SDK of a superseded release are removed.
+
+If the "policy" section of the ugbt config names a team policy, versions
+that the policy disallows are not updated to, and installed versions that
+violate it are reported. See the audit command for the policy format.
+
+If the -security-only flag is given, an executable is only updated if the
+new version fixes a known vulnerability in the OSV database that affects
+the installed version, either in the executable's module or in a
+dependency whose version required by the new version is at or after the
+fixed version. Other executables are skipped. Vulnerability queries are
+cached as described for audit -vuln.
+
+The -toolchain flag controls whether executables without a newer version
+are rebuilt at their installed version. With "auto", the default, an
+executable is rebuilt if it was built with an older Go release than the go
+command, or than the release it is pinned to in the "tools" section of the
+ugbt config, so that it picks up the fixes in the newer standard library.
+With "always", every executable without a newer version is rebuilt, and
+with "ignore", none are. Since the version is already installed, its
+retraction or the deprecation of its module does not prevent the rebuild.
+Executables are not rebuilt with -security-only.
+
+`))
 	f.PrintDefaults()
 }
 
 // Run runs the ugbt update command.
 func (u *update) Run(ctx context.Context, args ...string) error {
-	var exe string
-	switch len(args) {
-	case 0:
+	exes := args
+	if u.All {
+		if len(args) != 0 {
+			return errors.New("update -all does not accept executable arguments")
+		}
+		var err error
+		exes, err = u.binExecutables(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	if len(exes) == 0 && !u.All {
 		// Work on ugbt.
-	case 1:
-		exe = args[0]
-	default:
-		return errors.New("update requires zero or one argument")
+		exes = []string{""}
 	}
-
-	suffix, err := regexp.Compile(u.PreRelease)
+	exes, err := u.selectExecutables(ctx, exes, u.Selection)
 	if err != nil {
 		return err
 	}
 
-	path, mod, current, err := u.version(ctx, exe)
+	suffix, err := regexp.Compile(u.PreRelease)
 	if err != nil {
 		return err
 	}
-	versions, err := u.availableVersions(ctx, mod, current, false)
-	if err != nil {
-		return err
+	switch u.Toolchain {
+	case "auto", "ignore", "always":
+	default:
+		return tool.CommandLineErrorf("invalid -toolchain value %q: must be auto, ignore or always", u.Toolchain)
 	}
-	for _, v := range versions {
-		if semverCompare(v.Version, current) <= 0 {
-			break
+
+	out := io.Writer(os.Stderr)
+	if u.Summary {
+		out = io.Discard
+	}
+	// Installs report their progress as the
+	// updates are made.
+	u.BuildFlags.progress = out
+	var (
+		targets []target
+		results []updateResult
+
+		// releases holds the names of the executables
+		// that are updated by installing each Go release
+		// so that a release is only installed once.
+		releases = make(map[string]string)
+	)
+	skip := func(name, format string, args ...interface{}) {
+		fprintf(out, format+"\n", args...)
+		results = append(results, updateResult{Name: name, Status: "skipped", Detail: fmt.Sprintf(format, args...)})
+	}
+	for _, exe := range exes {
+		name := exe
+		if name == "" {
+			name = "ugbt"
 		}
-		if v.isRetracted {
+		suffix := suffix
+		if tool, ok := u.tool(exe); ok && tool.Suffix != "" && u.PreRelease == "^$" {
+			// Use the tool's policy in place of the default.
+			suffix, err = regexp.Compile(tool.Suffix)
+			if err != nil {
+				return fmt.Errorf("invalid suffix for %s in config: %w", name, err)
+			}
+		}
+		t, ok, err := u.target(ctx, exe, suffix, out)
+		if err != nil {
+			if u.All || u.Summary {
+				fprintf(out, "skipping %s: %v\n", name, err)
+				results = append(results, updateResult{Name: name, Status: "failed", Detail: err.Error()})
+				continue
+			}
+			return err
+		}
+		t.name, t.exe = name, exe
+		if !ok && t.mod != "std" {
+			next, moved, err := u.successor(ctx, t, suffix)
+			if err != nil {
+				// The successor is advisory, so a failure to
+				// find it does not prevent reporting that
+				// there is no new version.
+				fprintf(out, "warning: could not look up the successor of %s: %v\n", name, err)
+			}
+			if moved {
+				if !u.Follow {
+					skip(name, "%s has moved to %s: use update -follow to install it", name, next.path)
+					continue
+				}
+				if u.SecurityOnly {
+					skip(name, "%s has moved to %s: not following with -security-only", name, next.path)
+					continue
+				}
+				if exeName(next.path) != exeName(t.path) {
+					skip(name, "%s has moved to %s but would be installed as %s: not following", name, next.path, exeName(next.path))
+					continue
+				}
+				fprintf(out, "update %s to %s@%s\n", name, next.path, next.version)
+				next.name, next.exe, next.current = name, exe, t.current
+				targets = append(targets, next)
+				continue
+			}
+		}
+		if !ok && t.mod != "std" && u.Toolchain != "ignore" && !u.SecurityOnly && semver.IsValid(t.current) && semver.Build(t.current) == "" {
+			if info, err := u.buildInfo(ctx, exe); err == nil {
+				built, want, stale := u.staleToolchain(ctx, info, t.path)
+				if stale || u.Toolchain == "always" {
+					t.version, t.rebuild, ok = t.current, want, true
+					if stale {
+						fprintf(out, "%s %s was built with %s\n", name, t.current, built)
+					}
+				}
+			}
+		}
+		if !ok {
+			if len(exes) == 1 {
+				fmt.Fprintln(out, text("no new version"))
+			} else {
+				fprintf(out, "no new version of %s\n", name)
+			}
+			results = append(results, updateResult{Name: name, Status: "current", Detail: t.current})
 			continue
 		}
-		if !suffix.MatchString(semver.Prerelease(v.Version)) {
+		if dir, ok := u.localSource(ctx, t.path); ok {
+			skip(name, "%s was installed from the local working copy in %s: use install to replace it with %s", name, dir, t.version)
 			continue
 		}
-		if exe == "" {
-			exe = "ugbt"
+		if o, ok := u.installOverrides(ctx, t.path); ok {
+			fprintf(out, "warning: %s was installed with %s: updating to %s drops the overrides\n", name, o, t.version)
 		}
-		fmt.Fprintf(os.Stderr, "update %s to %s\n", exe, v.Version)
-		if u.DryRun {
-			return nil
+		if u.SecurityOnly {
+			fixes, err := u.securityFixes(ctx, t)
+			if err != nil {
+				if u.All || u.Summary {
+					fprintf(out, "skipping %s: %v\n", name, err)
+					results = append(results, updateResult{Name: name, Status: "failed", Detail: err.Error()})
+					continue
+				}
+				return err
+			}
+			if len(fixes) == 0 {
+				skip(name, "%s %s fixes no known vulnerability in %s", name, t.version, t.current)
+				continue
+			}
+			fprintf(out, "%s %s fixes %s\n", name, t.version, strings.Join(fixes, ", "))
 		}
-		return u.install(ctx, path, mod, v.Version, u.Verbose, u.Commands)
+		if t.mod == "std" {
+			if first, ok := releases[t.version]; ok {
+				skip(name, "%s is updated with %s by installing %s", name, first, t.version)
+				continue
+			}
+			releases[t.version] = name
+		}
+		if t.rebuild != "" {
+			fprintf(out, "rebuild %s %s with %s\n", name, t.version, t.rebuild)
+		} else {
+			fprintf(out, "update %s to %s\n", name, t.version)
+		}
+		targets = append(targets, t)
 	}
-	fmt.Fprintln(os.Stderr, "no new version")
-	return nil
+	if u.DryRun || len(targets) == 0 {
+		for _, t := range targets {
+			results = append(results, updateResult{Name: t.name, Status: "available", Detail: t.change()})
+		}
+		return u.summarize(results)
+	}
+	if u.DownloadOnly {
+		err = u.download(ctx, targets, u.BuildFlags)
+		if err != nil {
+			return err
+		}
+	}
+	for _, t := range targets {
+		inst := u.ugbt
+		var (
+			resolved, manager string
+			shim              bool
+		)
+		if u.Bin == "" {
+			resolved, manager, shim = u.resolveShim(ctx, t.exe)
+		}
+		if shim {
+			// Update the executable run by the shim in place.
+			inst = u.withGOBIN(filepath.Dir(resolved))
+		} else if dir, ok := u.installDir(ctx, t.exe); ok {
+			// Update the executable in place.
+			inst = u.withGOBIN(dir)
+		}
+		flags := u.BuildFlags
+		if t.rebuild != "" {
+			flags = rebuildFlags(flags)
+		}
+		err = inst.install(ctx, t.path, t.mod, t.version, flags)
+		if err == nil && shim {
+			fprintf(out, "warning: %s is run by a shim managed by %s; run %s reshim if the shims need updating\n", resolved, manager, manager)
+		}
+		if err == nil && u.Remove && t.superseded != "" {
+			err = u.removeSDK(ctx, t.superseded)
+		}
+		if err != nil {
+			results = append(results, updateResult{Name: t.name, Status: "failed", Detail: t.change() + ": " + err.Error()})
+			if !u.Summary && !u.All {
+				// Keep the record of the updates made before
+				// the failure.
+				u.recordRun(results)
+				return err
+			}
+			continue
+		}
+		results = append(results, updateResult{Name: t.name, Status: "updated", Detail: t.change()})
+	}
+	return u.summarize(results)
 }
 
-func semverCompare(v, w string) int {
-	return semver.Compare(replacePrefix(v, "go", "v"), replacePrefix(w, "go", "v"))
+// updateResult is the outcome of updating an executable.
+type updateResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
 }
 
-func replacePrefix(s, old, new string) string {
-	if !strings.HasPrefix(s, old) {
-		return s
+// summarize records the results of a bulk update in the ugbt state and
+// prints the table of update results if the -summary or -all flag was
+// given. It returns an error if any update failed.
+func (u *update) summarize(results []updateResult) error {
+	err := u.recordRun(results)
+	if err != nil {
+		return fmt.Errorf("record state: %w", err)
+	}
+	if !u.Summary && !u.All {
+		return nil
+	}
+	failed, err := writeResults(os.Stdout, results)
+	if err != nil {
+		return err
+	}
+	switch failed {
+	case 0:
+		return nil
+	case 1:
+		return errors.New("1 update failed")
+	default:
+		return fmt.Errorf("%d updates failed", failed)
 	}
-	return new + strings.TrimPrefix(s, old)
 }
 
-// install implements the install command.
-type install struct {
-	*ugbt
-
-	Verbose  bool `flag:"v" help:"print the names of packages as they are compiled."`
-	Commands bool `flag:"x" help:"print the commands run by the go tool."`
+// recordRun records the results of a bulk update in the ugbt state so
+// that they can be printed by the last command. Single executable updates
+// and dry runs are not recorded.
+func (u *update) recordRun(results []updateResult) error {
+	if u.DryRun || (!u.All && len(results) < 2) {
+		return nil
+	}
+	s, err := loadState()
+	if err != nil {
+		return err
+	}
+	s.LastUpdate = &updateRun{Time: time.Now().UTC(), Results: results}
+	return s.save()
 }
 
-func (*install) Name() string      { return "install" }
-func (*install) Usage() string     { return "[/path/to/go/executable] <version>" }
-func (*install) ShortHelp() string { return "runs the ugbt install command" }
-func (*install) DetailedHelp(f *flag.FlagSet) {
-	fmt.Fprint(f.Output(), `
-The install command reinstalls the executable at the provided path using
-go install. Any valid version may be used including "latest". See 'go help get'.
-If an executable path is not provided, ugbt will install the ugbt command
-at the requested version.
-
-If the executable is in the standard library, a golang.org/x/dl tool will
-be used to download the SDK. When installing the SDK, "latest" refers to the
-latest release. The "gotip" version will install the current development tip.
-
-`)
-	f.PrintDefaults()
+// writeResults writes a table of the update results to w, ordered by
+// status, and returns the number of failed updates.
+func writeResults(w io.Writer, results []updateResult) (failed int, err error) {
+	rank := map[string]int{"available": 0, "updated": 0, "current": 1, "skipped": 2, "failed": 3}
+	sort.SliceStable(results, func(i, j int) bool {
+		return rank[results[i].Status] < rank[results[j].Status]
+	})
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	for _, r := range results {
+		if r.Status == "failed" {
+			failed++
+		}
+		// Only show the first line of multi-line errors.
+		detail, _, _ := strings.Cut(r.Detail, "\n")
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Status, exeBase(r.Name), detail)
+	}
+	return failed, tw.Flush()
 }
 
-// Run runs the ugbt install command.
-func (i *install) Run(ctx context.Context, args ...string) error {
-	var exe, version string
-	switch len(args) {
-	case 1:
-		version = args[0]
-	case 2:
-		exe = args[0]
-		version = args[1]
-	default:
-		return errors.New("install requires one or two arguments")
+// localSource returns the directory of the local working copy that the
+// installed executable for the package was built from, if it was built
+// from a local working copy.
+func (u *ugbt) localSource(ctx context.Context, pkg string) (dir string, ok bool) {
+	dst, err := u.installPath(ctx, pkg)
+	if err != nil {
+		return "", false
 	}
-
-	path, mod, _, err := i.version(ctx, exe)
+	s, err := loadState()
 	if err != nil {
-		return err
+		return "", false
 	}
-	return i.install(ctx, path, mod, version, i.Verbose, i.Commands)
+	inst, ok := s.Installed[dst]
+	return inst.Local, ok && inst.Local != ""
 }
 
-// repo implements the repo command.
-type repo struct {
-	*ugbt
+// target is an update target.
+type target struct {
+	path    string // path is the package path of the executable.
+	mod     string // mod is the module path of the executable.
+	version string // version is the version to install.
+	current string // current is the installed version.
 
-	Open bool `flag:"o" help:"open the repo url in a browser instead of printing it."`
-}
+	// name is the name used for the executable in messages
+	// and exe is the path of the executable given to update.
+	name string
+	exe  string
 
-func (*repo) Name() string      { return "repo" }
-func (*repo) Usage() string     { return "[/path/to/go/executable]" }
-func (*repo) ShortHelp() string { return "runs the ugbt repo command" }
-func (*repo) DetailedHelp(f *flag.FlagSet) {
-	fmt.Fprint(f.Output(), `
-The repo command prints the source repo URL for the executable. If an
-executable path is not provided, ugbt will print the ugbt repo.
+	// superseded is the Go release of a golang.org/dl wrapper
+	// that is replaced by the target.
+	superseded string
 
-`)
-	f.PrintDefaults()
+	// rebuild is the Go release that the installed version
+	// is rebuilt with when it is not being updated.
+	rebuild string
 }
 
-// Run runs the ugbt repo command.
-func (r *repo) Run(ctx context.Context, args ...string) error {
-	var exe string
-	switch len(args) {
-	case 0:
-		// Work on ugbt.
-	case 1:
-		exe = args[0]
-	default:
-		return errors.New("repo requires zero or one argument")
+// change returns a description of the version change of the target.
+func (t target) change() string {
+	if t.rebuild != "" {
+		return t.current + " rebuilt with " + t.rebuild
 	}
-
-	_, mod, _, err := r.version(ctx, exe)
+	if t.current == "" {
+		return t.version
+	}
+	return t.current + " -> " + t.version
+}
+
+// target returns the newest unretracted version of the executable that
+// is newer than the installed version and has a pre-release matching
+// suffix. If no such version exists, ok is false and the returned target
+// holds the package and module paths and the installed version of the
+// executable with no version. Warnings are written to w.
+func (u *update) target(ctx context.Context, exe string, suffix *regexp.Regexp, w io.Writer) (t target, ok bool, err error) {
+	info, err := u.buildInfo(ctx, exe)
+	if err != nil {
+		return target{}, false, err
+	}
+	path, mod, current, err := u.exeVersion(ctx, info, exe)
+	if err != nil {
+		return target{}, false, err
+	}
+	var superseded string
+	if release, ok := dlRelease(path, mod); ok {
+		// Update the SDK of a golang.org/dl wrapper rather
+		// than the wrapper itself.
+		if release == "gotip" {
+			return target{path: path, mod: "std", version: release, current: release}, true, nil
+		}
+		mod, current, superseded = "std", release, release
+	}
+	warnReplaced(w, exe, info)
+	warnModified(w, exe, info)
+	versions, err := u.availableVersions(ctx, mod, current, false, preRelease(suffix))
+	if err != nil {
+		return target{}, false, err
+	}
+	if mod == "std" {
+		warnSecurity(w, current, versions)
+	}
+	pol, err := u.teamPolicy(ctx)
+	if err != nil {
+		return target{}, false, err
+	}
+	tp, restricted := pol.lookup(exeBase(exe), path, mod)
+	if restricted && semver.IsValid(current) {
+		if v := tp.violation(current); v != "" {
+			fmt.Fprintf(w, "%s %s violates the team policy: %s\n", exeName(path), current, v)
+		}
+	}
+	var blocked, disallowed bool
+	for _, v := range versions {
+		if semverCompare(v.Version, current) <= 0 {
+			break
+		}
+		if v.isRetracted {
+			continue
+		}
+		if !suffix.MatchString(semver.Prerelease(v.Version)) {
+			continue
+		}
+		if mod == "std" && goMinor(v.Version) != goMinor(current) {
+			continue
+		}
+		if restricted {
+			if reason := tp.violation(v.Version); reason != "" {
+				if !disallowed {
+					fmt.Fprintf(w, "%s %s is not allowed by the team policy: %s\n", exeName(path), v.Version, reason)
+					disallowed = true
+				}
+				continue
+			}
+		}
+		if pin := u.pin(path); pin != "" && mod != "std" {
+			need, err := u.goDirective(ctx, mod, v.Version)
+			if err == nil && need != "" && semver.Compare(goSemver(pin), goSemver(need)) < 0 {
+				if !blocked {
+					fmt.Fprintf(w, "%s %s requires go >= %s but is pinned to %s\n", exeName(path), v.Version, need, pin)
+					blocked = true
+				}
+				continue
+			}
+		}
+		return target{path: path, mod: mod, version: v.Version, current: current, superseded: superseded}, true, nil
+	}
+	return target{path: path, mod: mod, current: current}, false, nil
+}
+
+// successor returns the target for the command in t in the module that
+// has replaced the module of t, either by a deprecation notice pointing
+// to the new module, or by a vanity import redirection. The vanity import
+// server is only queried if the module is deprecated or the -follow flag
+// is given. If the module has not moved, ok is false.
+func (u *update) successor(ctx context.Context, t target, suffix *regexp.Regexp) (next target, ok bool, err error) {
+	deprecated, err := u.deprecation(ctx, t.mod)
+	if err != nil {
+		return target{}, false, err
+	}
+	mod := successorFrom(deprecated)
+	if mod == "" {
+		if deprecated == "" && !u.Follow {
+			// Only query the module's vanity import
+			// server if the successor is wanted or the
+			// module is known to be abandoned.
+			return target{}, false, nil
+		}
+		mod, ok, err = modrepo.Successor(ctx, doFunc(u.do), t.mod)
+		if err != nil || !ok {
+			return target{}, false, err
+		}
+	}
+	if mod == t.mod {
+		return target{}, false, nil
+	}
+	next = target{path: mod + strings.TrimPrefix(t.path, t.mod), mod: mod}
+	versions, err := u.availableVersions(ctx, mod, "", true, preRelease(suffix))
+	if err != nil {
+		return target{}, false, err
+	}
+	for _, v := range versions {
+		if v.isRetracted {
+			continue
+		}
+		if !suffix.MatchString(semver.Prerelease(v.Version)) {
+			continue
+		}
+		next.version = v.Version
+		return next, true, nil
+	}
+	return target{}, false, withKind(kindNotFound, fmt.Errorf("no suitable version of %s", mod))
+}
+
+// useModule matches a module path pointer in a deprecation notice.
+var useModule = regexp.MustCompile("(?i)\\buse\\s+`?([^\\s`]+)")
+
+// successorFrom returns the module path pointed to by a deprecation
+// notice of the form "use other/module", or the empty string if there
+// is no valid module path.
+func successorFrom(deprecated string) string {
+	m := useModule.FindStringSubmatch(deprecated)
+	if m == nil {
+		return ""
+	}
+	mod := strings.TrimRight(m[1], ".,;:)'\"")
+	if module.CheckPath(mod) != nil {
+		return ""
+	}
+	return mod
+}
+
+// exeName returns the name of the executable that go install builds for
+// the package path.
+func exeName(pkg string) string {
+	_, elem := path.Split(pkg)
+	if elem != pkg && isVersionElement(elem) {
+		// Commands in major version subdirectories such as
+		// example.com/mycmd/v2 are installed as mycmd.
+		_, elem = path.Split(path.Dir(pkg))
+	}
+	return elem
+}
+
+// isVersionElement reports whether s is a major version path element
+// greater than v1.
+func isVersionElement(s string) bool {
+	if len(s) < 2 || s[0] != 'v' || s[1] == '0' || s == "v1" {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if s[i] < '0' || '9' < s[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func semverCompare(v, w string) int {
+	return semver.Compare(replacePrefix(v, "go", "v"), replacePrefix(w, "go", "v"))
+}
+
+func replacePrefix(s, old, new string) string {
+	if !strings.HasPrefix(s, old) {
+		return s
+	}
+	return new + strings.TrimPrefix(s, old)
+}
+
+// install implements the install command.
+type install struct {
+	*ugbt
+
+	Same bool   `flag:"same" exclusive:"source" help:"install the vcs revision recorded in the executable instead of a version."`
+	From string `flag:"from" exclusive:"source" help:"install from the local working copy in the directory instead of a version."`
+
+	Replace string `flag:"replace" help:"comma-separated old[@v]=new[@v] dependency replacements to build with."`
+	Require string `flag:"require" help:"comma-separated mod@v dependency versions to build with."`
+	BuildFlags
+}
+
+// BuildFlags holds the flags that control how executables are built and
+// which versions may be installed. Default values for TrimPath and Strip
+// are taken from the install section of the ugbt config.
+type BuildFlags struct {
+	Verbose  bool `flag:"v" help:"print the names of packages as they are compiled."`
+	Commands bool `flag:"x" help:"print the commands run by the go tool."`
+	TrimPath bool `flag:"trimpath" help:"remove all file system paths from the resulting executable."`
+	Strip    bool `flag:"strip" help:"omit the symbol table and debug information from the executable (-ldflags='-s -w')."`
+
+	AllowRetracted  bool `flag:"allow-retracted" help:"allow installing a retracted version."`
+	AllowDeprecated bool `flag:"allow-deprecated" help:"allow installing a version of a deprecated module."`
+
+	KeepQuarantine bool `flag:"keep-quarantine" help:"on macOS, don't remove the quarantine attribute from installed executables."`
+	NoCodesign     bool `flag:"no-codesign" help:"on macOS, don't ad-hoc sign installed executables."`
+
+	Sandbox string `flag:"sandbox" help:"build in a sandbox with an isolated home directory and module cache (env or bwrap)."`
+	Probe   bool   `flag:"probe" help:"run a health probe on the installed executable and restore the backup if it fails."`
+
+	RequireSumDB bool `flag:"require-sumdb" help:"fail instead of installing without checksum database verification when the database is unreachable."`
+
+	// overrides are the dependency overrides to build with.
+	overrides *overrides
+
+	// progress, if not nil, receives the progress
+	// messages and go command output of installs in
+	// place of os.Stderr.
+	progress io.Writer
+}
+
+// progressWriter returns the writer for install progress messages.
+func (f BuildFlags) progressWriter() io.Writer {
+	if f.progress == nil {
+		return os.Stderr
+	}
+	return f.progress
+}
+
+// installArgs returns the go install command arguments for the flags.
+func (f BuildFlags) installArgs() []string {
+	args := []string{"install"}
+	if f.Verbose {
+		args = append(args, "-v")
+	}
+	if f.Commands {
+		args = append(args, "-x")
+	}
+	if f.TrimPath {
+		args = append(args, "-trimpath")
+	}
+	if f.Strip {
+		args = append(args, "-ldflags=-s -w")
+	}
+	return args
+}
+
+// buildFlags returns the default build flags held by the config.
+func (c config) buildFlags() BuildFlags {
+	return BuildFlags{
+		TrimPath: c.Install.TrimPath,
+		Strip:    c.Install.Strip,
+		Sandbox:  c.Install.Sandbox,
+		Probe:    c.Install.Probe,
+	}
+}
+
+func (*install) Name() string      { return "install" }
+func (*install) Usage() string     { return "[/path/to/go/executable] [<version>]" }
+func (*install) ShortHelp() string { return text("install an executable from its recorded source") }
+func (*install) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The install command reinstalls the executable at the provided path using
+go install. Any valid version may be used including "latest". See 'go help get'.
+If an executable path is not provided, ugbt will install the ugbt command
+at the requested version.
+
+If the executable is in the standard library, a golang.org/x/dl tool will
+be used to download the SDK. When installing the SDK, "latest" refers to the
+latest release. The "gotip" version will install the current development tip.
+Release archives are verified against the SHA-256 checksums published at
+https://go.dev/dl before they are unpacked by the golang.org/x/dl tool.
+
+If the -same flag is given, no version is provided and the executable is
+rebuilt from the vcs revision recorded in its build settings, installed as
+a pseudo-version. This allows executables built from a fork or an unpushed
+tag to be reproduced when their module version can not be resolved.
+
+If the -from flag is given, no version is provided and the executable is
+built from the local working copy in the provided directory. The install
+is recorded as locally sourced, and the update command will not replace
+the executable until it is installed from a module version again.
+
+The -replace and -require flags build the executable with patched
+dependencies, for example to pick up a fix before the executable's module
+releases a version that requires it. Each takes a comma-separated list of
+old[@v]=new[@v] replacements or mod@v requirements, as with the replace and
+require directives of a go.mod file; a replacement without a version is a
+local directory. The executable is built from a temporary module that
+requires the package at the requested version with the overrides applied.
+The overrides are recorded in the ugbt state, and the update command warns
+that they are dropped when it installs a newer version.
+
+Before building, the requested version is checked against the retractions
+and deprecation notice of the module, and the install fails unless the
+-allow-retracted or -allow-deprecated flag is given. A deprecated module
+only prevents installing an executable that is not already installed;
+replacing an installed executable prints a warning. The go directive of
+the module at the requested version is also compared with the version of
+the go command. If the module requires a newer Go and the go command can
+not switch toolchains itself because it is older than go1.21 or GOTOOLCHAIN
+is local, the install fails without building.
+After installing, a line giving the installed executable's path, the old and
+new versions, the Go version it was built with, the time taken and whether
+it is the executable found in PATH is printed. A warning is also printed if
+the install directory is not in PATH or if another executable with the same
+name is found earlier in PATH.
+
+The default values of the -trimpath and -strip flags are taken from the
+"trimpath" and "strip" fields of the "install" section of the ugbt config.
+
+If the -sandbox flag is given, go install is run with a temporary home
+directory, GOPATH, module cache and build cache, so that building does not
+read or alter the user's files and caches. The go env settings that select
+proxies and checksum databases are passed into the sandbox. With "env", the
+isolation is only through the environment of the go command. With "bwrap",
+the go command is also run in a bubblewrap container in which the user's
+home directory is hidden and the rest of the file system is read-only, apart
+from the install directory. Sandboxed builds do not share the build cache,
+so they are slower. The default is taken from the "sandbox" field of the
+"install" section of the ugbt config.
+
+If the executable's entry in the "tools" section of the ugbt config has a
+"go" field, the executable is built with that Go release using its
+golang.org/dl wrapper, which is installed first if necessary, and the go
+command is not allowed to switch toolchains. Versions requiring a newer Go
+than the pinned release are not installed, and update reports them.
+
+If the -probe flag is given, the installed executable is run with the -h
+flag, or with the arguments in the "probe" field of its entry in the "tools"
+section of the ugbt config, and if it fails, crashes or does not exit within
+10 seconds, the replaced executable is restored from its backup and the
+install fails. Backups must be enabled for the executable to be restored.
+The default is taken from the "probe" field of the "install" section of the
+ugbt config.
+
+If the checksum database can not be reached while the module proxy can, as
+behind some firewalls, the install is retried with the module excluded from
+checksum database verification through GONOSUMDB and a warning saying what
+was not verified is printed. The module's dependencies are still checked
+against its go.sum file. The -require-sumdb flag makes the install fail
+instead.
+
+On macOS, the quarantine attribute is removed from the installed executable
+and the executable is ad-hoc signed with codesign so that Gatekeeper and
+hardened runtime environments allow it to run. The -keep-quarantine and
+-no-codesign flags disable these steps.
+
+`))
+	f.PrintDefaults()
+}
+
+// Run runs the ugbt install command.
+func (i *install) Run(ctx context.Context, args ...string) error {
+	if (i.Replace != "" || i.Require != "") && (i.Same || i.From != "") {
+		return tool.CommandLineErrorf("-replace and -require can not be used with -same or -from")
+	}
+	if i.Same {
+		return i.runSame(ctx, args...)
+	}
+	if i.From != "" {
+		return i.runFrom(ctx, args...)
+	}
+
+	var exe, version string
+	switch len(args) {
+	case 1:
+		version = args[0]
+	case 2:
+		exe = args[0]
+		version = args[1]
+	default:
+		return errors.New("install requires one or two arguments")
+	}
+
+	path, mod, _, err := i.version(ctx, exe)
+	if err != nil {
+		return err
+	}
+	flags := i.BuildFlags
+	flags.overrides, err = parseOverrides(i.Replace, i.Require, i.wd)
+	if err != nil {
+		return tool.CommandLineErrorf("%v", err)
+	}
+	if flags.overrides != nil && mod == "std" {
+		return errors.New("install -replace and -require are not supported for the standard library")
+	}
+	return i.install(ctx, path, mod, version, flags)
+}
+
+// runFrom runs the ugbt install command with the -from flag.
+func (i *install) runFrom(ctx context.Context, args ...string) error {
+	var exe string
+	switch len(args) {
+	case 0:
+		// Work on ugbt.
+	case 1:
+		exe = args[0]
+	default:
+		return errors.New("install -from requires zero or one argument")
+	}
+
+	path, mod, _, err := i.version(ctx, exe)
+	if err != nil {
+		return err
+	}
+	if mod == "std" {
+		return errors.New("install -from is not supported for the standard library")
+	}
+	return i.installLocal(ctx, path, i.From, i.BuildFlags)
+}
+
+// runSame runs the ugbt install command with the -same flag.
+func (i *install) runSame(ctx context.Context, args ...string) error {
+	var exe string
+	switch len(args) {
+	case 0:
+		// Work on ugbt.
+	case 1:
+		exe = args[0]
+	default:
+		return errors.New("install -same requires zero or one argument")
+	}
+
+	info, err := i.buildInfo(ctx, exe)
+	if err != nil {
+		return err
+	}
+	path, mod, _, err := i.exeVersion(ctx, info, exe)
+	if err != nil {
+		return err
+	}
+	if mod == "std" {
+		return errors.New("install -same is not supported for the standard library")
+	}
+	version, err := sameVersion(info)
+	if err != nil {
+		return err
+	}
+	fprintf(os.Stderr, "install %s at %s\n", path, version)
+	return i.install(ctx, path, mod, version, i.BuildFlags)
+}
+
+// repo implements the repo command.
+type repo struct {
+	*ugbt
+
+	Open bool `flag:"o" help:"open the repo url in a browser instead of printing it."`
+	JSON bool `flag:"json" help:"print the module's repository information as JSON."`
+
+	Health bool `flag:"health" help:"show the deps.dev OpenSSF Scorecard score and advisories for the module."`
+}
+
+func (*repo) Name() string  { return "repo" }
+func (*repo) Usage() string { return "[/path/to/go/executable]" }
+func (*repo) ShortHelp() string {
+	return text("print the source code repository URL for the executable")
+}
+func (*repo) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The repo command prints the source repo URL for the executable. If an
+executable path is not provided, ugbt will print the ugbt repo.
+
+The -json flag prints the module path, the repo and issues URLs, the kind of
+forge hosting the repo and the repo's clone URLs as a JSON object. The forge
+kind and clone URLs are omitted if they are not known. The bugs command
+accepts the same flag.
+
+The -health flag adds the OpenSSF Scorecard score of the repository and the
+security advisories affecting the installed version of the module as
+reported by deps.dev, giving a signal of the module's maintenance health.
+
+`))
+	f.PrintDefaults()
+}
+
+// Run runs the ugbt repo command.
+func (r *repo) Run(ctx context.Context, args ...string) error {
+	var exe string
+	switch len(args) {
+	case 0:
+		// Work on ugbt.
+	case 1:
+		exe = args[0]
+	default:
+		return errors.New("repo requires zero or one argument")
+	}
+
+	_, mod, version, err := r.version(ctx, exe)
 	if err != nil {
 		return err
 	}
-	url, _, err := modrepo.URL(ctx, mod)
+	var h *health
+	if r.Health {
+		v, err := r.moduleHealth(ctx, mod, version)
+		if err != nil {
+			return err
+		}
+		h = &v
+	}
+	if r.JSON {
+		return r.writeRepoInfo(ctx, os.Stdout, mod, h)
+	}
+	url, _, err := modrepo.URL(ctx, doFunc(r.do), mod)
 	if err != nil {
 		return err
 	}
 	if !r.Open || !browser.Open(url) {
 		fmt.Println(url)
 	}
+	if h != nil {
+		if h.Project != "" {
+			fmt.Printf("scorecard %s for %s\n", h.scorecard(), h.Project)
+		}
+		fmt.Printf("%s affecting %s\n", formatAdvisories(h.Advisories), version)
+	}
 	return nil
 }
 
+// repoInfo is the repository information printed by the repo and bugs
+// commands with the -json flag.
+type repoInfo struct {
+	Module string  `json:"module"`
+	Repo   string  `json:"repo"`
+	Issues string  `json:"issues"`
+	Forge  string  `json:"forge,omitempty"`
+	HTTPS  string  `json:"https_clone,omitempty"`
+	SSH    string  `json:"ssh_clone,omitempty"`
+	Health *health `json:"health,omitempty"`
+}
+
+// writeRepoInfo writes the repository information for the module mod to w
+// as JSON, including the health signal h if it is not nil.
+func (u *ugbt) writeRepoInfo(ctx context.Context, w io.Writer, mod string, h *health) error {
+	repo, bugs, err := modrepo.URL(ctx, doFunc(u.do), mod)
+	if err != nil {
+		return err
+	}
+	info := repoInfo{Module: mod, Repo: repo, Issues: bugs, Health: h}
+	remote, err := modrepo.CloneURLs(ctx, doFunc(u.do), mod)
+	if err != nil {
+		u.debugf("no clone URLs for %s: %v", mod, err)
+	} else {
+		info.Forge, info.HTTPS, info.SSH = remote.Forge, remote.HTTPS, remote.SSH
+	}
+	b, err := json.MarshalIndent(info, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
 // bugs implements the bugs command.
 type bugs struct {
 	*ugbt
 
-	Open bool `flag:"o" help:"open the issues url in a browser instead of printing it."`
+	Open   bool   `flag:"o" help:"open the issues url in a browser instead of printing it."`
+	Attach string `flag:"attach" help:"prefill a new ugbt issue with the crash report at the path."`
+	JSON   bool   `flag:"json" help:"print the module's repository information as JSON."`
 }
 
 func (*bugs) Name() string      { return "bugs" }
 func (*bugs) Usage() string     { return "[/path/to/go/executable]" }
-func (*bugs) ShortHelp() string { return "runs the ugbt bugs command" }
+func (*bugs) ShortHelp() string { return text("print the issues URL for the executable") }
 func (*bugs) DetailedHelp(f *flag.FlagSet) {
-	fmt.Fprint(f.Output(), `
+	fmt.Fprint(f.Output(), text(`
 The bugs command prints the URL for issues for the executable. If an executable
 path is not provided, ugbt will print the ugbt bugs. If the issues URL is not
 known, the source repo URL is printed.
 
-`)
+When ugbt crashes it writes a crash report holding the stack, the ugbt version
+and the Go environment, with credentials and the home directory removed. The
+-attach flag takes the path of a report and prints or opens a URL for a new
+ugbt issue prefilled with the report. The report should be checked before the
+issue is submitted.
+
+The -json flag prints the module's repository information as described in
+the repo command's help.
+
+`))
 	f.PrintDefaults()
 }
 
@@ -417,15 +1610,30 @@ func (b *bugs) Run(ctx context.Context, args ...string) error {
 	default:
 		return errors.New("bugs requires zero or one argument")
 	}
+	if b.Attach != "" && exe != "" {
+		return errors.New("bugs -attach only reports ugbt crashes")
+	}
 
 	_, mod, _, err := b.version(ctx, exe)
 	if err != nil {
 		return err
 	}
-	_, url, err := modrepo.URL(ctx, mod)
+	if b.JSON {
+		if b.Attach != "" {
+			return tool.CommandLineErrorf("bugs -json can not be used with -attach")
+		}
+		return b.writeRepoInfo(ctx, os.Stdout, mod, nil)
+	}
+	_, url, err := modrepo.URL(ctx, doFunc(b.do), mod)
 	if err != nil {
 		return err
 	}
+	if b.Attach != "" {
+		url, err = newIssueURL(url, b.Attach)
+		if err != nil {
+			return err
+		}
+	}
 	if !b.Open || !browser.Open(url) {
 		fmt.Println(url)
 	}
@@ -442,7 +1650,7 @@ type version struct {
 
 func (*version) Name() string      { return "version" }
 func (*version) Usage() string     { return "" }
-func (*version) ShortHelp() string { return "print the ugbt version information" }
+func (*version) ShortHelp() string { return text("print the ugbt version information") }
 func (*version) DetailedHelp(f *flag.FlagSet) {
 	f.PrintDefaults()
 }
@@ -478,23 +1686,113 @@ func printModuleInfo(w io.Writer, m *debug.Module) {
 }
 
 // help implements the help command.
-type help struct{}
+type help struct {
+	*ugbt
+
+	// Markdown is a hidden flag used to generate
+	// the command reference documentation.
+	Markdown string `flag:"markdown" help:"write markdown command reference pages to the directory."`
+}
 
 func (*help) Name() string      { return "help" }
-func (*help) Usage() string     { return "" }
-func (*help) ShortHelp() string { return "output ugbt help information" }
+func (*help) Usage() string     { return "[command]" }
+func (*help) ShortHelp() string { return text("output ugbt help information") }
 func (*help) DetailedHelp(f *flag.FlagSet) {
-	f.PrintDefaults()
+	visible := flag.NewFlagSet(f.Name(), flag.ContinueOnError)
+	visible.SetOutput(f.Output())
+	f.VisitAll(func(fl *flag.Flag) {
+		if fl.Name != "markdown" {
+			visible.Var(fl.Value, fl.Name, fl.Usage)
+		}
+	})
+	visible.PrintDefaults()
 }
 
-// Run outputs the help text.
-func (*help) Run(ctx context.Context, args ...string) error {
-	fmt.Fprintf(os.Stdout, "%s", helpText)
+// Run outputs the help text, or the help for the command named by args.
+func (h *help) Run(ctx context.Context, args ...string) error {
+	if h.Markdown != "" {
+		return h.writeMarkdown(h.Markdown)
+	}
+	if len(args) != 0 {
+		return h.commandHelp(os.Stdout, args)
+	}
+	h.writeHelp(os.Stdout)
 	return nil
 }
 
-const helpText = `
-The Ugg boot tool.
+// writeHelp writes the top-level help text with the commands from the
+// command registry to w.
+func (h *help) writeHelp(w io.Writer) {
+	fmt.Fprint(w, text(helpHeader))
+	for _, c := range h.commands() {
+		fmt.Fprintf(w, "\n%s\n", wrapHelp("  "+c.Name()+": ", c.ShortHelp(), 72))
+	}
+	fmt.Fprint(w, text(helpFooter))
+}
+
+// wrapHelp returns the text prefixed by prefix and wrapped at width,
+// with continuation lines indented to the end of the prefix.
+func wrapHelp(prefix, text string, width int) string {
+	var (
+		b      strings.Builder
+		indent = strings.Repeat(" ", len(prefix))
+		n      = len(prefix)
+	)
+	b.WriteString(prefix)
+	for i, word := range strings.Fields(text) {
+		if i != 0 {
+			if n+1+len(word) > width {
+				b.WriteString("\n" + indent)
+				n = len(indent)
+			} else {
+				b.WriteByte(' ')
+				n++
+			}
+		}
+		b.WriteString(word)
+		n += len(word)
+	}
+	return b.String()
+}
+
+// commandHelp writes the detailed help and flags of the command named by
+// args to w. Sub commands are named by the command followed by the sub
+// command name.
+func (h *help) commandHelp(w io.Writer, args []string) error {
+	var (
+		app  tool.Application
+		cmds = h.commands()
+	)
+	for i, name := range args {
+		app = nil
+		for _, c := range cmds {
+			if c.Name() == name {
+				app = c
+				break
+			}
+		}
+		if app == nil {
+			return tool.CommandLineErrorf("Unknown command %v", strings.Join(args[:i+1], " "))
+		}
+		cmds = nil
+		if sub, ok := app.(interface{ commands() []tool.Application }); ok {
+			cmds = sub.commands()
+		}
+	}
+	usage := "ugbt [flags] " + strings.Join(args, " ") + " [command-flags]"
+	if app.Usage() != "" {
+		usage += " " + app.Usage()
+	}
+	f := tool.FlagSet(app)
+	f.SetOutput(w)
+	fmt.Fprint(w, app.ShortHelp())
+	fprintf(w, "\n\nUsage: %s\n", usage)
+	app.DetailedHelp(f)
+	return nil
+}
+
+const helpHeader = `
+The Ugg boot tool.
 
 Usage: ugbt [flags] <command> [command-flags] [command-args]
 
@@ -503,271 +1801,1580 @@ available versions using module version information embedded in
 the executable.
 
 Available commands:
+`
 
-  list: print a list of available versions for a Go executable
+const helpFooter = `
+Help for each command is provided with the -h flag or by ugbt help <command>.
 
-  install: install an executable from source based on source location
-           information stored in the executable
+An argument of the form @file is replaced by the lines of the file, ignoring
+blank lines and lines starting with #. Use @@ for an argument starting with @.
+`
 
-  update: update an executable to the latest release if it is newer
-          than the installed version
+// version returns the Go package path, mod path and version of the an
+// executable.
+func (u *ugbt) version(ctx context.Context, exepath string) (pth, mod, version string, err error) {
+	info, err := u.buildInfo(ctx, exepath)
+	if err != nil {
+		return "", "", "", err
+	}
+	return u.exeVersion(ctx, info, exepath)
+}
 
-  repo: print the source code repository URL for the executable
+// exeVersion returns the Go package path, mod path and version of the
+// executable at exepath with the provided build information. If the ugbt
+// config holds a tools entry for the executable, the package and module
+// paths are taken from the entry, and the version is only taken from the
+// build information if it is for the same module. When the entry gives a
+// fork source, the version of an executable built from the original module
+// is retained so that it is compared against the fork's versions.
+func (u *ugbt) exeVersion(ctx context.Context, info *debug.BuildInfo, exepath string) (pth, mod, version string, err error) {
+	tool, ok := u.tool(exepath)
+	if !ok {
+		return modVersion(info, exepath)
+	}
+	pth = tool.Package
+	if tool.Source != "" {
+		pth = tool.Source
+	}
+	mod = tool.Module
+	if mod == "" {
+		mod, err = u.moduleOf(ctx, pth)
+		if err != nil {
+			return "", "", "", err
+		}
+	}
+	if info.Main.Path == mod || (tool.Source != "" && info.Main.Path != "") {
+		version = info.Main.Version
+	}
+	return pth, mod, version, nil
+}
 
-  bugs: print the issues URL for the executable
+// exeBase returns the name of the executable at exepath, without the .exe
+// suffix on Windows.
+func exeBase(exepath string) string {
+	name := filepath.Base(exepath)
+	if runtime.GOOS == "windows" {
+		name = strings.TrimSuffix(name, ".exe")
+	}
+	return name
+}
 
-  version: print the ugbt version information
+// tool returns the tools entry of the ugbt config for the executable at
+// exepath.
+func (u *ugbt) tool(exepath string) (toolConfig, bool) {
+	if exepath == "" {
+		return toolConfig{}, false
+	}
+	tool, ok := u.config.Tools[exeBase(exepath)]
+	return tool, ok && (tool.Package != "" || tool.Source != "")
+}
 
-  help: output ugbt help information
+// moduleOf returns the module providing the package, found by querying
+// GOPROXY for the longest prefix of the package path that is a module.
+func (t *ugbt) moduleOf(ctx context.Context, pkg string) (string, error) {
+	proxies, err := t.proxies(ctx)
+	if err != nil {
+		return "", err
+	}
+	for mod := pkg; mod != "."; mod = path.Dir(mod) {
+		esc, err := module.EscapePath(mod)
+		if err != nil {
+			continue
+		}
+		for _, p := range proxies {
+			u, err := url.Parse(p)
+			if err != nil {
+				return "", err
+			}
+			u.Path = path.Join(u.Path, esc, "@latest")
+			_, err = t.get(ctx, u.String())
+			if err == nil {
+				return mod, nil
+			}
+			var status statusError
+			if !errors.As(err, &status) {
+				return "", fmt.Errorf("query proxy: %w", err)
+			}
+		}
+	}
+	return "", withKind(kindNotFound, fmt.Errorf("no module found for %s", pkg))
+}
 
-Help for each command is provided with the -h flag.
-`
+// modVersion returns the Go package path, mod path and version held in the
+// build information of the executable at exepath. If exepath is empty, the
+// build information is for ugbt.
+func modVersion(info *debug.BuildInfo, exepath string) (pth, mod, version string, err error) {
+	if exepath == "" {
+		// info.Path is being abused here, but it will work if the ugbt
+		// command always lives at the root of the module.
+		return info.Path, info.Main.Path, info.Main.Version, nil
+	}
+	if m := builtModule(info); info.Path != "" && m.Path != "" && m.Version != "" {
+		return info.Path, m.Path, m.Version, nil
+	}
+	if strings.HasPrefix(info.GoVersion, "go") && isStd(info, exepath) {
+		return path.Join("cmd", path.Base(exepath)), "std", info.GoVersion, nil
+	}
+	return "", "", "", noModuleError(info, exepath)
+}
 
-// version returns the Go package path, mod path and version of the an
-// executable.
-func (u *ugbt) version(ctx context.Context, exepath string) (pth, mod, version string, err error) {
+// isStd returns whether the build information without module information
+// is for a command in the Go distribution. Commands built by go1.18 and
+// later record their cmd package path, but older releases record no path,
+// so the go and gofmt commands are recognised by their name.
+func isStd(info *debug.BuildInfo, exepath string) bool {
+	if info.Path != "" {
+		return strings.HasPrefix(info.Path, "cmd/")
+	}
+	name := strings.TrimSuffix(filepath.Base(exepath), ".exe")
+	return name == "go" || name == "gofmt"
+}
+
+// noModuleError returns an error describing why the build information for
+// the Go executable at exepath does not identify its module.
+func noModuleError(info *debug.BuildInfo, exepath string) error {
+	base := filepath.Base(exepath)
+	switch {
+	case info.Path == "":
+		return withKind(kindNotGo, fmt.Errorf("%s is a %s executable without package build information, possibly built by an old Go release or with the build information removed; reinstall it with go install <package>@<version> or add it to the tools section of the ugbt config", base, info.GoVersion))
+	case info.Path == "command-line-arguments":
+		return withKind(kindNotGo, fmt.Errorf("%s was built from a list of Go files so its package is not known; reinstall it with go install <package>@<version> or add it to the tools section of the ugbt config", base))
+	case info.Main.Path == "":
+		return withKind(kindNotGo, fmt.Errorf("%s was built from %s outside module mode so its module is not known; reinstall it with go install %[2]s@<version>", base, info.Path))
+	default:
+		return withKind(kindNotGo, fmt.Errorf("%s was built from %s without a module version", base, info.Path))
+	}
+}
+
+// notGoError returns an error describing why the executable at exepath
+// could not be read as a Go executable.
+func notGoError(exepath string, err error) error {
+	base := filepath.Base(exepath)
+	f, ferr := os.Open(exepath)
+	if ferr != nil {
+		return ferr
+	}
+	defer f.Close()
+	// UPX places its magic in the first pages of the packed file.
+	buf := make([]byte, 4096)
+	n, _ := io.ReadFull(f, buf)
+	if bytes.Contains(buf[:n], []byte("UPX!")) {
+		return withKind(kindNotGo, fmt.Errorf("%s is compressed with UPX so its build information can not be read; decompress it with upx -d or add it to the tools section of the ugbt config", base))
+	}
+	_, ierr := buildinfo.ReadFile(exepath)
+	if ierr == nil {
+		return err
+	}
+	if strings.Contains(ierr.Error(), "not a Go executable") {
+		return withKind(kindNotGo, fmt.Errorf("%s is not a Go executable; if it is a packed Go executable, add it to the tools section of the ugbt config", base))
+	}
+	return withKind(kindNotGo, ierr)
+}
+
+// buildInfo returns the build information embedded in an executable. If
+// exepath is empty, the build information for ugbt is returned. If the
+// build information can not be read but the ugbt config holds a tools entry
+// for the executable, empty build information is returned.
+func (u *ugbt) buildInfo(ctx context.Context, exepath string) (*debug.BuildInfo, error) {
+	u.noteQueried(exepath)
+	info, err := u.readBuildInfo(ctx, exepath)
+	if err != nil {
+		if _, ok := u.tool(exepath); ok {
+			// The package is known from the ugbt config.
+			return &debug.BuildInfo{}, nil
+		}
+		return nil, err
+	}
+	return info, nil
+}
+
+// readBuildInfo returns the build information embedded in an executable.
+// If exepath is empty, the build information for ugbt is returned. The
+// build information is read directly from the executable, so no Go
+// toolchain is needed, and is cached for executables that have not
+// changed since they were last read.
+func (u *ugbt) readBuildInfo(ctx context.Context, exepath string) (*debug.BuildInfo, error) {
 	if exepath == "" {
 		info, ok := debug.ReadBuildInfo()
 		if !ok {
-			return "", "", "", errors.New("could not read build info")
+			return nil, errors.New("could not read build info")
 		}
-		// info.Path is being abused here, but it will work if the ugbt
-		// command always lives at the root of the module.
-		return info.Path, info.Main.Path, info.Main.Version, nil
+		return info, nil
 	}
 
-	exepath, err = exec.LookPath(exepath)
+	exepath, err := exec.LookPath(exepath)
 	if err != nil {
-		return "", "", "", err
+		return nil, err
+	}
+	if resolved, _, ok := u.resolveShim(ctx, exepath); ok {
+		// Version manager shims hold no build information,
+		// so use the executable that the shim runs.
+		exepath = resolved
+	}
+
+	info, err := u.exeBuildInfo(exepath)
+	if err != nil {
+		return nil, notGoError(exepath, err)
+	}
+	return info, nil
+}
+
+// warnReplaced writes a warning to w if the build information shows that
+// the executable was built with replaced modules, listing the replacements.
+func warnReplaced(w io.Writer, exe string, info *debug.BuildInfo) {
+	var replaced []*debug.Module
+	if info.Main.Replace != nil {
+		replaced = append(replaced, &info.Main)
+	}
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			replaced = append(replaced, dep)
+		}
+	}
+	if len(replaced) == 0 {
+		return
+	}
+	if exe == "" {
+		exe = "ugbt"
+	}
+	fprintf(w, "warning: %s was built with replaced modules; installing with go install will not reproduce the original build:\n", exe)
+	for _, m := range replaced {
+		fmt.Fprintf(w, "\t%s => %s\n", modString(m), modString(m.Replace))
+	}
+}
+
+// warnModified writes a warning to w if the build information shows that
+// the executable was built from a vcs working tree with uncommitted changes.
+func warnModified(w io.Writer, exe string, info *debug.BuildInfo) {
+	if !vcsModified(info) {
+		return
+	}
+	if exe == "" {
+		exe = "ugbt"
+	}
+	var at string
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			at = " at " + s.Value
+			break
+		}
+	}
+	fprintf(w, "warning: %s was built from a working tree with uncommitted changes%s; a clean rebuild will not include them\n", exe, at)
+}
+
+// vcsModified returns whether the build information records that the
+// executable was built from a vcs working tree with uncommitted changes.
+func vcsModified(info *debug.BuildInfo) bool {
+	for _, s := range info.Settings {
+		if s.Key == "vcs.modified" {
+			return s.Value == "true"
+		}
+	}
+	return false
+}
+
+// modString returns the path and, if present, version of m.
+func modString(m *debug.Module) string {
+	if m.Version == "" {
+		return m.Path
+	}
+	return m.Path + " " + m.Version
+}
+
+// sameVersion returns a pseudo-version for the VCS revision recorded in
+// the build settings of the provided build information.
+func sameVersion(info *debug.BuildInfo) (string, error) {
+	var rev, vcsTime string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			rev = s.Value
+		case "vcs.time":
+			vcsTime = s.Value
+		}
+	}
+	if rev == "" || vcsTime == "" {
+		return "", errors.New("no vcs revision recorded in build settings")
+	}
+	t, err := time.Parse(time.RFC3339Nano, vcsTime)
+	if err != nil {
+		return "", fmt.Errorf("invalid vcs time: %w", err)
+	}
+	if len(rev) > 12 {
+		rev = rev[:12]
+	}
+	major := semver.Major(info.Main.Version)
+	if major == "v1" {
+		// Pseudo-versions without a preceding tag must be v0.
+		major = "v0"
+	}
+	return module.PseudoVersion(major, "", t, rev), nil
+}
+
+// install installs the package at the given path at the given version.
+func (u *ugbt) install(ctx context.Context, path, mod, version string, flags BuildFlags) error {
+	if mod == "std" {
+		return u.installStd(ctx, path, version, flags)
+	}
+
+	if mod != "" {
+		err := u.preflight(ctx, mod, version, u.pin(path), u.installedVersion(ctx, path) != "", flags)
+		if err != nil {
+			return err
+		}
+	}
+	if flags.overrides != nil {
+		dir, err := u.overrideModule(ctx, path, mod, version, flags.overrides, flags)
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		return u.goInstall(ctx, path, path, mod, dir, flags)
+	}
+	return u.goInstall(ctx, path, path+"@"+version, mod, "", flags)
+}
+
+// installLocal installs the package at the given path from the local
+// working copy in dir.
+func (u *ugbt) installLocal(ctx context.Context, path, dir string, flags BuildFlags) error {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(u.wd, dir)
+	}
+	target := "."
+	root, mod, goVersion, err := localModule(dir)
+	if err != nil {
+		return err
+	}
+	err = u.checkGoVersion(ctx, mod, "(devel)", u.pin(path), goVersion)
+	if err != nil {
+		return err
+	}
+	if modrepo.HasPathPrefix(path, mod) {
+		// Build the executable's package within the module rather
+		// than whichever package happens to be in dir.
+		dir, target = root, "./"+strings.TrimPrefix(strings.TrimPrefix(path, mod), "/")
+		if target == "./" {
+			target = "."
+		}
+	}
+	return u.goInstall(ctx, path, target, "", dir, flags)
+}
+
+// localModule returns the root directory, module path and go directive
+// version of the module containing dir. If the module has no go directive,
+// goVersion is empty.
+func localModule(dir string) (root, mod, goVersion string, err error) {
+	for root = dir; ; {
+		name := filepath.Join(root, "go.mod")
+		buf, err := os.ReadFile(name)
+		if err == nil {
+			f, err := modfile.ParseLax(name, buf, nil)
+			if err != nil {
+				return "", "", "", fmt.Errorf("invalid modfile: %w", err)
+			}
+			if f.Module == nil || f.Module.Mod.Path == "" {
+				return "", "", "", fmt.Errorf("no module path in %s", name)
+			}
+			if f.Go != nil {
+				goVersion = f.Go.Version
+			}
+			return root, f.Module.Mod.Path, goVersion, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", "", "", err
+		}
+		parent := filepath.Dir(root)
+		if parent == root {
+			return "", "", "", fmt.Errorf("no go.mod found for %s", dir)
+		}
+		root = parent
+	}
+}
+
+// goInstall runs go install for the target, replacing the executable for
+// the package path. If dir is not empty, the target is built from the local
+// working copy in dir, and the install is recorded as locally sourced unless
+// dir is the temporary module holding the dependency overrides in flags.
+func (u *ugbt) goInstall(ctx context.Context, path, target, mod, dir string, flags BuildFlags) error {
+	args := append(flags.installArgs(), target)
+	start := time.Now()
+	previous := u.installedVersion(ctx, path)
+
+	var saved *backup
+	if u.config.Backup.Enabled {
+		var err error
+		saved, err = u.backup(ctx, path)
+		if err != nil {
+			return fmt.Errorf("backup: %w", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	stderr := io.Writer(&buf)
+	if flags.Verbose || flags.Commands {
+		stderr = io.MultiWriter(flags.progressWriter(), stderr)
+	}
+	// run runs go install with the additional environment
+	// variables in env. ran is set when go install is
+	// started.
+	var ran bool
+	run := func(env ...string) error {
+		buf.Reset()
+		cmd := u.cmd(ctx, nil, stderr, args...)
+		if dir != "" {
+			cmd.Dir = dir
+		}
+		if pin := u.pin(path); pin != "" {
+			err := u.usePinned(ctx, cmd, pin, flags)
+			if err != nil {
+				return err
+			}
+		}
+		if flags.Sandbox != "" {
+			bin, err := u.binDir(ctx)
+			if err != nil {
+				return err
+			}
+			cleanup, err := u.sandbox(ctx, cmd, flags.Sandbox, bin, dir)
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+		}
+		if len(env) != 0 {
+			if cmd.Env == nil {
+				cmd.Env = os.Environ()
+			}
+			cmd.Env = append(cmd.Env, env...)
+		}
+		ran = true
+		restore := u.moveRunning(ctx, path)
+		err := cmd.Run()
+		if err != nil {
+			restore()
+		}
+		return err
+	}
+	offline := u.offlineEnv(ctx)
+	err := run(offline...)
+	if err != nil && dir == "" && !flags.RequireSumDB && sumDBUnreachable(buf.String()) {
+		if mod == "" {
+			mod = path
+		}
+		env, gosumdb, skipErr := u.skipSumDB(ctx, mod)
+		if skipErr != nil {
+			if saved != nil {
+				os.RemoveAll(saved.dir)
+			}
+			return skipErr
+		}
+		fprintf(flags.progressWriter(), "warning: checksum database %s is unreachable: installing %s without checking %s against it; its dependencies are checked against its go.sum file\n", gosumdb, target, mod)
+		err = run(append(offline, env)...)
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		if saved != nil {
+			os.RemoveAll(saved.dir)
+		}
+		return fmt.Errorf("installing %s requires a Go toolchain: %w", path, err)
+	}
+	if err != nil && !ran {
+		if saved != nil {
+			os.RemoveAll(saved.dir)
+		}
+		return err
+	}
+	if err != nil {
+		if saved != nil {
+			// The executable was not replaced.
+			os.RemoveAll(saved.dir)
+		}
+		if flags.Verbose || flags.Commands {
+			return fmt.Errorf("go install: %w", err)
+		}
+		if dir != "" {
+			return withKind(goOutputKind(buf.String()), fmt.Errorf("go install: %s", strings.TrimSpace(buf.String())))
+		}
+		if mod == "" {
+			mod = path
+		}
+		if flags.RequireSumDB && sumDBUnreachable(buf.String()) {
+			return fmt.Errorf("%s\n\nthe checksum database could not be reached to verify %s and -require-sumdb was given", strings.TrimSpace(buf.String()), mod)
+		}
+		return u.sumDBError(ctx, mod, buf.String())
+	}
+
+	if runtime.GOOS == "darwin" {
+		// Sign before recording the install so that the
+		// recorded digest is of the signed executable.
+		u.prepareDarwin(ctx, flags.progressWriter(), path, flags)
+	}
+	local := dir
+	if flags.overrides != nil {
+		local = ""
+	}
+	err = u.recordInstall(ctx, path, local, flags.overrides, saved)
+	if err != nil {
+		return fmt.Errorf("record state: %w", err)
+	}
+	if flags.Probe {
+		err = u.probe(ctx, path)
+		if err != nil {
+			return u.rollback(ctx, path, saved, err)
+		}
+	}
+
+	if u.config.Backup.Enabled {
+		_, err = u.pruneBackups(time.Now())
+		if err != nil {
+			return fmt.Errorf("prune backups: %w", err)
+		}
+	}
+	u.reportInstall(ctx, flags.progressWriter(), path, previous, time.Since(start))
+	u.warnPath(ctx, flags.progressWriter(), path)
+	return nil
+}
+
+// installedVersion returns the module version of the executable installed
+// for the package path, or the empty string if there is none.
+func (u *ugbt) installedVersion(ctx context.Context, path string) string {
+	dst, err := u.installPath(ctx, path)
+	if err != nil {
+		return ""
+	}
+	info, err := buildinfo.ReadFile(dst)
+	if err != nil {
+		return ""
+	}
+	return builtModule(info).Version
+}
+
+// reportInstall writes a line to w describing the executable installed
+// for the package path: where it was installed, the version it replaced,
+// the Go version it was built with, how long the install took and whether
+// it is the executable found in PATH.
+func (u *ugbt) reportInstall(ctx context.Context, w io.Writer, path, previous string, elapsed time.Duration) {
+	dst, err := u.installPath(ctx, path)
+	if err != nil {
+		return
+	}
+	info, err := buildinfo.ReadFile(dst)
+	if err != nil {
+		return
+	}
+	version := builtModule(info).Version
+	if previous != "" && previous != version {
+		version = previous + " -> " + version
+	}
+	where := "not in PATH"
+	if found, err := exec.LookPath(exeName(path)); err == nil && sameFile(found, dst) {
+		where = "in PATH"
+	}
+	fprintf(w, "installed %s %s with %s in %s (%s)\n", dst, version, info.GoVersion, elapsed.Round(100*time.Millisecond), where)
+}
+
+// moveRunning moves the executable installed for the package path aside if
+// it is the running ugbt executable on Windows, where a running executable
+// can be renamed but not replaced. The returned function moves it back if
+// the install fails. Executables moved aside by earlier installs are
+// removed if they are no longer running.
+func (u *ugbt) moveRunning(ctx context.Context, path string) (restore func()) {
+	restore = func() {}
+	if runtime.GOOS != "windows" {
+		return restore
+	}
+	dst, err := u.installPath(ctx, path)
+	if err != nil {
+		return restore
+	}
+	old := dst + ".old"
+	os.Remove(old)
+	self, err := os.Executable()
+	if err != nil || !sameFile(self, dst) {
+		return restore
+	}
+	err = os.Rename(dst, old)
+	if err != nil {
+		return restore
+	}
+	return func() { os.Rename(old, dst) }
+}
+
+// prepareDarwin prepares the executable installed for the package path to
+// be run on macOS. The quarantine attribute is removed so that Gatekeeper
+// does not refuse to run the executable, and the executable is ad-hoc
+// signed for environments that require signed code. Failures are written
+// to w as warnings since the executable has already been installed.
+func (u *ugbt) prepareDarwin(ctx context.Context, w io.Writer, path string, flags BuildFlags) {
+	dst, err := u.installPath(ctx, path)
+	if err != nil {
+		return
+	}
+	if !flags.KeepQuarantine {
+		const quarantine = "com.apple.quarantine"
+		// Only attempt removal if the attribute is present
+		// since xattr -d fails otherwise.
+		if exec.CommandContext(ctx, "xattr", "-p", quarantine, dst).Run() == nil {
+			out, err := exec.CommandContext(ctx, "xattr", "-d", quarantine, dst).CombinedOutput()
+			if err != nil {
+				fprintf(w, "warning: could not remove quarantine attribute from %s: %v %s\n", dst, err, bytes.TrimSpace(out))
+			}
+		}
+	}
+	if !flags.NoCodesign {
+		out, err := exec.CommandContext(ctx, "codesign", "--force", "--sign", "-", dst).CombinedOutput()
+		if err != nil {
+			fprintf(w, "warning: could not sign %s: %v %s\n\tuse -no-codesign to skip signing\n", dst, err, bytes.TrimSpace(out))
+		}
+	}
+}
+
+// recordInstall records the installation of the package path in the ugbt
+// state. The local parameter is the directory of the working copy that the
+// executable was built from, and is empty for module versions. The o
+// parameter holds any dependency overrides the executable was built with,
+// and the saved parameter is the backup of the replaced executable. Both
+// may be nil.
+func (u *ugbt) recordInstall(ctx context.Context, pkg, local string, o *overrides, saved *backup) error {
+	dst, err := u.installPath(ctx, pkg)
+	if err != nil {
+		return err
+	}
+	current := installed{Package: pkg, Local: local, Overrides: o, Time: time.Now().UTC()}
+	_, current.Module, current.Version, err = u.version(ctx, dst)
+	if err != nil {
+		return err
+	}
+	if info, err := buildinfo.ReadFile(dst); err == nil {
+		current.Sum = moduleSum(info)
+	}
+	current.SHA256, err = fileSHA256(dst)
+	if err != nil {
+		return err
+	}
+	var dir string
+	if saved != nil {
+		dir = saved.dir
+	}
+	s, err := loadState()
+	if err != nil {
+		return err
+	}
+	s.record(dst, current, dir)
+	return s.save()
+}
+
+// installPath returns the path that go install will write the executable
+// for the package path to.
+func (u *ugbt) installPath(ctx context.Context, pkg string) (string, error) {
+	dir, err := u.binDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	name := exeName(pkg)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// warnPath writes a warning to w if the executable installed for the
+// package is not the one that is found in PATH, either because the install
+// directory is not in PATH or because another executable with the same name
+// is found first. No warning is written when the -bin flag is given since
+// the executables are then not intended to be run from PATH.
+func (u *ugbt) warnPath(ctx context.Context, w io.Writer, pkg string) {
+	if u.Bin != "" {
+		return
+	}
+	dst, err := u.installPath(ctx, pkg)
+	if err != nil {
+		return
+	}
+	dir := filepath.Dir(dst)
+	var inPath bool
+	for _, p := range filepath.SplitList(u.getenv("PATH")) {
+		if sameFile(p, dir) {
+			inPath = true
+			break
+		}
+	}
+	if !inPath {
+		fprintf(w, "warning: %s is not in PATH; add it to PATH to run %s\n", dir, filepath.Base(dst))
+		return
+	}
+	found, err := exec.LookPath(exeName(pkg))
+	if err != nil || sameFile(found, dst) {
+		return
+	}
+	fprintf(w, "warning: %s is shadowed by %s which is earlier in PATH; remove it or move %s before it in PATH\n", dst, found, dir)
+}
+
+// sameFile returns whether the paths a and b refer to the same file.
+func sameFile(a, b string) bool {
+	if filepath.Clean(a) == filepath.Clean(b) {
+		return true
+	}
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ai, bi)
+}
+
+// binDir returns the directory that go install writes executables to.
+func (u *ugbt) binDir(ctx context.Context) (string, error) {
+	gobin, err := u.goenv(ctx, "GOBIN")
+	if err != nil {
+		return "", err
+	}
+	if gobin != "" {
+		return gobin, nil
 	}
+	gopath, err := u.goenv(ctx, "GOPATH")
+	if err != nil {
+		return "", err
+	}
+	list := filepath.SplitList(gopath)
+	if len(list) == 0 || list[0] == "" {
+		return "", errors.New("no GOBIN or GOPATH")
+	}
+	return filepath.Join(list[0], "bin"), nil
+}
 
+// download downloads the modules needed to build the provided targets into
+// the module cache, so that installing them needs no network access. The
+// packages are loaded with go install -n, which downloads all the modules
+// providing the packages of the build without building them. Targets in
+// the standard library are skipped since they are obtained by the
+// golang.org/x/dl tool during installation. The failures for all the targets
+// that could not be downloaded are returned together.
+func (u *ugbt) download(ctx context.Context, targets []target, flags BuildFlags) error {
+	var (
+		wg   sync.WaitGroup
+		sema = make(chan struct{}, u.concurrency())
+		errs = make([]error, len(targets))
+	)
+	for i, t := range targets {
+		if t.mod == "std" {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, t target) {
+			defer wg.Done()
+			sema <- struct{}{}
+			defer func() { <-sema }()
+
+			args := []string{"install", "-n"}
+			if flags.Commands {
+				args = append(args, "-x")
+			}
+			args = append(args, t.path+"@"+t.version)
+			var buf bytes.Buffer
+			stderr := io.Writer(&buf)
+			if flags.Verbose || flags.Commands {
+				stderr = io.MultiWriter(flags.progressWriter(), stderr)
+			}
+			// The commands that go install -n would run
+			// are not of interest.
+			err := u.cmd(ctx, io.Discard, stderr, args...).Run()
+			if err != nil {
+				errs[i] = withKind(goOutputKind(buf.String()), fmt.Errorf("download %s@%s: %v", t.mod, t.version, strings.TrimSpace(buf.String())))
+			}
+		}(i, t)
+	}
+	wg.Wait()
+	return joinErrors(errs)
+}
+
+// preflight returns an error if the module at the version should not be
+// installed. The version must not be retracted unless allowed by flags, and
+// the module must not be deprecated unless allowed by flags or replacing is
+// true, when a warning is printed instead. The go directive of the module must not require a newer Go
+// toolchain than the local go command when the go command will not switch
+// to a newer toolchain itself, or than the pinned Go release if pin is not
+// empty. Failures to obtain the module information are left for go install
+// to report.
+func (u *ugbt) preflight(ctx context.Context, mod, version, pin string, replacing bool, flags BuildFlags) error {
 	var stdout bytes.Buffer
-	err = u.cmd(ctx, &stdout, nil, "version", "-m", exepath).Run()
+	err := u.cmd(ctx, &stdout, io.Discard, "list", "-m", "-json", "-retracted", "-u", mod+"@"+version).Run()
 	if err != nil {
-		return "", "", "", err
+		return nil
+	}
+	var m struct {
+		Version    string
+		GoVersion  string
+		Retracted  []string
+		Deprecated string
+	}
+	err = json.Unmarshal(stdout.Bytes(), &m)
+	if err != nil {
+		return nil
+	}
+	if m.Retracted != nil && !flags.AllowRetracted {
+		var rationale string
+		if r := strings.Join(m.Retracted, "; "); r != "" {
+			rationale = " (" + r + ")"
+		}
+		return withKind(kindRetracted, fmt.Errorf("%s@%s is retracted%s; use -allow-retracted to install it", mod, m.Version, rationale))
+	}
+	if m.Deprecated != "" && !flags.AllowDeprecated {
+		if !replacing {
+			return withKind(kindDeprecated, fmt.Errorf("%s is deprecated (%s); use -allow-deprecated to install it", mod, m.Deprecated))
+		}
+		fprintf(flags.progressWriter(), "warning: %s is deprecated (%s)\n", mod, m.Deprecated)
+	}
+	return u.checkGoVersion(ctx, mod, version, pin, m.GoVersion)
+}
+
+// checkGoVersion returns an error if the go directive version need of the
+// module at the version requires a newer Go toolchain than the local go
+// command and the go command will not switch to a newer toolchain itself.
+// If pin is not empty, it is the Go release that the module is built with
+// in place of the local go command.
+func (u *ugbt) checkGoVersion(ctx context.Context, mod, version, pin, need string) error {
+	if need == "" {
+		return nil
+	}
+	if pin != "" {
+		if semver.Compare(goSemver(pin), goSemver(need)) >= 0 {
+			return nil
+		}
+		return withKind(kindToolchain, fmt.Errorf("%s@%s requires go >= %s, but it is pinned to %s in the ugbt config", mod, version, need, pin))
+	}
+	have, err := u.goenv(ctx, "GOVERSION")
+	if err != nil || !goRelease.MatchString(have) {
+		// Development versions are not checked.
+		return nil
+	}
+	if semver.Compare(goSemver(have), goSemver(need)) >= 0 {
+		return nil
+	}
+	remedy := "put a newer go command first in PATH or pin the executable to go" + need +
+		" or later with the go field of its tools entry in the ugbt config"
+	if semver.Compare(goSemver(have), "v1.21.0") >= 0 {
+		// Since go1.21, the go command downloads the
+		// required toolchain unless GOTOOLCHAIN forbids it.
+		toolchain, err := u.goenv(ctx, "GOTOOLCHAIN")
+		if err != nil {
+			return nil
+		}
+		if toolchain != "local" && !strings.HasSuffix(toolchain, "+path") {
+			return nil
+		}
+		remedy = "set GOTOOLCHAIN=auto to allow the go command to download go" + need + ", " + remedy
+	}
+	return withKind(kindToolchain, fmt.Errorf("%s@%s requires go >= %s, you have %s; %s",
+		mod, version, need, strings.TrimPrefix(have, "go"), remedy))
+}
+
+// pin returns the Go release that the executable installed for the package
+// path is pinned to in the tools section of the ugbt config, or the empty
+// string if it is not pinned.
+func (u *ugbt) pin(pkg string) string {
+	return u.config.Tools[exeName(pkg)].Go
+}
+
+// usePinned configures cmd, a go command, to be run by the go command of
+// the SDK for the pinned Go release, installing the golang.org/dl wrapper
+// and its SDK if they are not already installed. The SDK's GOROOT is set
+// explicitly so that it is used within a sandbox, where the home directory
+// that the wrapper finds the SDK in is not the user's. The go command is
+// prevented from switching to another toolchain.
+func (u *ugbt) usePinned(ctx context.Context, cmd *execabs.Cmd, pin string, flags BuildFlags) error {
+	if !isGoRelease(pin) {
+		return fmt.Errorf("invalid pinned Go release: %q", pin)
+	}
+	wrapper, err := u.installPath(ctx, "golang.org/dl/"+pin)
+	if err != nil {
+		return err
+	}
+	sdk, err := sdkRoot()
+	if err != nil {
+		return err
+	}
+	goroot := filepath.Join(sdk, pin)
+	gocmd := filepath.Join(goroot, "bin", "go")
+	if runtime.GOOS == "windows" {
+		gocmd += ".exe"
+	}
+	_, werr := os.Stat(wrapper)
+	_, gerr := os.Stat(gocmd)
+	if werr != nil || gerr != nil {
+		fprintf(flags.progressWriter(), "installing pinned toolchain %s\n", pin)
+		err = u.installStd(ctx, "", pin, flags)
+		if err != nil {
+			return fmt.Errorf("install pinned toolchain %s: %w", pin, err)
+		}
+	}
+	cmd.Path = gocmd
+	cmd.Args[0] = "go"
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env,
+		"GOROOT="+goroot,
+		"GOTOOLCHAIN=local",
+		"PATH="+filepath.Join(goroot, "bin")+string(os.PathListSeparator)+os.Getenv("PATH"),
+	)
+	return nil
+}
+
+// goDirective returns the go directive version of the module at the version
+// as recorded by the first $GOPROXY proxy that holds it. If the module has
+// no go directive, the empty string is returned.
+func (u *ugbt) goDirective(ctx context.Context, mod, version string) (string, error) {
+	buf, ok, err := u.proxyFile(ctx, mod, version, ".mod")
+	if !ok || err != nil {
+		return "", err
+	}
+	f, err := modfile.ParseLax(mod+"@"+version+"/go.mod", buf, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid modfile: %w", err)
+	}
+	if f.Go == nil {
+		return "", nil
+	}
+	return f.Go.Version, nil
+}
+
+// proxyFile returns the file with the extension ext, .info, .mod or .zip,
+// for the module at the version from the first $GOPROXY proxy that holds
+// it. If no proxy holds the module version, ok is false.
+func (u *ugbt) proxyFile(ctx context.Context, mod, version, ext string) (_ []byte, ok bool, _ error) {
+	mod, err := module.EscapePath(mod)
+	if err != nil {
+		return nil, false, err
+	}
+	version, err = module.EscapeVersion(version)
+	if err != nil {
+		return nil, false, err
+	}
+	proxies, err := u.proxies(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, p := range proxies {
+		pu, err := url.Parse(p)
+		if err != nil {
+			return nil, false, err
+		}
+		pu.Path = path.Join(pu.Path, mod, "@v", version+ext)
+		buf, err := u.get(ctx, pu.String())
+		if err != nil {
+			var status statusError
+			if errors.As(err, &status) {
+				switch status.code {
+				case http.StatusNotFound, http.StatusGone:
+					continue
+				}
+			}
+			return nil, false, fmt.Errorf("query proxy: %w", err)
+		}
+		return buf, true, nil
+	}
+	return nil, false, nil
+}
+
+// goSemver returns the Go release or go directive version v as a semantic
+// version. For example go1.21rc1 is returned as v1.21.0-rc1.
+func goSemver(v string) string {
+	v = strings.TrimPrefix(v, "go")
+	var pre string
+	if i := strings.IndexAny(v, "abcdefghijklmnopqrstuvwxyz"); i >= 0 {
+		v, pre = v[:i], "-"+v[i:]
+	}
+	if strings.Count(v, ".") == 1 {
+		v += ".0"
+	}
+	return "v" + v + pre
+}
+
+// installStd installs the go tool chain and standard library.
+func (u *ugbt) installStd(ctx context.Context, path, version string, flags BuildFlags) error {
+	if version == "latest" {
+		versions, err := u.stdInfo(ctx)
+		if err != nil {
+			return err
+		}
+		if len(versions) == 0 {
+			return withKind(kindNotFound, errors.New("not found"))
+		}
+		version = versions[0].Version
+	}
+	err := u.install(ctx, "golang.org/dl/"+version, "", "latest", flags)
+	if err != nil {
+		return err
+	}
+	if version != "gotip" {
+		err = u.seedSDK(ctx, version)
+		if err != nil {
+			return err
+		}
+	}
+	stderr := io.Discard
+	if flags.Verbose {
+		stderr = flags.progressWriter()
+	}
+	cmd := execabs.CommandContext(ctx, version, "download")
+	cmd.Dir = u.wd
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	if err != nil {
+		return err
+	}
+	if !flags.Verbose {
+		fmt.Fprintf(flags.progressWriter(), "go tool available as %s\n", version)
+	}
+	return nil
+}
+
+type info struct {
+	Version             string
+	Time                time.Time
+	isRetracted         bool
+	retractionRationale string
+	isSecurity          bool
+
+	// retractions holds all the retractions
+	// that cover the version.
+	retractions []retraction
+}
+
+// retraction is a version retraction declared in a go.mod file.
+type retraction struct {
+	// Low and High are the bounds of the
+	// retracted version interval.
+	Low, High string
+	Rationale string
+
+	// GoMod is the module version whose
+	// go.mod file declared the retraction.
+	GoMod string
+}
+
+// availableVersions returns the available semver versions from the
+// $GOPROXY version database. Only versions at or after the current
+// version are returned unless all is true.
+func (t *ugbt) availableVersions(ctx context.Context, mod, current string, all bool, filter versionFilter) ([]info, error) {
+	if mod == "std" {
+		versions, err := t.stdInfo(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var kept []string
+		idx := make(map[string]info)
+		for _, v := range versions {
+			if filter.keep != nil && !filter.keep(v.Version) {
+				continue
+			}
+			if !all && semverCompare(v.Version, current) < 0 {
+				continue
+			}
+			if !filter.published(v.Time) {
+				continue
+			}
+			kept = append(kept, v.Version)
+			idx[v.Version] = v
+		}
+		kept = filter.page(kept, nil)
+		versions = versions[:0]
+		for _, v := range kept {
+			versions = append(versions, idx[v])
+		}
+		return versions, nil
+	}
+	versions, _, err := t.moduleVersions(ctx, mod, current, all, filter)
+	return versions, err
+}
+
+// versionFilter selects the versions returned by moduleVersions.
+type versionFilter struct {
+	// keep, if not nil, returns whether a version is
+	// to be returned. The metadata of other versions
+	// is not fetched.
+	keep func(version string) bool
+
+	// offset and limit select a page of the kept
+	// versions, newest first, from each proxy. If
+	// limit is zero, all the kept versions after
+	// offset are returned.
+	offset, limit int
+
+	// unretracted excludes versions retracted by the
+	// latest version of the module from the kept
+	// versions.
+	unretracted bool
+
+	// since and until, if not zero, exclude versions
+	// published before since or after until.
+	since, until time.Time
+
+	// quiet suppresses the warning about stale module
+	// cache information for background checks.
+	quiet bool
+}
+
+// published returns whether a version published at t is within the
+// filter's date range. Versions without a publication time are kept.
+func (f versionFilter) published(t time.Time) bool {
+	if t.IsZero() {
+		return true
+	}
+	return (f.since.IsZero() || !t.Before(f.since)) && (f.until.IsZero() || !t.After(f.until))
+}
+
+// preRelease returns a version filter for moduleVersions that keeps
+// versions with a pre-release matching suffix.
+func preRelease(suffix *regexp.Regexp) versionFilter {
+	return versionFilter{keep: func(version string) bool {
+		return suffix.MatchString(semver.Prerelease(version))
+	}}
+}
+
+// page returns the page of versions described by the filter, sorted
+// newest first. If the filter excludes retracted versions, versions
+// within the retractions are omitted before the page is selected.
+func (f versionFilter) page(versions []string, retractions []retraction) []string {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return semverCompare(versions[i], versions[j]) > 0
+	})
+	if f.unretracted && len(retractions) != 0 {
+		kept := versions[:0]
+	outer:
+		for _, v := range versions {
+			for _, r := range retractions {
+				if semver.Compare(v, r.Low) >= 0 && semver.Compare(v, r.High) <= 0 {
+					continue outer
+				}
+			}
+			kept = append(kept, v)
+		}
+		versions = kept
 	}
-	var main string
-	sc := bufio.NewScanner(&stdout)
+	if f.offset >= len(versions) {
+		return nil
+	}
+	versions = versions[f.offset:]
+	if f.limit > 0 && f.limit < len(versions) {
+		versions = versions[:f.limit]
+	}
+	return versions
+}
+
+// moduleVersions returns the available semver versions of the module from
+// the $GOPROXY version database and the retractions declared by their go.mod
+// files. Only versions at or after the current version and selected by the
+// filter are returned unless all is true, and the metadata of other versions
+// is not fetched.
+func (t *ugbt) moduleVersions(ctx context.Context, mod, current string, all bool, filter versionFilter) ([]info, []retraction, error) {
+	mod, err := module.EscapePath(mod)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, reason := range []string{
+		"GOPRIVATE",
+		"GONOPROXY",
+	} {
+		private, err := t.isPrivate(ctx, mod, reason)
+		if err != nil {
+			return nil, nil, err
+		}
+		if private {
+			return nil, nil, fmt.Errorf("module %s matches %s", mod, reason)
+		}
+	}
+
+	proxies, err := t.proxies(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Query the proxies concurrently, but merge the results in
+	// GOPROXY order so that earlier proxies take precedence.
+	var (
+		wg      sync.WaitGroup
+		results = make([]proxyResult, len(proxies))
+	)
+	for i, p := range proxies {
+		wg.Add(1)
+		go func(i int, p string) {
+			defer wg.Done()
+			results[i] = t.proxyVersions(ctx, p, mod, current, all, filter)
+		}(i, p)
+	}
+	wg.Wait()
+
+	var (
+		versions    []info
+		retractions []retraction
+	)
+	for i, r := range results {
+		if r.err != nil {
+			return nil, nil, r.err
+		}
+		if r.stale != nil && filter.offset == 0 && !filter.quiet {
+			// Only warn for the first page of versions.
+			warnStale(os.Stderr, mod, r.stale)
+		}
+		for _, v := range r.versions {
+			t.debugf("%s@%s from %s", mod, v.Version, proxies[i])
+		}
+		versions = append(versions, r.versions...)
+		retractions = append(retractions, r.retractions...)
+	}
+	versions = unique(versions)
+	retractions = uniqueRetractions(retractions)
+	for i, v := range versions {
+		for _, r := range retractions {
+			if semver.Compare(v.Version, r.Low) >= 0 && semver.Compare(v.Version, r.High) <= 0 {
+				versions[i].isRetracted = true
+				versions[i].retractionRationale = r.Rationale
+				versions[i].retractions = append(versions[i].retractions, r)
+			}
+		}
+	}
+	return versions, retractions, nil
+}
+
+// proxyResult is the result of querying a single proxy for versions.
+type proxyResult struct {
+	versions    []info
+	retractions []retraction
+
+	// stale is the module cache version list
+	// URL if the versions were obtained from
+	// the module cache.
+	stale *url.URL
+
+	err error
+}
+
+// proxyVersions returns the versions of the escaped module path held by
+// the proxy. Only versions at or after the current version and selected by
+// the filter are returned unless all is true. A proxy that does not hold the
+// module returns no versions.
+func (t *ugbt) proxyVersions(ctx context.Context, proxy, mod, current string, all bool, filter versionFilter) proxyResult {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return proxyResult{err: err}
+	}
+	base := u.Path
+	u.Path = path.Join(base, mod, "@v", "list")
+	var buf []byte
+	if u.Scheme == "file" {
+		buf, err = t.get(ctx, u.String())
+	} else {
+		buf, err = t.getList(ctx, u.String())
+	}
+	if err != nil {
+		var status statusError
+		if !errors.As(err, &status) {
+			return proxyResult{err: err}
+		}
+		switch status.code {
+		case http.StatusNotFound, http.StatusGone:
+		default:
+			return proxyResult{err: err}
+		}
+		if u.Scheme != "file" {
+			return proxyResult{}
+		}
+		// The module cache only holds a version list if the
+		// go command has queried the list, so fall back to
+		// the versions that have been downloaded.
+		buf, err = cachedList(u)
+		if err != nil {
+			return proxyResult{err: err}
+		}
+	}
+	var r proxyResult
+	if u.Scheme == "file" {
+		stale := *u
+		r.stale = &stale
+	}
+
+	sc := bufio.NewScanner(bytes.NewReader(buf))
+	var (
+		list   []string
+		latest string
+	)
 	for sc.Scan() {
-		if len(sc.Bytes()) == 0 {
+		version := sc.Text()
+		if latest == "" || semverCompare(version, latest) > 0 {
+			latest = version
+		}
+		if filter.keep != nil && !filter.keep(version) {
 			continue
 		}
-		if m := bytes.Split(sc.Bytes(), []byte(": ")); len(m) == 2 {
-			main = string(m[0])
-			version = string(m[1])
+		if all || semverCompare(version, current) >= 0 {
+			list = append(list, version)
 		}
-		f := bytes.Fields(sc.Bytes())
-		switch {
-		case bytes.Equal(f[0], []byte("path")):
-			if len(f) < 2 {
-				return "", "", "", fmt.Errorf("unexpected path information format: %q", sc.Bytes())
+	}
+	if latest != "" {
+		// Retractions declared by the latest version apply to
+		// the listed versions, so obtain them before selecting
+		// the versions to fetch information for.
+		u.Path = path.Join(base, mod, "@v", latest)
+		rs, err := t.retractions(ctx, u.String())
+		if err != nil {
+			var status statusError
+			if !errors.As(err, &status) || (status.code != http.StatusNotFound && status.code != http.StatusGone) {
+				return proxyResult{err: err}
 			}
-			pth = string(f[1])
-		case bytes.Equal(f[0], []byte("mod")):
-			if len(f) < 3 {
-				return "", "", "", fmt.Errorf("unexpected module information format: %q", sc.Bytes())
+		}
+		r.retractions = append(r.retractions, rs...)
+	}
+	// Fetch the version information concurrently, newest
+	// first, handling each version as it becomes available.
+	// Every version's publication time is checked against
+	// the date range since backported releases may be newer
+	// than releases with a higher version.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for d := range t.versionDetails(ctx, u, base, mod, latest, filter.page(list, r.retractions), filter) {
+		if d.err != nil {
+			var status statusError
+			if errors.As(d.err, &status) {
+				switch status.code {
+				case http.StatusNotFound, http.StatusGone:
+					continue
+				}
 			}
-			mod = string(f[1])
-			version = string(f[2])
+			return proxyResult{err: d.err}
+		}
+		if !filter.published(d.info.Time) {
+			continue
 		}
-		if pth != "" && mod != "" && version != "" {
-			return pth, mod, version, nil
+		if d.modErr != nil {
+			return proxyResult{err: d.modErr}
 		}
+		r.versions = append(r.versions, d.info)
+		r.retractions = append(r.retractions, d.retractions...)
 	}
-	if sc.Err() != nil {
-		return "", "", "", sc.Err()
-	}
-	if strings.HasPrefix(version, "go") {
-		return path.Join("cmd", path.Base(main)), "std", version, nil
-	}
-	return "", "", "", errors.New("not a go binary or no module information")
+	return r
 }
 
-// install installs the package at the given path at the given version.
-func (u *ugbt) install(ctx context.Context, path, mod, version string, verbose, commands bool) error {
-	if mod == "std" {
-		return u.installStd(ctx, path, version, verbose, commands)
-	}
+// versionDetail is the information and retractions of a module version.
+type versionDetail struct {
+	info        info
+	retractions []retraction
 
-	args := []string{"install"}
-	if verbose {
-		args = append(args, "-v")
-	}
-	if commands {
-		args = append(args, "-x")
-	}
-	args = append(args, path+"@"+version)
-	var buf bytes.Buffer
-	stderr := io.Writer(&buf)
-	if verbose || commands {
-		stderr = io.MultiWriter(os.Stderr, stderr)
-	}
-	err := u.cmd(ctx, nil, stderr, args...).Run()
-	if err != nil {
-		if verbose || commands {
-			return fmt.Errorf("go install: %w", err)
-		}
-		return errors.New(strings.TrimSpace(buf.String()))
-	}
-	return nil
+	// err is the error obtaining the information
+	// and modErr is the error obtaining the
+	// retractions.
+	err, modErr error
 }
 
-// installStd installs the go tool chain and standard library.
-func (u *ugbt) installStd(ctx context.Context, path, version string, verbose, commands bool) error {
-	if version == "latest" {
-		versions, err := u.stdInfo(ctx)
-		if err != nil {
-			return err
+// versionDetails queries the information for each of the versions of the
+// escaped module path held by the proxy at u with the path base, and the
+// retractions declared by each version other than latest that is published
+// within the date range of filter. The versions are queried by a fixed set
+// of workers within the -concurrency limit and the details are sent on the
+// returned channel in the order of the versions as each becomes available.
+// Cancelling ctx abandons the queries that have not started.
+func (t *ugbt) versionDetails(ctx context.Context, u *url.URL, base, mod, latest string, versions []string, filter versionFilter) <-chan versionDetail {
+	done := make([]chan versionDetail, len(versions))
+	for i := range done {
+		done[i] = make(chan versionDetail, 1)
+	}
+	indexes := make(chan int)
+	go func() {
+		defer close(indexes)
+		for i := range versions {
+			select {
+			case indexes <- i:
+			case <-ctx.Done():
+				return
+			}
 		}
-		if len(versions) == 0 {
-			return errors.New("not found")
+	}()
+	workers := t.concurrency()
+	if workers > len(versions) {
+		workers = len(versions)
+	}
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range indexes {
+				v := *u
+				v.Path = path.Join(base, mod, "@v", versions[i])
+				done[i] <- t.versionDetail(ctx, v.String(), versions[i] == latest, filter)
+			}
+		}()
+	}
+	details := make(chan versionDetail)
+	go func() {
+		defer close(details)
+		for _, c := range done {
+			var d versionDetail
+			select {
+			case d = <-c:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case details <- d:
+			case <-ctx.Done():
+				return
+			}
 		}
-		version = versions[0].Version
-	}
-	err := u.install(ctx, "golang.org/dl/"+version, "", "latest", verbose, commands)
-	if err != nil {
-		return err
-	}
-	stderr := io.Discard
-	if verbose {
-		stderr = os.Stderr
-	}
-	cmd := execabs.CommandContext(ctx, version, "download")
-	cmd.Dir = u.wd
-	cmd.Stderr = stderr
-	err = cmd.Run()
+	}()
+	return details
+}
+
+// versionDetail queries the information for the module version at url, and
+// its retractions if it is not the latest version and it is published
+// within the date range of filter.
+func (t *ugbt) versionDetail(ctx context.Context, url string, latest bool, filter versionFilter) versionDetail {
+	var d versionDetail
+	release, err := t.acquireQuery(ctx)
 	if err != nil {
-		return err
+		d.err = err
+		return d
 	}
-	if !verbose {
-		fmt.Fprintf(os.Stderr, "go tool available as %s\n", version)
+	defer release()
+
+	d.info, d.err = t.info(ctx, url)
+	if d.err != nil || latest || !filter.published(d.info.Time) {
+		return d
 	}
-	return nil
+	d.retractions, d.modErr = t.retractions(ctx, url)
+	return d
 }
 
-type info struct {
-	Version             string
-	Time                time.Time
-	isRetracted         bool
-	retractionRationale string
+// concurrency returns the maximum number of concurrent module proxy
+// queries.
+func (u *ugbt) concurrency() int {
+	if u.Concurrency < 1 {
+		return 1
+	}
+	return u.Concurrency
 }
 
-// availableVersions returns the available semver versions from the
-// $GOPROXY version database. Only versions at or after the current
-// version are returned unless all is true.
-func (t *ugbt) availableVersions(ctx context.Context, mod, current string, all bool) ([]info, error) {
-	if mod == "std" {
-		return t.stdInfo(ctx)
+// acquireQuery blocks until a module proxy query may be made within the
+// concurrency limit, and returns a function that releases it. The limit
+// is shared by all the proxy queries made by the command. An error is
+// returned if ctx is cancelled while waiting.
+func (u *ugbt) acquireQuery(ctx context.Context) (release func(), err error) {
+	q := u.queries
+	q.once.Do(func() {
+		q.sema = make(chan struct{}, u.concurrency())
+	})
+	select {
+	case q.sema <- struct{}{}:
+		return func() { <-q.sema }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
+}
+
+// queryLimit is the semaphore limiting concurrent module proxy queries.
+type queryLimit struct {
+	once sync.Once
+	sema chan struct{}
+}
 
+// deprecation returns the deprecation notice in the go.mod file of the
+// latest version of the module recorded by the first $GOPROXY proxy that
+// holds the module. If the module is not deprecated, the empty string is
+// returned.
+func (t *ugbt) deprecation(ctx context.Context, mod string) (string, error) {
 	mod, err := module.EscapePath(mod)
 	if err != nil {
-		return nil, err
-	}
-
-	for _, reason := range []string{
-		"GOPRIVATE",
-		"GONOPROXY",
-	} {
-		private, err := t.isPrivate(ctx, mod, reason)
-		if err != nil {
-			return nil, err
-		}
-		if private {
-			return nil, fmt.Errorf("module %s matches %s", mod, reason)
-		}
+		return "", err
 	}
-
 	proxies, err := t.proxies(ctx)
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-
-	var (
-		versions    []info
-		cli         http.Client
-		retractions []*modfile.Retract
-	)
 	for _, p := range proxies {
 		u, err := url.Parse(p)
 		if err != nil {
-			return nil, err
+			return "", err
 		}
-		u.Path = path.Join(mod, "@v", "list")
-		req, err := http.NewRequest("GET", u.String(), nil)
+		base := u.Path
+		u.Path = path.Join(base, mod, "@latest")
+		buf, err := t.get(ctx, u.String())
 		if err != nil {
-			return nil, err
+			var status statusError
+			if errors.As(err, &status) {
+				switch status.code {
+				case http.StatusNotFound, http.StatusGone:
+					continue
+				}
+			}
+			return "", fmt.Errorf("query proxy: %w", err)
 		}
-		resp, err := cli.Do(req)
+		var latest info
+		err = json.Unmarshal(buf, &latest)
 		if err != nil {
-			return nil, err
+			return "", fmt.Errorf("invalid version information: %w", err)
 		}
-		defer resp.Body.Close()
-
-		sc := bufio.NewScanner(resp.Body)
-		var list []string
-		for sc.Scan() {
-			version := sc.Text()
-			if all || semverCompare(version, current) >= 0 {
-				list = append(list, version)
-			}
+		u.Path = path.Join(base, mod, "@v", latest.Version+".mod")
+		buf, err = t.get(ctx, u.String())
+		if err != nil {
+			return "", fmt.Errorf("query proxy: %w", err)
 		}
-		for _, version := range list {
-			u.Path = path.Join(mod, "@v", version)
-			url := u.String()
-
-			i, err := t.info(ctx, url)
-			if err != nil {
-				var status statusError
-				if errors.As(err, &status) {
-					switch status.code {
-					case http.StatusNotFound, http.StatusGone:
-						continue
-					}
-				}
-				return nil, err
-			}
-			versions = append(versions, i)
-
-			r, err := t.retractions(ctx, url)
-			if err != nil {
-				return nil, err
-			}
-			retractions = append(retractions, r...)
+		f, err := modfile.ParseLax(u.Path, buf, nil)
+		if err != nil {
+			return "", fmt.Errorf("invalid modfile: %w", err)
 		}
-	}
-	versions = unique(versions)
-	for i, v := range versions {
-		for _, r := range retractions {
-			if semver.Compare(v.Version, r.Low) >= 0 && semver.Compare(v.Version, r.High) <= 0 {
-				versions[i].isRetracted = true
-				versions[i].retractionRationale = r.Rationale
-			}
+		if f.Module == nil {
+			return "", nil
 		}
+		return f.Module.Deprecated, nil
 	}
-	return versions, nil
+	return "", nil
 }
 
 // stdInfo returns the information for a Go standard library versions.
 func (u *ugbt) stdInfo(ctx context.Context) ([]info, error) {
-	buf, err := get(ctx, "https://go.dev/dl/?mode=json&include=all")
+	releases, err := u.releases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]info, len(releases))
+	for i, r := range releases {
+		versions[i] = info{Version: r.Version}
+	}
+	// The security annotations are advisory, so don't fail
+	// if the release history is not available.
+	_ = u.markSecurityReleases(ctx, versions)
+	return versions, nil
+}
+
+// release is a Go release published at https://go.dev/dl.
+type release struct {
+	Version string
+	Stable  bool
+	Files   []sdkFile
+}
+
+// releases returns the Go releases published at https://go.dev/dl, newest
+// first.
+func (u *ugbt) releases(ctx context.Context) ([]release, error) {
+	buf, err := u.get(ctx, "https://go.dev/dl/?mode=json&include=all")
 	if err != nil {
 		return nil, fmt.Errorf("query proxy: %w", err)
 	}
-	var versions []info
-	err = json.Unmarshal(buf, &versions)
+	var releases []release
+	err = json.Unmarshal(buf, &releases)
 	if err != nil {
 		return nil, fmt.Errorf("invalid version information: %w", err)
 	}
-	sort.Slice(versions, func(i, j int) bool {
-		return semverCompare(versions[i].Version, versions[j].Version) > 0
+	sort.Slice(releases, func(i, j int) bool {
+		return semverCompare(releases[i].Version, releases[j].Version) > 0
 	})
-	return versions, nil
+	return releases, nil
 }
 
 // info returns the information for a version recorded by a Go proxy.
 func (u *ugbt) info(ctx context.Context, version string) (info, error) {
-	buf, err := get(ctx, version+".info")
+	buf, err := u.get(ctx, version+".info")
 	if err != nil {
 		return info{}, fmt.Errorf("query proxy: %w", err)
 	}
@@ -780,8 +3387,8 @@ func (u *ugbt) info(ctx context.Context, version string) (info, error) {
 }
 
 // retractions returns any retractions noted in the version's modfile.
-func (u *ugbt) retractions(ctx context.Context, version string) ([]*modfile.Retract, error) {
-	buf, err := get(ctx, version+".mod")
+func (u *ugbt) retractions(ctx context.Context, version string) ([]retraction, error) {
+	buf, err := u.get(ctx, version+".mod")
 	if err != nil {
 		return nil, fmt.Errorf("query proxy: %w", err)
 	}
@@ -789,32 +3396,111 @@ func (u *ugbt) retractions(ctx context.Context, version string) ([]*modfile.Retr
 	if err != nil {
 		return nil, fmt.Errorf("invalid modfile: %w", err)
 	}
-	return f.Retract, nil
+	if len(f.Retract) == 0 {
+		return nil, nil
+	}
+	declared := path.Base(version)
+	rs := make([]retraction, len(f.Retract))
+	for i, r := range f.Retract {
+		rs[i] = retraction{Low: r.Low, High: r.High, Rationale: r.Rationale, GoMod: declared}
+	}
+	return rs, nil
 }
 
 // get returns the body of a GET request to the provided URL. Any non 200
-// response status is returned as an error.
-func get(ctx context.Context, url string) ([]byte, error) {
+// response status is returned as an error. Requests that are rejected by
+// rate limiting are retried after the delay requested by the server.
+func (u *ugbt) get(ctx context.Context, url string) ([]byte, error) {
+	if strings.HasPrefix(url, "file://") {
+		return getFile(url)
+	}
+	buf, _, err := u.fetch(ctx, url, nil)
+	return buf, err
+}
+
+// fetch returns the contents of the HTTP url and the response, adding the
+// provided request header fields. A not modified status is returned as a
+// response without error when the request is conditional.
+func (u *ugbt) fetch(ctx context.Context, url string, header http.Header) ([]byte, *http.Response, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
 	}
-	var cli http.Client
-	resp, err := cli.Do(req)
+	resp, err := u.do(req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && len(header) != 0 {
+		io.Copy(io.Discard, resp.Body)
+		return nil, resp, nil
+	}
 	if resp.StatusCode != http.StatusOK {
 		io.Copy(io.Discard, resp.Body)
-		return nil, statusError{status: resp.Status, code: resp.StatusCode}
+		return nil, nil, statusError{status: resp.Status, code: resp.StatusCode}
 	}
 	var buf bytes.Buffer
 	_, err = io.Copy(&buf, resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return buf.Bytes(), resp, nil
+}
+
+// do sends the HTTP request with the shared client, adding any code hosting
+// service credentials, and retries rate limited requests after the wait
+// requested by the server. All of ugbt's HTTP requests are made through do,
+// including those made by modrepo, so that they behave consistently.
+func (u *ugbt) do(req *http.Request) (*http.Response, error) {
+	u.authorize(req)
+	for attempt := 0; ; attempt++ {
+		resp, err := u.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		wait, limited := rateLimitWait(resp, attempt, time.Now())
+		if !limited {
+			return resp, nil
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if attempt >= maxRateLimitRetries || wait > maxRateLimitWait {
+			return nil, rateLimitError{status: resp.Status, wait: wait}
+		}
+		err = sleep(req.Context(), wait)
+		if err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// doFunc adapts a function such as ugbt's do method to the modrepo.Doer
+// interface.
+type doFunc func(*http.Request) (*http.Response, error)
+
+func (f doFunc) Do(req *http.Request) (*http.Response, error) { return f(req) }
+
+// getFile returns the contents of the file at the file URL. A missing file
+// is returned as a not found status error.
+func getFile(fileURL string) ([]byte, error) {
+	u, err := url.Parse(fileURL)
 	if err != nil {
 		return nil, err
 	}
-	return buf.Bytes(), nil
+	buf, err := os.ReadFile(filePath(u))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, statusError{status: http.StatusText(http.StatusNotFound), code: http.StatusNotFound}
+	}
+	return buf, err
 }
 
 // statusError is an HTTP status error.
@@ -826,17 +3512,18 @@ type statusError struct {
 func (e statusError) Error() string { return e.status }
 
 // unique returns version lexically sorted in descending version order
-// and with repeated elements omitted.
+// and with repeated versions omitted. The first occurrence of a repeated
+// version is retained.
 func unique(versions []info) []info {
 	if len(versions) < 2 {
 		return versions
 	}
-	sort.Slice(versions, func(i, j int) bool {
+	sort.SliceStable(versions, func(i, j int) bool {
 		return semver.Compare(versions[i].Version, versions[j].Version) > 0
 	})
 	curr := 0
 	for i, addr := range versions {
-		if addr == versions[curr] {
+		if addr.Version == versions[curr].Version {
 			continue
 		}
 		curr++
@@ -847,23 +3534,206 @@ func unique(versions []info) []info {
 	return versions[:curr+1]
 }
 
-// proxies returns the list of GOPROXY proxies in go env.
+// uniqueRetractions returns the retractions with repeated intervals omitted.
+// Since retractions are typically carried forward into each new go.mod, the
+// retraction from the newest declaring go.mod is retained.
+func uniqueRetractions(retractions []retraction) []retraction {
+	type interval struct{ low, high string }
+	idx := make(map[interval]int)
+	var rs []retraction
+	for _, r := range retractions {
+		k := interval{r.Low, r.High}
+		i, ok := idx[k]
+		if !ok {
+			idx[k] = len(rs)
+			rs = append(rs, r)
+			continue
+		}
+		if semver.Compare(r.GoMod, rs[i].GoMod) > 0 {
+			rs[i] = r
+		}
+	}
+	return rs
+}
+
+// proxies returns the list of GOPROXY proxies in go env, or given by the
+// -goproxy flag. If no proxy is available because GOPROXY is off or direct,
+// the module download cache is used as a proxy and a warning is printed.
 func (u *ugbt) proxies(ctx context.Context) ([]string, error) {
-	goproxy, err := u.goenv(ctx, "GOPROXY")
+	goproxy := u.GOPROXY
+	if goproxy == "" {
+		var err error
+		goproxy, err = u.goenv(ctx, "GOPROXY")
+		if err != nil {
+			return nil, err
+		}
+	}
+	var (
+		proxies []string
+		off     bool
+	)
+	for _, p := range strings.FieldsFunc(goproxy, func(r rune) bool { return r == ',' || r == '|' }) {
+		if p == "off" {
+			// No proxy after off is consulted.
+			off = true
+			break
+		}
+		if p == "direct" {
+			continue
+		}
+		proxies = append(proxies, p)
+	}
+	if len(proxies) != 0 {
+		return proxies, nil
+	}
+
+	cache, err := u.modCacheProxy(ctx)
+	if err != nil {
+		return nil, err
+	}
+	u.proxyWarning.Do(func() {
+		reason := "GOPROXY=direct: ugbt can only query module proxies"
+		if off {
+			reason = "GOPROXY=off: module lookup is disabled"
+		}
+		fprintf(os.Stderr, "warning: %s; using the module cache only so results may be stale\n", reason)
+		fmt.Fprintf(os.Stderr, "\tuse %s -goproxy=https://proxy.golang.org to query a proxy\n", u.name)
+	})
+	return []string{cache}, nil
+}
+
+// offlineEnv returns the environment variables that make the go command
+// use the module download cache as its proxy when GOPROXY is off, so that
+// modules downloaded by the prefetch command or the update -download-only
+// flag can be installed without network access. The go command would
+// otherwise fail to look up module deprecations.
+func (u *ugbt) offlineEnv(ctx context.Context) []string {
+	goproxy := u.GOPROXY
+	if goproxy == "" {
+		var err error
+		goproxy, err = u.goenv(ctx, "GOPROXY")
+		if err != nil {
+			return nil
+		}
+	}
+	if goproxy != "off" {
+		return nil
+	}
+	cache, err := u.modCacheProxy(ctx)
+	if err != nil {
+		return nil
+	}
+	return []string{"GOPROXY=" + cache}
+}
+
+// modCacheProxy returns a file URL for the module download cache, which
+// has the layout of a module proxy.
+func (u *ugbt) modCacheProxy(ctx context.Context) (string, error) {
+	modcache, err := u.goenv(ctx, "GOMODCACHE")
+	if err != nil {
+		return "", err
+	}
+	if modcache == "" {
+		return "", errors.New("no module cache")
+	}
+	dir := filepath.ToSlash(filepath.Join(modcache, "cache", "download"))
+	if !strings.HasPrefix(dir, "/") {
+		// Windows paths.
+		dir = "/" + dir
+	}
+	return (&url.URL{Scheme: "file", Path: dir}).String(), nil
+}
+
+// filePath returns the local file path for a file URL.
+func filePath(u *url.URL) string {
+	p := u.Path
+	if runtime.GOOS == "windows" {
+		p = strings.TrimPrefix(p, "/")
+	}
+	return filepath.FromSlash(p)
+}
+
+// cachedList returns a version list constructed from the version
+// information files held in the module cache for the @v/list URL u.
+func cachedList(u *url.URL) ([]byte, error) {
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(filePath(u)), "*.info"))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, f := range files {
+		fmt.Fprintln(&buf, strings.TrimSuffix(filepath.Base(f), ".info"))
+	}
+	return buf.Bytes(), nil
+}
+
+// modCacheVersions returns the directory in the module download cache that
+// holds the version files of the module. If there is no module cache, the
+// returned directory is empty.
+func (u *ugbt) modCacheVersions(ctx context.Context, mod string) (string, error) {
+	modcache, err := u.goenv(ctx, "GOMODCACHE")
+	if err != nil || modcache == "" {
+		return "", err
+	}
+	emod, err := module.EscapePath(mod)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(modcache, "cache", "download", filepath.FromSlash(emod), "@v"), nil
+}
+
+// cachedZips returns the set of versions of the module whose zip files
+// are held in the module cache, so that installing them does not need
+// to download the module source.
+func (u *ugbt) cachedZips(ctx context.Context, mod string) (map[string]bool, error) {
+	dir, err := u.modCacheVersions(ctx, mod)
+	if err != nil || dir == "" {
+		return nil, err
+	}
+	zips, err := filepath.Glob(filepath.Join(dir, "*.zip"))
 	if err != nil {
 		return nil, err
 	}
-	var proxies []string
-	for _, p := range strings.Split(goproxy, ",") {
-		if p == "off" || p == "direct" {
+	cached := make(map[string]bool)
+	for _, z := range zips {
+		v, err := module.UnescapeVersion(strings.TrimSuffix(filepath.Base(z), ".zip"))
+		if err != nil {
 			continue
 		}
-		proxies = append(proxies, p)
+		cached[v] = true
+	}
+	return cached, nil
+}
+
+// debugf prints debugging information to stderr if the -debug flag is set.
+func (u *ugbt) debugf(format string, args ...interface{}) {
+	if !u.Debug {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "debug: "+format+"\n", args...)
+}
+
+// warnStale writes a warning to w noting when the module cache information
+// at the @v/list URL u was last updated.
+func warnStale(w io.Writer, mod string, u *url.URL) {
+	dir := filepath.Dir(filePath(u))
+	var last time.Time
+	files, _ := os.ReadDir(dir)
+	for _, f := range files {
+		fi, err := f.Info()
+		if err == nil && fi.ModTime().After(last) {
+			last = fi.ModTime()
+		}
+	}
+	if last.IsZero() {
+		fprintf(w, "warning: no module cache information for %s\n", mod)
+		return
 	}
-	return proxies, nil
+	fprintf(w, "warning: module cache information for %s was last updated %s\n", mod, last.Format("_2 Jan 2006 15:04"))
 }
 
-// isPrivate returns whether the module matches any GOPRIVATE or GONOPROXY pattern.
+// isPrivate returns whether the module matches any pattern in the
+// GOPRIVATE, GONOPROXY or GONOSUMDB go env variable given by reason.
 func (u *ugbt) isPrivate(ctx context.Context, mod, reason string) (bool, error) {
 	patterns, err := u.goenv(ctx, reason)
 	if err != nil {
@@ -872,19 +3742,136 @@ func (u *ugbt) isPrivate(ctx context.Context, mod, reason string) (bool, error)
 	return module.MatchPrefixPatterns(patterns, mod), nil
 }
 
-// goenv returns the requested go env variable.
+// goenv returns the requested go env variable. The complete go env is
+// obtained once per run and cached; variables that are not reported in
+// the complete go env are queried individually.
 func (u *ugbt) goenv(ctx context.Context, name string) (string, error) {
+	u.goenvMu.Lock()
+	defer u.goenvMu.Unlock()
+	if u.goenvCache == nil {
+		env, err := u.goenvJSON(ctx)
+		if err != nil {
+			return "", err
+		}
+		u.goenvCache = env
+	}
+	if v, ok := u.goenvCache[name]; ok {
+		return v, nil
+	}
+	env, err := u.goenvJSON(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	v := env[name]
+	u.goenvCache[name] = v
+	return v, nil
+}
+
+// goenvJSON returns the go env variables reported by go env -json for
+// the provided names, or all variables if no name is given.
+func (u *ugbt) goenvJSON(ctx context.Context, names ...string) (map[string]string, error) {
 	var stdout, stderr bytes.Buffer
-	err := u.cmd(ctx, &stdout, &stderr, "env", name).Run()
+	err := u.cmd(ctx, &stdout, &stderr, append([]string{"env", "-json"}, names...)...).Run()
+	if errors.Is(err, exec.ErrNotFound) {
+		u.debugf("no go command: using default go env")
+		return u.defaultGoenv(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", &stderr, err)
+	}
+	var env map[string]string
+	err = json.Unmarshal(stdout.Bytes(), &env)
 	if err != nil {
-		return "", fmt.Errorf("%s: %w", &stderr, err)
+		return nil, fmt.Errorf("invalid go env output: %w", err)
+	}
+	if env == nil {
+		env = make(map[string]string)
 	}
-	return strings.TrimSpace(stdout.String()), nil
+	return env, nil
 }
 
-// cmd is a go command runner helper.
+// defaultGoenv returns the go env variables used by ugbt as the go command
+// would report them, for use when no Go toolchain is installed. Values are
+// taken from the environment, then from the go env configuration file, and
+// then from the go command defaults.
+func (u *ugbt) defaultGoenv() map[string]string {
+	file := make(map[string]string)
+	path := u.getenv("GOENV")
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err == nil {
+			path = filepath.Join(dir, "go", "env")
+		}
+	}
+	if path != "" && path != "off" {
+		buf, _ := os.ReadFile(path)
+		for _, line := range strings.Split(string(buf), "\n") {
+			k, v, ok := strings.Cut(strings.TrimSpace(line), "=")
+			if ok {
+				file[k] = v
+			}
+		}
+	}
+	get := func(name, def string) string {
+		if v := u.getenv(name); v != "" {
+			return v
+		}
+		if v := file[name]; v != "" {
+			return v
+		}
+		return def
+	}
+
+	env := map[string]string{
+		"GOPROXY":   get("GOPROXY", "https://proxy.golang.org,direct"),
+		"GOSUMDB":   get("GOSUMDB", "sum.golang.org"),
+		"GOPRIVATE": get("GOPRIVATE", ""),
+		"GOBIN":     get("GOBIN", ""),
+		"GOFLAGS":   get("GOFLAGS", ""),
+	}
+	env["GONOPROXY"] = get("GONOPROXY", env["GOPRIVATE"])
+	env["GONOSUMDB"] = get("GONOSUMDB", env["GOPRIVATE"])
+	var gopath string
+	if home, err := os.UserHomeDir(); err == nil {
+		gopath = filepath.Join(home, "go")
+	}
+	env["GOPATH"] = get("GOPATH", gopath)
+	var modcache string
+	if list := filepath.SplitList(env["GOPATH"]); len(list) > 0 && list[0] != "" {
+		modcache = filepath.Join(list[0], "pkg", "mod")
+	}
+	env["GOMODCACHE"] = get("GOMODCACHE", modcache)
+	// GOVERSION is left empty since there is no toolchain.
+	env["GOVERSION"] = ""
+	return env
+}
+
+// getenv returns the value of the environment variable, taking values
+// from the environment provided to newUggboot in preference to the
+// process environment.
+func (u *ugbt) getenv(name string) string {
+	for i := len(u.env) - 1; i >= 0; i-- {
+		k, v, ok := strings.Cut(u.env[i], "=")
+		if ok && k == name {
+			return v
+		}
+	}
+	return os.Getenv(name)
+}
+
+// cmd is a go command runner helper. The environment provided to
+// newUggboot is added to the process environment of the command.
 func (u *ugbt) cmd(ctx context.Context, stdout, stderr io.Writer, args ...string) *execabs.Cmd {
 	cmd := execabs.CommandContext(ctx, "go", args...)
+	if len(u.env) != 0 {
+		cmd.Env = append(os.Environ(), u.env...)
+	}
+	if u.GOPROXY != "" {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, "GOPROXY="+u.GOPROXY)
+	}
 	cmd.Dir = u.wd
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
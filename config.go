@@ -0,0 +1,366 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// config is the ugbt user configuration. It is stored as JSON in
+// ugbt/config.json in the user's configuration directory, or at the
+// path given by the -config flag or the UGBT_CONFIG environment variable.
+type config struct {
+	// Install holds the default build options used by the install
+	// and update commands.
+	Install installConfig `json:"install"`
+
+	// Backup holds the configuration for backups of executables
+	// that are replaced by the install and update commands.
+	Backup backupConfig `json:"backup"`
+
+	// Forge holds the credentials used for requests to code
+	// hosting service APIs.
+	Forge forgeConfig `json:"forge"`
+
+	// Proxy holds the module proxy configuration.
+	Proxy proxyConfig `json:"proxy"`
+
+	// Scan holds the configuration for scans of the install
+	// directory by bulk commands.
+	Scan scanConfig `json:"scan"`
+
+	// Telemetry holds the opt-in usage telemetry configuration.
+	Telemetry telemetryConfig `json:"telemetry"`
+
+	// Notify holds the configuration for update hints printed
+	// after commands.
+	Notify notifyConfig `json:"notify"`
+
+	// Policy holds the location of the team policy applied by the
+	// audit and update commands.
+	Policy policyConfig `json:"policy"`
+
+	// Bundle holds the configuration for the manifests read by
+	// the bundle command.
+	Bundle bundleConfig `json:"bundle"`
+
+	// Vuln holds the configuration for vulnerability queries
+	// made by the audit command.
+	Vuln vulnConfig `json:"vuln"`
+
+	// Tools maps executable names to the packages they are built
+	// from, for executables with absent or incorrect build
+	// information.
+	Tools map[string]toolConfig `json:"tools,omitempty"`
+
+	// Namespaces maps module path prefixes to the modules listed
+	// by list -module-prefix in place of the modules discovered
+	// from the module index.
+	Namespaces map[string][]string `json:"namespaces,omitempty"`
+}
+
+// installConfig holds default build options.
+type installConfig struct {
+	TrimPath bool `json:"trimpath"`
+	Strip    bool `json:"strip"`
+
+	// Sandbox is the default sandbox used for builds, "env" or
+	// "bwrap". If empty, builds are not sandboxed.
+	Sandbox string `json:"sandbox"`
+
+	// Probe specifies that installed executables are health
+	// probed and restored from their backup if the probe fails.
+	Probe bool `json:"probe"`
+}
+
+// backupConfig holds the configuration for backups of replaced executables.
+type backupConfig struct {
+	// Enabled specifies that executables are backed up before
+	// they are replaced.
+	Enabled bool `json:"enabled"`
+
+	// Dir is the backup directory. If empty, ugbt/backups in the
+	// user cache directory is used.
+	Dir string `json:"dir"`
+
+	// Keep is the number of backups to retain for each executable.
+	// If zero, all backups are retained.
+	Keep int `json:"keep"`
+
+	// MaxAge is the maximum age of retained backups. If zero, backups
+	// are retained regardless of their age.
+	MaxAge duration `json:"max_age"`
+}
+
+// forgeConfig holds code hosting service API credentials. Credentials
+// in the environment take precedence over those in the config.
+type forgeConfig struct {
+	// GitHubToken is the token used for GitHub API requests.
+	// If it is empty and no token is set in the GH_TOKEN or
+	// GITHUB_TOKEN environment variables, the token held by
+	// the gh command is used if available.
+	GitHubToken string `json:"github_token"`
+
+	// GitLabToken is the token used for GitLab API requests.
+	// It is overridden by the GITLAB_TOKEN environment variable.
+	GitLabToken string `json:"gitlab_token"`
+}
+
+// proxyConfig holds the module proxy configuration.
+type proxyConfig struct {
+	// GOPROXY is the module proxy list used in place of the go env
+	// GOPROXY value. It is overridden by the -goproxy flag.
+	GOPROXY string `json:"goproxy"`
+}
+
+// policyConfig holds the location of the team policy.
+type policyConfig struct {
+	// Source is the path or http or https URL of the team policy
+	// file. A leading ~ in a path refers to the user's home
+	// directory. If empty, no policy is applied.
+	Source string `json:"source"`
+
+	// Signature holds the configuration for verifying a
+	// detached signature of the policy before it is used.
+	Signature signatureConfig `json:"signature"`
+}
+
+// bundleConfig holds the configuration for bundle manifests.
+type bundleConfig struct {
+	// Signature holds the configuration for verifying a
+	// detached signature of the manifest before it is used.
+	Signature signatureConfig `json:"signature"`
+}
+
+// vulnConfig holds the configuration for OSV vulnerability queries.
+type vulnConfig struct {
+	// API is the root URL of the OSV API. If empty,
+	// https://api.osv.dev is used.
+	API string `json:"api"`
+
+	// CacheTTL is how long query results are cached. If zero,
+	// results are cached for a day.
+	CacheTTL duration `json:"cache_ttl"`
+
+	// Severity, FailSeverity and FailFixed are the defaults
+	// for the audit -severity, -fail-severity and -fail-fixed
+	// flags.
+	Severity     string `json:"severity"`
+	FailSeverity string `json:"fail_severity"`
+	FailFixed    bool   `json:"fail_fixed"`
+}
+
+// signatureConfig holds the configuration for verifying the detached
+// signature of a file.
+type signatureConfig struct {
+	// Format is the signature format, one of "ssh", "minisign"
+	// or "cosign". If empty, no signature is required.
+	Format string `json:"format"`
+
+	// Key is the path of the public key for minisign and cosign
+	// signatures, or of the allowed signers file for ssh
+	// signatures.
+	Key string `json:"key"`
+
+	// Identity is the signer identity required for ssh
+	// signatures.
+	Identity string `json:"identity,omitempty"`
+
+	// Location is the path or http or https URL of the
+	// signature. If empty, it is the location of the signed file
+	// with ".minisig" appended for minisign signatures and ".sig"
+	// appended otherwise.
+	Location string `json:"location,omitempty"`
+}
+
+// notifyConfig holds the configuration for update hints.
+type notifyConfig struct {
+	// Disabled suppresses the hints printed after commands
+	// when the executables they act on have updates.
+	Disabled bool `json:"disabled"`
+
+	// DisableSelfCheck suppresses the daily check for a new
+	// ugbt release.
+	DisableSelfCheck bool `json:"disable_self_check"`
+}
+
+// scanConfig holds the configuration for bulk scans of executables.
+type scanConfig struct {
+	// Dirs is a list of directories to scan for Go executables in
+	// addition to the install directory and the bin directories of
+	// the GOPATH elements. A leading ~ refers to the user's home
+	// directory.
+	Dirs []string `json:"dirs"`
+
+	// ExcludePaths is a list of directories whose executables are
+	// never acted on by bulk commands, for directories managed by
+	// other systems. A leading ~ refers to the user's home directory.
+	ExcludePaths []string `json:"exclude_paths"`
+
+	// Toolchain specifies that the executables of the active Go
+	// toolchain, the go and gofmt commands and the commands run
+	// by go tool, are included in scans. Their versions are the
+	// Go release that they are part of.
+	Toolchain bool `json:"toolchain"`
+}
+
+// excluded returns whether the executable at path is in a directory
+// excluded from scans. Symbolic links are followed so that links to
+// executables in excluded directories are also excluded.
+func (c scanConfig) excluded(path string) bool {
+	candidates := []string{path}
+	if resolved, err := filepath.EvalSymlinks(path); err == nil && resolved != path {
+		candidates = append(candidates, resolved)
+	}
+	for _, dir := range c.ExcludePaths {
+		dir = expandHome(dir)
+		if dir == "" {
+			continue
+		}
+		for _, p := range candidates {
+			rel, err := filepath.Rel(dir, p)
+			if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// expandHome returns path with a leading ~ replaced by the user's home
+// directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, `~\`) {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// toolConfig holds the package information for an executable.
+type toolConfig struct {
+	// Package is the package path of the executable.
+	Package string `json:"package"`
+
+	// Source is the package path of a fork of the executable's
+	// package to install and update from in place of the package
+	// recorded in the build information or given by Package.
+	Source string `json:"source"`
+
+	// Module is the module path of the package or source. If
+	// empty, the module is found by querying GOPROXY for the
+	// longest prefix of the package path that is a module.
+	Module string `json:"module"`
+
+	// Suffix is the pre-release pattern used by the update
+	// command in place of the default -suffix value.
+	Suffix string `json:"suffix"`
+
+	// Go is the Go release, such as go1.21.5, that the executable
+	// is built with by install and update, using the golang.org/dl
+	// wrapper for the release. If empty, the go command is used.
+	Go string `json:"go"`
+
+	// Probe is the arguments the executable is run with to check
+	// its health after it has been installed. If empty, -h is used.
+	Probe []string `json:"probe"`
+}
+
+// duration is a time.Duration that is represented in JSON as a
+// string accepted by time.ParseDuration.
+type duration time.Duration
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// configPath returns the path to the ugbt configuration file.
+func configPath() (string, error) {
+	if path := os.Getenv("UGBT_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ugbt", "config.json"), nil
+}
+
+// loadConfig returns the configuration stored at path. If path is empty
+// the default configuration path is used. A missing configuration file
+// is not an error and results in the zero configuration.
+func loadConfig(path string) (config, error) {
+	if path == "" {
+		var err error
+		path, err = configPath()
+		if err != nil {
+			// No configuration location, so use the defaults.
+			return config{}, nil
+		}
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return config{}, nil
+		}
+		return config{}, err
+	}
+	var cfg config
+	err = json.Unmarshal(b, &cfg)
+	if err != nil {
+		return config{}, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// saveConfig writes the configuration to path and returns the path written.
+// If path is empty the default configuration path is used.
+func saveConfig(path string, cfg config) (string, error) {
+	if path == "" {
+		var err error
+		path, err = configPath()
+		if err != nil {
+			return "", err
+		}
+	}
+	b, err := json.MarshalIndent(cfg, "", "\t")
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return "", err
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, append(b, '\n'), 0o600)
+	if err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp, path)
+}
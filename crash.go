@@ -0,0 +1,175 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+// crashDir returns the directory holding crash reports.
+func crashDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ugbt", "crashes"), nil
+}
+
+// reportCrash must be deferred by the caller of a command. If the command
+// panics, reportCrash writes a crash report holding the stack, the ugbt
+// version and the sanitized environment, and prints the panic and how to
+// file an issue with the report before exiting.
+func (u *ugbt) reportCrash(command string) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	stack := debug.Stack()
+	fmt.Fprintf(os.Stderr, "panic: %v\n\n%s\n", r, stack)
+	path, err := writeCrashReport(command, r, stack)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not write crash report: %v\n", err)
+	} else {
+		fmt.Fprintf(os.Stderr, "ugbt crashed; please report this with: %s bugs -o -attach %s\n", u.name, path)
+	}
+	os.Exit(2)
+}
+
+// writeCrashReport writes a crash report for the panic value r with the
+// provided stack and returns its path.
+func writeCrashReport(command string, r interface{}, stack []byte) (string, error) {
+	dir, err := crashDir()
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	version := "(unknown)"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		version = builtModule(info).Version
+	}
+	fmt.Fprintf(&buf, "ugbt version: %s\n", version)
+	fmt.Fprintf(&buf, "go version: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&buf, "command: %s\n", command)
+	fmt.Fprintf(&buf, "\npanic: %s\n\n%s\n", sanitize(fmt.Sprint(r)), sanitize(string(stack)))
+	fmt.Fprintln(&buf, "environment:")
+	for _, kv := range crashEnv() {
+		fmt.Fprintf(&buf, "%s\n", kv)
+	}
+
+	f, err := os.CreateTemp(dir, time.Now().UTC().Format("20060102T150405Z")+"-*.txt")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(buf.Bytes())
+	if err != nil {
+		f.Close()
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// maxIssueBody is the maximum length of the crash report included in a
+// prefilled issue URL, keeping the URL within the limits of browsers and
+// code hosting services.
+const maxIssueBody = 6000
+
+// newIssueURL returns a URL for a new issue in the tracker at issues that
+// is prefilled with the crash report at path. Only GitHub and GitLab
+// trackers are supported.
+func newIssueURL(issues, path string) (string, error) {
+	report, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if len(report) > maxIssueBody {
+		report = append(report[:maxIssueBody:maxIssueBody], "\n[report truncated]\n"...)
+	}
+	title := "ugbt: crash"
+	if i := bytes.Index(report, []byte("\ncommand: ")); i >= 0 {
+		cmd, _, _ := strings.Cut(string(report[i+len("\ncommand: "):]), "\n")
+		title += " in " + cmd
+	}
+	body := "<!-- Please describe what you were doing when ugbt crashed. -->\n\n```\n" + string(report) + "```\n"
+
+	u, err := url.Parse(issues)
+	if err != nil {
+		return "", err
+	}
+	q := make(url.Values)
+	switch {
+	case u.Host == "github.com" && strings.HasSuffix(u.Path, "/issues"):
+		u.Path += "/new"
+		q.Set("title", title)
+		q.Set("body", body)
+	case strings.HasSuffix(u.Path, "/-/issues"):
+		u.Path += "/new"
+		q.Set("issue[title]", title)
+		q.Set("issue[description]", body)
+	default:
+		return "", fmt.Errorf("cannot prefill an issue at %s: attach %s to a new issue", issues, path)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// secretName matches the names of environment variables that may hold
+// credentials.
+var secretName = regexp.MustCompile(`(?i)TOKEN|SECRET|PASSWORD|PASSWD|AUTH|KEY|CREDENTIAL`)
+
+// crashEnv returns the sanitized environment variables relevant to ugbt,
+// sorted by name. Only Go and ugbt variables are included, variables that
+// may hold credentials are omitted and values are sanitized.
+func crashEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, val, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		if !strings.HasPrefix(name, "GO") && !strings.HasPrefix(name, "UGBT_") && !strings.HasPrefix(name, "CGO") {
+			continue
+		}
+		if secretName.MatchString(name) {
+			continue
+		}
+		env = append(env, name+"="+sanitize(val))
+	}
+	sort.Strings(env)
+	return env
+}
+
+// urlUserinfo matches the userinfo part of URLs.
+var urlUserinfo = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s@,|]+@`)
+
+// sanitize returns s with user information removed from URLs and with the
+// user's home directory replaced with $HOME.
+func sanitize(s string) string {
+	s = urlUserinfo.ReplaceAllStringFunc(s, func(m string) string {
+		u, err := url.Parse(m + "host")
+		if err != nil || u.User == nil {
+			return m
+		}
+		return u.Scheme + "://"
+	})
+	if home, err := os.UserHomeDir(); err == nil && len(home) > 1 {
+		s = strings.ReplaceAll(s, home, "$HOME")
+	}
+	return s
+}
@@ -0,0 +1,123 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// depsDevAPI is the root of the deps.dev API.
+const depsDevAPI = "https://api.deps.dev/v3"
+
+// health is the maintenance health signal for a module version reported
+// by deps.dev.
+type health struct {
+	// Project is the source repository project
+	// of the module, if it is known.
+	Project string `json:"project,omitempty"`
+
+	// Scorecard is the overall OpenSSF Scorecard
+	// score of the project, or nil if the project
+	// has not been scored.
+	Scorecard *float64 `json:"scorecard,omitempty"`
+
+	// Advisories are the IDs of the security
+	// advisories affecting the version.
+	Advisories []string `json:"advisories"`
+}
+
+// scorecard returns the formatted scorecard score, or "-" if the project
+// has not been scored.
+func (h health) scorecard() string {
+	if h.Scorecard == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f", *h.Scorecard)
+}
+
+// moduleHealth returns the deps.dev maintenance health signal for the
+// module at version.
+func (u *ugbt) moduleHealth(ctx context.Context, mod, version string) (health, error) {
+	if mod == "std" {
+		return health{}, errors.New("deps.dev does not report on the standard library")
+	}
+	buf, err := u.get(ctx, fmt.Sprintf("%s/systems/go/packages/%s/versions/%s",
+		depsDevAPI, url.PathEscape(mod), url.PathEscape(version)))
+	if err != nil {
+		var status statusError
+		if errors.As(err, &status) && status.code == http.StatusNotFound {
+			return health{}, fmt.Errorf("deps.dev has no record of %s@%s", mod, version)
+		}
+		return health{}, fmt.Errorf("deps.dev: %w", err)
+	}
+	var v struct {
+		AdvisoryKeys []struct {
+			ID string `json:"id"`
+		} `json:"advisoryKeys"`
+		RelatedProjects []struct {
+			ProjectKey struct {
+				ID string `json:"id"`
+			} `json:"projectKey"`
+			RelationType string `json:"relationType"`
+		} `json:"relatedProjects"`
+	}
+	err = json.Unmarshal(buf, &v)
+	if err != nil {
+		return health{}, fmt.Errorf("invalid deps.dev version response: %w", err)
+	}
+	h := health{Advisories: []string{}}
+	for _, a := range v.AdvisoryKeys {
+		h.Advisories = append(h.Advisories, a.ID)
+	}
+	for _, p := range v.RelatedProjects {
+		if p.RelationType == "SOURCE_REPO" {
+			h.Project = p.ProjectKey.ID
+			break
+		}
+	}
+	if h.Project == "" {
+		return h, nil
+	}
+
+	buf, err = u.get(ctx, depsDevAPI+"/projects/"+url.PathEscape(h.Project))
+	if err != nil {
+		var status statusError
+		if errors.As(err, &status) && status.code == http.StatusNotFound {
+			return h, nil
+		}
+		return h, fmt.Errorf("deps.dev: %w", err)
+	}
+	var p struct {
+		Scorecard *struct {
+			OverallScore float64 `json:"overallScore"`
+		} `json:"scorecard"`
+	}
+	err = json.Unmarshal(buf, &p)
+	if err != nil {
+		return h, fmt.Errorf("invalid deps.dev project response: %w", err)
+	}
+	if p.Scorecard != nil {
+		h.Scorecard = &p.Scorecard.OverallScore
+	}
+	return h, nil
+}
+
+// formatAdvisories returns a summary of the advisories for display.
+func formatAdvisories(ids []string) string {
+	switch len(ids) {
+	case 0:
+		return "no advisories"
+	case 1:
+		return "1 advisory (" + ids[0] + ")"
+	default:
+		return fmt.Sprintf("%d advisories (%s)", len(ids), strings.Join(ids, ", "))
+	}
+}
@@ -0,0 +1,441 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// doctor implements the doctor command.
+type doctor struct {
+	*ugbt
+
+	Proxies bool `flag:"proxies" help:"benchmark the GOPROXY proxies and suggest an ordering."`
+	Probes  int  `flag:"n" help:"number of requests made to each proxy by -proxies."`
+	Record  bool `flag:"record" help:"record the suggested proxy ordering in the ugbt config."`
+}
+
+func (*doctor) Name() string      { return "doctor" }
+func (*doctor) Usage() string     { return "" }
+func (*doctor) ShortHelp() string { return text("diagnose problems with the ugbt environment") }
+func (*doctor) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The doctor command checks the environment that ugbt depends on and prints
+the result of each check with a hint for how to resolve any failure. It
+checks for the go command, that the install directory is writable and in
+PATH, that each GOPROXY proxy and the GOSUMDB checksum database can be
+reached, and that the ugbt and module cache directories are writable.
+
+If the -proxies flag is given, the other checks are not made. Instead, each
+GOPROXY proxy is sent -n requests and its median latency and error rate are
+printed, followed by a GOPROXY value ordering the proxies by error rate and
+then latency. If the -record flag is also given, the ordering is recorded
+in the "goproxy" field of the "proxy" section of the ugbt config, where it
+is used in place of the go env GOPROXY value.
+
+`))
+	f.PrintDefaults()
+}
+
+// check is the result of a doctor check.
+type check struct {
+	name   string
+	detail string
+	err    error
+
+	// hint is a suggestion for resolving a failed check.
+	hint string
+}
+
+// Run runs the ugbt doctor command.
+func (d *doctor) Run(ctx context.Context, args ...string) error {
+	if len(args) != 0 {
+		return errors.New("doctor does not accept arguments")
+	}
+	if d.Record && !d.Proxies {
+		return errors.New("doctor -record requires -proxies")
+	}
+	if d.Proxies {
+		return d.benchProxies(ctx)
+	}
+	var failed int
+	for _, c := range d.checks(ctx) {
+		if c.err != nil {
+			failed++
+			fmt.Printf("FAIL %s: %v\n", c.name, c.err)
+			if c.hint != "" {
+				fmt.Printf("     %s\n", c.hint)
+			}
+			continue
+		}
+		fmt.Printf("ok   %s: %s\n", c.name, c.detail)
+	}
+	switch failed {
+	case 0:
+		return nil
+	case 1:
+		return errors.New("1 check failed")
+	default:
+		return fmt.Errorf("%d checks failed", failed)
+	}
+}
+
+// checks returns the results of the doctor checks.
+func (d *doctor) checks(ctx context.Context) []check {
+	checks := []check{d.checkGo(ctx)}
+	checks = append(checks, d.checkBinDir(ctx)...)
+	checks = append(checks, d.checkProxies(ctx)...)
+	checks = append(checks, d.checkSumDB(ctx))
+	checks = append(checks, d.checkCaches(ctx)...)
+	return checks
+}
+
+// checkGo checks for the go command.
+func (d *doctor) checkGo(ctx context.Context) check {
+	c := check{name: "go command"}
+	path, err := exec.LookPath("go")
+	if err != nil {
+		c.err = err
+		c.hint = "install Go from https://go.dev/dl to use the install and update commands"
+		return c
+	}
+	version, err := d.goenv(ctx, "GOVERSION")
+	if err != nil {
+		c.err = err
+		return c
+	}
+	c.detail = fmt.Sprintf("%s at %s", version, path)
+	return c
+}
+
+// checkBinDir checks that the install directory is writable and in PATH.
+func (d *doctor) checkBinDir(ctx context.Context) []check {
+	write := check{name: "install directory"}
+	dir, err := d.binDir(ctx)
+	if err != nil {
+		write.err = err
+		write.hint = "set GOBIN or GOPATH with go env -w"
+		return []check{write}
+	}
+	write.detail = dir + " is writable"
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		// go install creates the directory when needed.
+		write.detail = dir + " can be created"
+	}
+	write.err = writable(dir)
+	if write.err != nil {
+		write.hint = "create the directory or change its permissions, or set GOBIN with go env -w"
+	}
+
+	path := check{name: "PATH", detail: dir + " is in PATH"}
+	var inPath bool
+	for _, p := range filepath.SplitList(d.getenv("PATH")) {
+		if sameFile(p, dir) {
+			inPath = true
+			break
+		}
+	}
+	if !inPath {
+		path.err = fmt.Errorf("%s is not in PATH", dir)
+		path.hint = "add " + dir + " to PATH so that installed executables are run"
+	}
+	return []check{write, path}
+}
+
+// checkProxies checks that each GOPROXY proxy can be reached.
+func (d *doctor) checkProxies(ctx context.Context) []check {
+	goproxy := d.GOPROXY
+	if goproxy == "" {
+		var err error
+		goproxy, err = d.goenv(ctx, "GOPROXY")
+		if err != nil {
+			return []check{{name: "GOPROXY", err: err}}
+		}
+	}
+	var checks []check
+	for _, p := range strings.FieldsFunc(goproxy, func(r rune) bool { return r == ',' || r == '|' }) {
+		if p == "off" {
+			break
+		}
+		if p == "direct" {
+			continue
+		}
+		c := check{name: "proxy " + p}
+		latency, err := d.probeProxy(ctx, p)
+		if err != nil {
+			c.err = err
+			c.hint = "check the proxy URL and network access, or remove it from GOPROXY"
+		} else {
+			c.detail = fmt.Sprintf("reachable in %v", latency.Round(time.Millisecond))
+		}
+		checks = append(checks, c)
+	}
+	if len(checks) == 0 {
+		checks = append(checks, check{
+			name: "GOPROXY",
+			err:  fmt.Errorf("no module proxy in GOPROXY=%s", goproxy),
+			hint: "ugbt can only use the module cache; set GOPROXY=https://proxy.golang.org,direct with go env -w",
+		})
+	}
+	return checks
+}
+
+// probeModule is the module used to probe module proxies.
+const probeModule = "golang.org/x/mod"
+
+// probeProxy returns the time taken to obtain the version list of a well
+// known module from the proxy.
+func (d *doctor) probeProxy(ctx context.Context, proxy string) (time.Duration, error) {
+	start := time.Now()
+	_, err := d.get(ctx, strings.TrimSuffix(proxy, "/")+"/"+probeModule+"/@v/list")
+	return time.Since(start), err
+}
+
+// checkSumDB checks that the checksum database can be reached.
+func (d *doctor) checkSumDB(ctx context.Context) check {
+	c := check{name: "GOSUMDB"}
+	sum, err := d.sumDB(ctx)
+	if err != nil {
+		c.err = err
+		return c
+	}
+	if sum == nil {
+		c.detail = "off"
+		return c
+	}
+	start := time.Now()
+	_, err = d.get(ctx, sum.ops.url+"/latest")
+	if err != nil {
+		c.err = err
+		c.hint = "check network access to " + sum.ops.url + ", or set GOSUMDB with go env -w"
+		return c
+	}
+	c.detail = fmt.Sprintf("%s reachable in %v", sum.ops.url, time.Since(start).Round(time.Millisecond))
+	return c
+}
+
+// checkCaches checks that the ugbt and module cache directories are
+// writable.
+func (d *doctor) checkCaches(ctx context.Context) []check {
+	ugbt := check{name: "ugbt cache"}
+	dir, err := os.UserCacheDir()
+	if err == nil {
+		dir = filepath.Join(dir, "ugbt")
+		err = writable(dir)
+	}
+	ugbt.err = err
+	ugbt.detail = dir + " is writable"
+	if err != nil {
+		ugbt.hint = "change the permissions of the user cache directory"
+	}
+
+	mod := check{name: "module cache"}
+	modcache, err := d.goenv(ctx, "GOMODCACHE")
+	if err == nil && modcache == "" {
+		err = errors.New("GOMODCACHE is not set")
+	}
+	if err == nil {
+		err = writable(modcache)
+	}
+	mod.err = err
+	mod.detail = modcache + " is writable"
+	if err != nil {
+		mod.hint = "set GOMODCACHE with go env -w or change the permissions of the module cache"
+	}
+	return []check{ugbt, mod}
+}
+
+// writable returns an error if a file can not be created in dir. If dir
+// does not exist, its nearest existing parent is checked.
+func writable(dir string) error {
+	for {
+		_, err := os.Stat(dir)
+		if !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	f, err := os.CreateTemp(dir, ".ugbt-doctor-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	_, err = io.WriteString(f, "ugbt")
+	f.Close()
+	os.Remove(name)
+	return err
+}
+
+// proxyBench is the result of benchmarking a proxy.
+type proxyBench struct {
+	proxy   string
+	median  time.Duration
+	errors  int
+	lastErr error
+}
+
+// proxyEntry is an entry of a GOPROXY list.
+type proxyEntry struct {
+	proxy string
+
+	// sep is the separator following the entry, "," or "|", or
+	// empty for the last entry.
+	sep string
+
+	// bench is the index of the benchmark result for the proxy,
+	// or -1 for direct and off.
+	bench int
+}
+
+// splitGOPROXY returns the entries of the GOPROXY list, each with the
+// separator that follows it in the list.
+func splitGOPROXY(goproxy string) []proxyEntry {
+	var entries []proxyEntry
+	for goproxy != "" {
+		i := strings.IndexAny(goproxy, ",|")
+		e := proxyEntry{proxy: goproxy, bench: -1}
+		if i < 0 {
+			goproxy = ""
+		} else {
+			e.proxy, e.sep, goproxy = goproxy[:i], goproxy[i:i+1], goproxy[i+1:]
+		}
+		if e.proxy == "" {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// benchProxies runs the doctor -proxies benchmark.
+func (d *doctor) benchProxies(ctx context.Context) error {
+	if d.Probes < 1 {
+		return errors.New("doctor -n must be positive")
+	}
+	goproxy := d.GOPROXY
+	if goproxy == "" {
+		var err error
+		goproxy, err = d.goenv(ctx, "GOPROXY")
+		if err != nil {
+			return err
+		}
+	}
+	var (
+		entries []proxyEntry
+		results []proxyBench
+	)
+	for _, e := range splitGOPROXY(goproxy) {
+		if e.proxy == "off" || e.proxy == "direct" {
+			// Retain the fallback in place.
+			entries = append(entries, e)
+			if e.proxy == "off" {
+				break
+			}
+			continue
+		}
+		e.bench = len(results)
+		entries = append(entries, e)
+		results = append(results, d.benchProxy(ctx, e.proxy))
+	}
+	if len(results) == 0 {
+		return fmt.Errorf("no module proxy in GOPROXY=%s", goproxy)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\tmedian %v\terrors %d/%d", r.proxy, r.median.Round(time.Millisecond), r.errors, d.Probes)
+		if r.lastErr != nil {
+			fmt.Fprintf(w, "\t%v", r.lastErr)
+		}
+		fmt.Fprintln(w)
+	}
+	err := w.Flush()
+	if err != nil {
+		return err
+	}
+
+	less := func(a, b proxyEntry) bool {
+		ra, rb := results[a.bench], results[b.bench]
+		if ra.errors != rb.errors {
+			return ra.errors < rb.errors
+		}
+		return ra.median < rb.median
+	}
+	// Only reorder the proxies between fallbacks so that direct and
+	// off keep their positions.
+	for i := 0; i < len(entries); {
+		if entries[i].bench < 0 {
+			i++
+			continue
+		}
+		j := i
+		for j < len(entries) && entries[j].bench >= 0 {
+			j++
+		}
+		run := entries[i:j]
+		sort.SliceStable(run, func(i, j int) bool { return less(run[i], run[j]) })
+		i = j
+	}
+	var buf strings.Builder
+	for i, e := range entries {
+		buf.WriteString(e.proxy)
+		if i == len(entries)-1 {
+			break
+		}
+		if e.sep == "" {
+			e.sep = ","
+		}
+		buf.WriteString(e.sep)
+	}
+	suggested := buf.String()
+	fmt.Printf("\nsuggested GOPROXY=%s\n", suggested)
+	if !d.Record {
+		return nil
+	}
+	d.config.Proxy.GOPROXY = suggested
+	path, err := saveConfig(d.Config, d.config)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "recorded proxy ordering in %s\n", path)
+	return nil
+}
+
+// benchProxy sends d.Probes requests to the proxy, returning the median
+// latency of the successful requests and the number of failed requests.
+func (d *doctor) benchProxy(ctx context.Context, proxy string) proxyBench {
+	b := proxyBench{proxy: proxy}
+	var latencies []time.Duration
+	for i := 0; i < d.Probes; i++ {
+		latency, err := d.probeProxy(ctx, proxy)
+		if err != nil {
+			b.errors++
+			b.lastErr = err
+			continue
+		}
+		latencies = append(latencies, latency)
+	}
+	if len(latencies) != 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		b.median = latencies[len(latencies)/2]
+	}
+	return b
+}
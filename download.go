@@ -0,0 +1,158 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"debug/buildinfo"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	modzip "golang.org/x/mod/zip"
+
+	"github.com/kortschak/ugbt/internal/tool"
+)
+
+// download implements the download command.
+type download struct {
+	*ugbt
+
+	Output string `flag:"o" help:"directory to extract the module source into."`
+}
+
+func (*download) Name() string  { return "download" }
+func (*download) Usage() string { return "</path/to/go/executable|module> <version>" }
+func (*download) ShortHelp() string {
+	return text("download and extract the source of a module version without installing it")
+}
+func (*download) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The download command fetches the source zip of the module of a Go executable
+or of a module path at the requested version from the module proxy, and
+extracts it so that the exact source of the version can be inspected
+before deciding to build it. The "latest" version refers to the latest
+release.
+
+The hash of the zip is verified against the checksum database before it is
+extracted unless the module is excluded from checksum database verification
+by the go env GOSUMDB, GONOSUMDB or GOPRIVATE settings, in which case a
+warning is printed.
+
+The source is extracted into the -o directory, which must be empty or not
+exist, or into a directory named module@version in the current directory.
+The path of the directory is printed.
+
+`))
+	f.PrintDefaults()
+}
+
+// Run runs the ugbt download command.
+func (d *download) Run(ctx context.Context, args ...string) error {
+	if len(args) != 2 {
+		return tool.CommandLineErrorf("download requires an executable or module and a version")
+	}
+	mod, err := d.targetModule(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if mod == "std" {
+		return errors.New("the standard library is not available as a module")
+	}
+	version := args[1]
+	if version == "latest" {
+		versions, err := d.availableVersions(ctx, mod, "", true, versionFilter{
+			keep:        func(version string) bool { return semver.Prerelease(version) == "" },
+			unretracted: true,
+			limit:       1,
+		})
+		if err != nil {
+			return err
+		}
+		if len(versions) == 0 {
+			return withKind(kindNotFound, fmt.Errorf("no release of %s found", mod))
+		}
+		version = versions[0].Version
+	}
+	if !semver.IsValid(version) {
+		return fmt.Errorf("invalid version: %q", version)
+	}
+
+	buf, ok, err := d.proxyFile(ctx, mod, version, ".zip")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return withKind(kindNotFound, fmt.Errorf("no proxy holds %s@%s", mod, version))
+	}
+	tmp, err := os.CreateTemp("", "ugbt-download-*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(buf)
+	if err == nil {
+		err = tmp.Close()
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	z, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		return fmt.Errorf("invalid module zip: %w", err)
+	}
+	hash, err := zipHash(z)
+	if err != nil {
+		return fmt.Errorf("invalid module zip: %w", err)
+	}
+	verified, err := d.verifyZipHash(ctx, mod, version, hash)
+	if err != nil {
+		return err
+	}
+	if !verified {
+		fprintf(os.Stderr, "warning: %s@%s is not checked against the checksum database\n", mod, version)
+	}
+
+	dir := d.Output
+	if dir == "" {
+		dir = path.Base(mod) + "@" + version
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(d.wd, dir)
+	}
+	err = modzip.Unzip(dir, module.Version{Path: mod, Version: version}, tmp.Name())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "extracted %s@%s %s\n", mod, version, hash)
+	fmt.Println(dir)
+	return nil
+}
+
+// targetModule returns the module of the Go executable at exe, or exe if
+// it is not a Go executable but is a valid module path. If exe is empty,
+// the module of the ugbt executable is returned. The module of executables
+// in the standard library is "std".
+func (u *ugbt) targetModule(ctx context.Context, exe string) (string, error) {
+	if p, err := exePath(exe); err == nil {
+		if _, err := buildinfo.ReadFile(p); err == nil {
+			_, mod, _, err := u.version(ctx, exe)
+			return mod, err
+		}
+	}
+	err := module.CheckPath(exe)
+	if err != nil {
+		return "", fmt.Errorf("%s is neither a Go executable nor a module path: %w", exe, err)
+	}
+	return exe, nil
+}
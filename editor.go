@@ -0,0 +1,244 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"debug/buildinfo"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/mod/semver"
+
+	"github.com/kortschak/ugbt/internal/tool"
+)
+
+// editorTools is the set of packages of the standard tool sets of
+// editors, keyed by editor name.
+var editorTools = map[string][]string{
+	// The tools installed by the Go extension for VS Code.
+	"vscode": {
+		"golang.org/x/tools/gopls",
+		"github.com/go-delve/delve/cmd/dlv",
+		"honnef.co/go/tools/cmd/staticcheck",
+		"github.com/cweill/gotests/gotests",
+		"github.com/fatih/gomodifytags",
+		"github.com/josharian/impl",
+		"github.com/haya14busa/goplay/cmd/goplay",
+	},
+	// The tools installed by vim-go's :GoInstallBinaries.
+	"vim": {
+		"golang.org/x/tools/gopls",
+		"github.com/go-delve/delve/cmd/dlv",
+		"honnef.co/go/tools/cmd/staticcheck",
+		"golang.org/x/tools/cmd/goimports",
+		"github.com/fatih/gomodifytags",
+		"github.com/josharian/impl",
+		"github.com/kisielk/errcheck",
+		"github.com/davidrjenni/reftools/cmd/fillstruct",
+		"github.com/rogpeppe/godef",
+		"github.com/mgechev/revive",
+		"github.com/jstemmer/gotags",
+		"github.com/klauspost/asmfmt/cmd/asmfmt",
+		"github.com/fatih/motion",
+		"github.com/koron/iferr",
+	},
+}
+
+// editor implements the editor command.
+type editor struct {
+	*ugbt
+
+	DryRun bool `flag:"dry-run,n" help:"don't install anything, just print what would be installed."`
+	BuildFlags
+}
+
+func (*editor) Name() string      { return "editor" }
+func (*editor) Usage() string     { return "<vscode|vim> [status|update]" }
+func (*editor) ShortHelp() string { return text("manage the Go tool sets used by editors") }
+func (*editor) DetailedHelp(f *flag.FlagSet) {
+	editors := make([]string, 0, len(editorTools))
+	for e := range editorTools {
+		editors = append(editors, e)
+	}
+	sort.Strings(editors)
+	fprintf(f.Output(), `
+The editor command manages the standard set of tools used by an editor's
+Go support. Known editors are %s.
+
+The status action, the default, prints each tool of the set with the
+version installed in the install directory and the Go version it was built
+with, and notes tools that are missing, have a newer version, or were built
+with an older Go release than the go command, which editors such as VS Code
+warn about.
+
+The update action installs missing tools at their latest release, updates
+tools that have a newer version and rebuilds tools that were built with an
+older Go release at their current version, so that the whole set is built
+with the same Go release. Missing tools are checked for retraction,
+deprecation and their Go version requirement as for the install command.
+A failure to install one tool does not prevent the others from being
+installed.
+
+`, strings.Join(editors, " and "))
+	f.PrintDefaults()
+}
+
+// editorTool is the state of a tool in an editor's tool set.
+type editorTool struct {
+	name string
+
+	// installed and goVersion are the module version
+	// and the Go version of the installed executable.
+	installed string
+	goVersion string
+
+	// action is the action needed to bring the tool
+	// up to date, and path, mod and version are the
+	// package path, module path and version to install.
+	// No action is needed if action is empty.
+	action  string
+	path    string
+	mod     string
+	version string
+
+	// note describes the state of the tool.
+	note string
+}
+
+// Run runs the ugbt editor command.
+func (e *editor) Run(ctx context.Context, args ...string) error {
+	action := "status"
+	switch len(args) {
+	case 1:
+	case 2:
+		action = args[1]
+	default:
+		return tool.CommandLineErrorf("editor requires an editor name and an optional action")
+	}
+	pkgs, ok := editorTools[args[0]]
+	if !ok {
+		return tool.CommandLineErrorf("unknown editor %q", args[0])
+	}
+	switch action {
+	case "status", "update":
+	default:
+		return tool.CommandLineErrorf("unknown editor action %q", action)
+	}
+
+	goVersion, err := e.goenv(ctx, "GOVERSION")
+	if err != nil {
+		return err
+	}
+	if !goRelease.MatchString(goVersion) {
+		// Development versions and missing toolchains
+		// are not compared.
+		goVersion = ""
+	}
+	tools := make([]editorTool, len(pkgs))
+	for i, pkg := range pkgs {
+		tools[i] = e.toolState(ctx, pkg, goVersion)
+	}
+
+	if action == "status" {
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		for _, t := range tools {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", t.name, t.installed, t.goVersion, t.note)
+		}
+		return w.Flush()
+	}
+
+	var failed int
+	for _, t := range tools {
+		if t.action == "" {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "%s %s@%s\n", t.action, t.path, t.version)
+		if e.DryRun {
+			continue
+		}
+		err := e.install(ctx, t.path, t.mod, t.version, e.BuildFlags)
+		if err != nil {
+			fprintf(os.Stderr, "failed to %s %s: %v\n", t.action, t.name, err)
+			failed++
+		}
+	}
+	switch failed {
+	case 0:
+		return nil
+	case 1:
+		return errors.New("1 tool failed to install")
+	default:
+		return fmt.Errorf("%d tools failed to install", failed)
+	}
+}
+
+// toolState returns the state of the tool built from the package pkg in
+// the install directory. Tools built with an older Go release than
+// goVersion need to be rebuilt unless goVersion is empty.
+func (e *editor) toolState(ctx context.Context, pkg, goVersion string) editorTool {
+	t := editorTool{name: exeName(pkg), path: pkg}
+	dst, err := e.installPath(ctx, pkg)
+	if err != nil {
+		t.note = err.Error()
+		return t
+	}
+	info, err := buildinfo.ReadFile(dst)
+	if err != nil {
+		t.installed, t.note = "missing", "not installed"
+		// Resolve the module and release so that the
+		// install is checked as for other installs.
+		t.mod, err = e.moduleOf(ctx, pkg)
+		if err != nil {
+			t.note += ": " + err.Error()
+			return t
+		}
+		versions, err := e.availableVersions(ctx, t.mod, "", true, versionFilter{
+			keep:        func(version string) bool { return semver.Prerelease(version) == "" },
+			unretracted: true,
+			limit:       1,
+		})
+		if err != nil {
+			t.note += ": " + err.Error()
+			return t
+		}
+		if len(versions) == 0 {
+			t.note += ": no release of " + t.mod + " found"
+			return t
+		}
+		t.action, t.version = "install", versions[0].Version
+		return t
+	}
+	t.installed, t.goVersion = builtModule(info).Version, info.GoVersion
+
+	u := &update{ugbt: e.ugbt}
+	next, ok, err := u.target(ctx, dst, regexp.MustCompile(`^$`), io.Discard)
+	if err != nil {
+		t.note = err.Error()
+		return t
+	}
+	t.path, t.mod = next.path, next.mod
+	switch {
+	case ok:
+		t.action, t.version = "update", next.version
+		t.note = "update available: " + next.version
+	case goVersion != "" && goRelease.MatchString(info.GoVersion) &&
+		semver.Compare(goSemver(goMinor(info.GoVersion)), goSemver(goMinor(goVersion))) < 0:
+		t.note = "built with " + goMinor(info.GoVersion) + ", older than " + goMinor(goVersion)
+		if semver.IsValid(next.current) {
+			t.action, t.version = "rebuild", next.current
+		}
+	default:
+		t.note = "current"
+	}
+	return t
+}
@@ -0,0 +1,198 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/kortschak/ugbt/internal/tool"
+)
+
+// env implements the env command.
+type env struct {
+	*ugbt
+
+	Shell string `flag:"shell" help:"shell to print the environment setup for: bash, zsh, fish or pwsh."`
+	Go    string `flag:"go" help:"Go release of an SDK unpacked by a golang.org/dl wrapper to use as the go command."`
+}
+
+func (*env) Name() string  { return "env" }
+func (*env) Usage() string { return "" }
+func (*env) ShortHelp() string {
+	return text("print shell commands that set up the environment for ugbt")
+}
+func (*env) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The env command prints shell commands that add the install directory, the
+bin directories of the GOPATH elements and the "dirs" of the "scan" section
+of the ugbt config to the PATH, for use in shell start up files. For
+example, in a bash or zsh rc file
+
+	eval "$(ugbt env)"
+
+or in a fish config file
+
+	ugbt env -shell fish | source
+
+or in a PowerShell profile
+
+	ugbt env -shell pwsh | Out-String | Invoke-Expression
+
+Directories that are already in the PATH are not added again, so the
+output can be evaluated more than once in a session.
+
+If the -go flag is given, the bin directory of the SDK for that Go release
+installed by its golang.org/dl wrapper is placed at the start of the PATH
+if it is not already in the PATH, and GOROOT is set to the SDK, so that
+the go command is the selected release.
+
+If -shell is not given, the shell is determined from the SHELL environment
+variable, or is pwsh on Windows.
+
+`))
+	f.PrintDefaults()
+}
+
+// Run runs the ugbt env command.
+func (e *env) Run(ctx context.Context, args ...string) error {
+	if len(args) != 0 {
+		return tool.CommandLineErrorf("env does not accept arguments")
+	}
+	shell := e.Shell
+	if shell == "" {
+		shell = e.defaultShell()
+	}
+	switch shell {
+	case "sh", "bash", "zsh", "fish", "pwsh":
+	default:
+		return tool.CommandLineErrorf("unknown shell %q: must be bash, zsh, fish or pwsh", shell)
+	}
+
+	var goroot string
+	if e.Go != "" {
+		if !isGoRelease(e.Go) {
+			return fmt.Errorf("invalid Go release: %q", e.Go)
+		}
+		root, err := sdkRoot()
+		if err != nil {
+			return err
+		}
+		goroot = filepath.Join(root, e.Go)
+		// SDKs built from source, such as gotip's, are not
+		// marked as unpacked, so look for the go command.
+		gocmd := filepath.Join(goroot, "bin", "go")
+		if runtime.GOOS == "windows" {
+			gocmd += ".exe"
+		}
+		_, err = os.Stat(gocmd)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("no %s SDK in %s: install golang.org/dl/%[1]s and run %[1]s download", e.Go, root)
+			}
+			return err
+		}
+	}
+
+	dirs, err := e.scanDirs(ctx)
+	if err != nil {
+		return err
+	}
+	path := filepath.SplitList(e.getenv("PATH"))
+	var add []string
+	if goroot != "" {
+		bin := filepath.Join(goroot, "bin")
+		if !inPath(path, bin) {
+			add = append(add, bin)
+		}
+	}
+	for _, d := range dirs {
+		d, err := filepath.Abs(d)
+		if err != nil {
+			return err
+		}
+		if !inPath(path, d) {
+			add = append(add, d)
+		}
+	}
+	writeEnv(os.Stdout, shell, add, goroot)
+	return nil
+}
+
+// inPath returns whether the directory dir is an element of path.
+func inPath(path []string, dir string) bool {
+	for _, p := range path {
+		if p == dir || sameFile(p, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultShell returns the shell named by the SHELL environment variable,
+// or pwsh on Windows if it is not set.
+func (e *env) defaultShell() string {
+	if sh := e.getenv("SHELL"); sh != "" {
+		return strings.TrimSuffix(filepath.Base(sh), ".exe")
+	}
+	if runtime.GOOS == "windows" {
+		return "pwsh"
+	}
+	return "sh"
+}
+
+// writeEnv writes the commands for shell that prepend the dirs to the
+// PATH, and that set GOROOT if it is not empty.
+func writeEnv(w io.Writer, shell string, dirs []string, goroot string) {
+	switch shell {
+	case "fish":
+		if goroot != "" {
+			fmt.Fprintf(w, "set -gx GOROOT %s\n", posixQuote(goroot))
+		}
+		if len(dirs) != 0 {
+			quoted := make([]string, len(dirs))
+			for i, d := range dirs {
+				quoted[i] = posixQuote(d)
+			}
+			fmt.Fprintf(w, "set -gx PATH %s $PATH\n", strings.Join(quoted, " "))
+		}
+	case "pwsh":
+		if goroot != "" {
+			fmt.Fprintf(w, "$env:GOROOT = %s\n", pwshQuote(goroot))
+		}
+		if len(dirs) != 0 {
+			sep := string(os.PathListSeparator)
+			fmt.Fprintf(w, "$env:PATH = %s + $env:PATH\n", pwshQuote(strings.Join(dirs, sep)+sep))
+		}
+	default:
+		if goroot != "" {
+			fmt.Fprintf(w, "export GOROOT=%s\n", posixQuote(goroot))
+		}
+		if len(dirs) != 0 {
+			quoted := make([]string, len(dirs))
+			for i, d := range dirs {
+				quoted[i] = posixQuote(d)
+			}
+			fmt.Fprintf(w, "export PATH=%s:\"$PATH\"\n", strings.Join(quoted, ":"))
+		}
+	}
+}
+
+// posixQuote returns s single quoted for POSIX shells and fish.
+func posixQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// pwshQuote returns s single quoted for PowerShell.
+func pwshQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,258 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+
+	"github.com/kortschak/ugbt/internal/tool"
+)
+
+// errorKind is a class of command failure. Each kind is reported with a
+// distinct exit status so that programs running ugbt can tell the causes
+// of failures apart.
+type errorKind string
+
+const (
+	// kindOther is any failure not described by another kind.
+	kindOther errorKind = "other"
+	// kindUsage is an invalid command line.
+	kindUsage errorKind = "usage"
+	// kindVulnerable is a failed vulnerability audit.
+	kindVulnerable errorKind = "vulnerable"
+	// kindNetwork is a failure to reach a remote service.
+	kindNetwork errorKind = "network"
+	// kindNotFound is a missing executable, module, version
+	// or Go release.
+	kindNotFound errorKind = "not-found"
+	// kindNotGo is an executable without readable Go build
+	// information identifying its module.
+	kindNotGo errorKind = "not-a-go-binary"
+	// kindToolchain is a module that requires a newer Go
+	// toolchain than is available.
+	kindToolchain errorKind = "toolchain-too-old"
+	// kindRetracted is a request for a retracted version.
+	kindRetracted errorKind = "retracted"
+	// kindPermission is a denied file system or remote
+	// service access.
+	kindPermission errorKind = "permission"
+	// kindDeprecated is a request to install an executable
+	// from a deprecated module.
+	kindDeprecated errorKind = "deprecated"
+)
+
+// exitCodes are the exit statuses for each kind of failure. The usage and
+// other kinds keep the exit status of 2 used by tool.Main.
+var exitCodes = map[errorKind]int{
+	kindOther:      2,
+	kindUsage:      2,
+	kindVulnerable: 3,
+	kindNetwork:    4,
+	kindNotFound:   5,
+	kindNotGo:      6,
+	kindToolchain:  7,
+	kindRetracted:  8,
+	kindPermission: 9,
+	kindDeprecated: 10,
+}
+
+// kindError is an error with a known kind.
+type kindError struct {
+	kind errorKind
+	err  error
+}
+
+// withKind returns err marked as being of the given kind. If err is nil,
+// withKind returns nil.
+func withKind(kind errorKind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return kindError{kind: kind, err: err}
+}
+
+func (e kindError) Error() string { return e.err.Error() }
+func (e kindError) Unwrap() error { return e.err }
+
+// ExitCode returns the exit code for the kind of the error.
+func (e kindError) ExitCode() int { return exitCodes[e.kind] }
+
+// joinErrors returns an error reporting each of the non-nil errs on its own
+// line, or nil if there are none. The joined error has the kind shared by
+// all of errs, or kindOther if their kinds differ.
+func joinErrors(errs []error) error {
+	var (
+		msgs []string
+		kind errorKind
+		last error
+	)
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		k := kindOf(err)
+		if kind == "" {
+			kind = k
+		} else if k != kind {
+			kind = kindOther
+		}
+		msgs = append(msgs, err.Error())
+		last = err
+	}
+	switch len(msgs) {
+	case 0:
+		return nil
+	case 1:
+		return last
+	}
+	return withKind(kind, errors.New(strings.Join(msgs, "\n")))
+}
+
+// kindOf returns the kind of err. Errors that have not been marked with
+// withKind are classified by the errors they wrap.
+func kindOf(err error) errorKind {
+	var (
+		kerr    kindError
+		vulnErr vulnError
+		status  statusError
+		urlErr  *url.Error
+		opErr   *net.OpError
+	)
+	switch {
+	case errors.As(err, &kerr):
+		return kerr.kind
+	case tool.IsCommandLineError(err):
+		return kindUsage
+	case errors.As(err, &vulnErr):
+		return kindVulnerable
+	case errors.As(err, &status):
+		switch status.code {
+		case http.StatusNotFound, http.StatusGone:
+			return kindNotFound
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return kindPermission
+		}
+		return kindNetwork
+	case errors.As(err, &urlErr), errors.As(err, &opErr):
+		return kindNetwork
+	case errors.Is(err, fs.ErrPermission):
+		return kindPermission
+	case errors.Is(err, exec.ErrNotFound):
+		// A missing go command is not a missing
+		// executable or module.
+		return kindOther
+	case errors.Is(err, fs.ErrNotExist):
+		return kindNotFound
+	default:
+		return kindOther
+	}
+}
+
+// goOutputKind returns the kind of a go command failure from the go
+// command's output.
+func goOutputKind(stderr string) errorKind {
+	for _, m := range []struct {
+		kind errorKind
+		msgs []string
+	}{
+		{kind: kindToolchain, msgs: []string{"requires go >=", "requires go version"}},
+		{kind: kindPermission, msgs: []string{"permission denied"}},
+		{kind: kindNotFound, msgs: []string{
+			"no matching versions for query",
+			"unknown revision",
+			"cannot find module providing package",
+			"does not contain package",
+			"404 Not Found",
+			"410 Gone",
+			"no such file or directory",
+		}},
+		{kind: kindNetwork, msgs: []string{
+			"dial tcp",
+			"no such host",
+			"i/o timeout",
+			"connection refused",
+			"connection reset",
+			"network is unreachable",
+		}},
+	} {
+		for _, msg := range m.msgs {
+			if strings.Contains(stderr, msg) {
+				return m.kind
+			}
+		}
+	}
+	return kindOther
+}
+
+// exitError is the error returned by ugbt when a command fails. It holds
+// the classification of the command's error, and if the ugbt -json-errors
+// flag is given it is reported by tool.Main as a JSON object.
+type exitError struct {
+	command string
+	kind    errorKind
+	err     error
+	json    bool
+}
+
+// classify returns err marked with its kind for reporting by tool.Main.
+// Command line errors are returned unaltered so that tool.Main prints the
+// command usage, unless errors are reported as JSON.
+func classify(command string, err error, asJSON bool) error {
+	if err == nil || (!asJSON && tool.IsCommandLineError(err)) {
+		return err
+	}
+	return exitError{command: command, kind: kindOf(err), err: err, json: asJSON}
+}
+
+func (e exitError) Error() string { return e.err.Error() }
+func (e exitError) Unwrap() error { return e.err }
+
+// ExitCode returns the exit code for the kind of the error, or the error's
+// own exit code if it has one.
+func (e exitError) ExitCode() int {
+	var coded interface{ ExitCode() int }
+	if errors.As(e.err, &coded) {
+		return coded.ExitCode()
+	}
+	return exitCodes[e.kind]
+}
+
+// errorReport is the JSON form of a command failure.
+type errorReport struct {
+	Command  string    `json:"command,omitempty"`
+	Kind     errorKind `json:"kind"`
+	Error    string    `json:"error"`
+	ExitCode int       `json:"exit_code"`
+}
+
+// Report implements the tool.Main error reporting hook, writing the error
+// to w as a single line JSON object if the ugbt -json-errors flag was
+// given, and otherwise in the default form.
+func (e exitError) Report(w io.Writer, name string) {
+	if !e.json {
+		fmt.Fprintf(w, "%s: %v\n", name, e.err)
+		return
+	}
+	b, err := json.Marshal(errorReport{
+		Command:  e.command,
+		Kind:     e.kind,
+		Error:    e.err.Error(),
+		ExitCode: e.ExitCode(),
+	})
+	if err != nil {
+		fmt.Fprintf(w, "%s: %v\n", name, e.err)
+		return
+	}
+	fmt.Fprintf(w, "%s\n", b)
+}
@@ -0,0 +1,129 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Selection holds the flags that select executables for bulk commands.
+type Selection struct {
+	Only   string `flag:"only" help:"only act on executables with a name, package or module path matching the pattern."`
+	Except string `flag:"except" help:"do not act on executables with a name, package or module path matching the pattern."`
+}
+
+// selectionHelp is the detailed help for the Selection flags.
+const selectionHelp = `The -only and -except flags select executables by matching a pattern
+against the executable's name and its package and module paths. A pattern
+is a glob as accepted by path.Match, where * does not match a /, so that
+golang.org/x/* matches the modules in golang.org/x but not the packages in
+their subdirectories. As in go command package patterns, a pattern holding
+... matches with each ... standing for any string, including one with a /,
+and the rest of the pattern matched literally, so that golang.org/x/...
+matches all the modules and packages in golang.org/x. A pattern prefixed
+with re: is a regular expression.
+`
+
+// pattern is an executable selection pattern.
+type pattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+// compilePattern returns the pattern for the -only or -except flag value.
+func compilePattern(flag, s string) (*pattern, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if expr := strings.TrimPrefix(s, "re:"); expr != s {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -%s pattern: %w", flag, err)
+		}
+		return &pattern{re: re}, nil
+	}
+	if strings.Contains(s, "...") {
+		return &pattern{re: dotsPattern(s)}, nil
+	}
+	_, err := path.Match(s, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid -%s pattern %q: %w", flag, s, err)
+	}
+	return &pattern{glob: s}, nil
+}
+
+// dotsPattern returns a regular expression matching the go command style
+// pattern s, where ... matches any string. As for the go command, a trailing
+// /... also matches the path before it, so that net/... matches net.
+func dotsPattern(s string) *regexp.Regexp {
+	expr := regexp.QuoteMeta(s)
+	expr = strings.ReplaceAll(expr, `\.\.\.`, `.*`)
+	if strings.HasSuffix(expr, `/.*`) {
+		expr = strings.TrimSuffix(expr, `/.*`) + `(/.*)?`
+	}
+	return regexp.MustCompile(`^` + expr + `$`)
+}
+
+// match returns whether any of the candidates matches the pattern.
+func (p *pattern) match(candidates ...string) bool {
+	for _, c := range candidates {
+		if c == "" {
+			continue
+		}
+		if p.re != nil {
+			if p.re.MatchString(c) {
+				return true
+			}
+			continue
+		}
+		if ok, _ := path.Match(p.glob, c); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// selectExecutables returns the executables in exes that are selected by
+// the -only and -except flags held by sel. An empty exes element refers
+// to ugbt.
+func (u *ugbt) selectExecutables(ctx context.Context, exes []string, sel Selection) ([]string, error) {
+	only, err := compilePattern("only", sel.Only)
+	if err != nil {
+		return nil, err
+	}
+	except, err := compilePattern("except", sel.Except)
+	if err != nil {
+		return nil, err
+	}
+	if only == nil && except == nil {
+		return exes, nil
+	}
+	var selected []string
+	for _, exe := range exes {
+		name := "ugbt"
+		if exe != "" {
+			name = exeBase(exe)
+		}
+		pkg, mod, _, err := u.version(ctx, exe)
+		if err != nil {
+			fprintf(os.Stderr, "skipping %s: %v\n", name, err)
+			continue
+		}
+		candidates := []string{name, pkg, mod}
+		if only != nil && !only.match(candidates...) {
+			continue
+		}
+		if except != nil && except.match(candidates...) {
+			continue
+		}
+		selected = append(selected, exe)
+	}
+	return selected, nil
+}
@@ -0,0 +1,145 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/execabs"
+)
+
+const (
+	// maxRateLimitRetries is the maximum number of times a rate
+	// limited request is retried.
+	maxRateLimitRetries = 3
+
+	// maxRateLimitWait is the longest delay requested by a rate
+	// limiting server that will be waited for before retrying.
+	maxRateLimitWait = time.Minute
+)
+
+// rateLimitWait returns how long to wait before retrying a request that
+// received resp on the given attempt, and whether the response indicates
+// that the request was rejected by rate limiting. The delay is taken from
+// the Retry-After or rate limit reset headers if they are present, and
+// otherwise backs off exponentially with the number of attempts.
+func rateLimitWait(resp *http.Response, attempt int, now time.Time) (wait time.Duration, limited bool) {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+	case http.StatusForbidden:
+		// GitHub signals exhausted primary rate limits with
+		// a 403 status and no remaining requests.
+		if resp.Header.Get("X-RateLimit-Remaining") != "0" {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	if s := resp.Header.Get("Retry-After"); s != "" {
+		if secs, err := strconv.Atoi(s); err == nil {
+			return time.Duration(secs) * time.Second, true
+		}
+		if t, err := http.ParseTime(s); err == nil {
+			return t.Sub(now), true
+		}
+	}
+	for _, h := range []string{"X-RateLimit-Reset", "RateLimit-Reset"} {
+		s := resp.Header.Get(h)
+		if s == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			continue
+		}
+		// GitHub and GitLab give the reset time in seconds since
+		// the epoch, while the IETF draft uses seconds from now.
+		if v > 1e9 {
+			return time.Unix(v, 0).Sub(now), true
+		}
+		return time.Duration(v) * time.Second, true
+	}
+	return time.Second << attempt, true
+}
+
+// rateLimitError is returned when a request is still rate limited after
+// retrying, or the server requests a delay that is too long to wait for.
+type rateLimitError struct {
+	status string
+	wait   time.Duration
+}
+
+func (e rateLimitError) Error() string {
+	return fmt.Sprintf("%s: rate limited for %v: configure an API token to raise the limit", e.status, e.wait.Round(time.Second))
+}
+
+// sleep waits for the duration d or until the context is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// authorize adds credentials to requests to code hosting service APIs.
+// Credentials are only sent over HTTPS.
+func (u *ugbt) authorize(req *http.Request) {
+	if req.URL.Scheme != "https" {
+		return
+	}
+	switch {
+	case req.URL.Host == "api.github.com":
+		if tok := u.githubToken(req.Context()); tok != "" {
+			req.Header.Set("Authorization", "Bearer "+tok)
+		}
+	case req.URL.Host == "gitlab.com" && strings.HasPrefix(req.URL.Path, "/api/"):
+		tok := u.getenv("GITLAB_TOKEN")
+		if tok == "" {
+			tok = u.config.Forge.GitLabToken
+		}
+		if tok != "" {
+			req.Header.Set("PRIVATE-TOKEN", tok)
+		}
+	}
+}
+
+var (
+	ghTokenOnce sync.Once
+	ghToken     string
+)
+
+// githubToken returns the token to use for GitHub API requests.
+func (u *ugbt) githubToken(ctx context.Context) string {
+	for _, env := range []string{"GH_TOKEN", "GITHUB_TOKEN"} {
+		if tok := u.getenv(env); tok != "" {
+			return tok
+		}
+	}
+	if u.config.Forge.GitHubToken != "" {
+		return u.config.Forge.GitHubToken
+	}
+	ghTokenOnce.Do(func() {
+		// Use the credential store of the gh command if it is
+		// installed and logged in.
+		var stdout bytes.Buffer
+		cmd := execabs.CommandContext(ctx, "gh", "auth", "token")
+		cmd.Stdout = &stdout
+		if cmd.Run() == nil {
+			ghToken = strings.TrimSpace(stdout.String())
+		}
+	})
+	return ghToken
+}
@@ -1,12 +1,15 @@
 module github.com/kortschak/ugbt
 
-go 1.17
+go 1.18
 
 require (
-	golang.org/x/mod v0.5.1
-	golang.org/x/sys v0.0.0-20211124211545-fe61309f8881
+	// x/mod v0.12.0 or later is needed to parse the go
+	// directives of modules that use go1.21 versions.
+	golang.org/x/mod v0.17.0
+	// x/sys v0.20.0 has an execabs that reports a missing
+	// command with go1.19 and later versions of os/exec.
+	golang.org/x/sys v0.20.0
+	golang.org/x/text v0.16.0
 )
 
-require golang.org/x/xerrors v0.0.0-20191011141410-1b5146add898 // indirect
-
 retract v1.0.0 // Unsafe use of os/exec.
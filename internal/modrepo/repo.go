@@ -6,8 +6,9 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Package modrepo provide a function to obtain the repo URL for a module
-// path. It is a cut down version of golang.org/x/pkgsite/internal/source.
+// Package modrepo provide functions to obtain the repo, clone and source
+// file URLs for a module path. It is a cut down version of
+// golang.org/x/pkgsite/internal/source.
 package modrepo
 
 import (
@@ -17,17 +18,27 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"path"
 	"regexp"
 	"strings"
 )
 
+// Doer is an HTTP client used to fetch go-import and go-source meta tags.
+// It is satisfied by *http.Client, and allows fetches to be made through
+// the caller's HTTP layer.
+type Doer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
 const (
 	goSourceRepoURL = "https://cs.opensource.google/go/go"
 	goIssuesURL     = "https://github.com/golang/go/issues"
+	goCloneURL      = "https://go.googlesource.com/go"
 )
 
-// URL returns the repository corresponding to the module path.
-func URL(ctx context.Context, mod string) (repo, bugs string, _ error) {
+// URL returns the repository corresponding to the module path. The client
+// is used to fetch go-import meta tags for vanity import paths.
+func URL(ctx context.Context, client Doer, mod string) (repo, bugs string, _ error) {
 	// The example.com domain can never be real; it is reserved for testing
 	// (https://en.wikipedia.org/wiki/Example.com). Treat it as if it used
 	// GitHub templates.
@@ -44,7 +55,7 @@ func URL(ctx context.Context, mod string) (repo, bugs string, _ error) {
 
 	repo, bugsFor, err := matchStatic(mod)
 	if err != nil {
-		meta, err := fetchMeta(ctx, mod)
+		meta, err := fetchMeta(ctx, client, mod)
 		if err != nil {
 			return "", "", err
 		}
@@ -60,6 +71,208 @@ func URL(ctx context.Context, mod string) (repo, bugs string, _ error) {
 	return repo, bugsFor(repo), nil
 }
 
+// Remote holds the URLs used to clone the repository holding a module.
+type Remote struct {
+	// Forge is the kind of code hosting
+	// service holding the repository, for
+	// example github or gitlab. It is empty
+	// if it is not known.
+	Forge string
+
+	// Root is the import path prefix
+	// corresponding to the repository root.
+	Root string
+
+	// HTTPS is the https clone URL of the
+	// repository.
+	HTTPS string
+
+	// SSH is the ssh clone URL of the
+	// repository in scp-like form. It is
+	// empty if it is not known.
+	SSH string
+}
+
+// CloneURLs returns the clone URLs for the repository holding the module
+// path. The client is used to fetch go-import meta tags for vanity import
+// paths. Unlike the repository URL returned by URL, the URLs returned by
+// CloneURLs are always the repository named by the go-import meta tag,
+// not a browsing site given by a go-source meta tag.
+func CloneURLs(ctx context.Context, client Doer, mod string) (Remote, error) {
+	if strings.HasPrefix(mod, "example.com/") {
+		return Remote{Root: mod, HTTPS: "https://" + mod}, nil
+	}
+
+	const standard = "std"
+	if mod == standard {
+		return Remote{HTTPS: goCloneURL, Forge: "googlesource"}, nil
+	}
+
+	repo, _, err := matchStatic(mod)
+	if err == nil {
+		return Remote{Root: repo, HTTPS: "https://" + repo, SSH: sshURL(repo), Forge: forgeKind(repo)}, nil
+	}
+	meta, err := fetchMeta(ctx, client, mod)
+	if err != nil {
+		return Remote{}, err
+	}
+	if meta.importURL == "" {
+		return Remote{}, fmt.Errorf("%s: no go-import repository: %w", mod, errors.New("not found"))
+	}
+	r := Remote{Root: meta.repoRootPrefix, HTTPS: meta.importURL}
+	if repo, _, err := matchStatic(removeHTTPScheme(meta.importURL)); err == nil {
+		r.SSH, r.Forge = sshURL(repo), forgeKind(repo)
+	}
+	return r, nil
+}
+
+// Source holds the information needed to link to the source files of the
+// repository holding a module.
+type Source struct {
+	// Root is the import path prefix
+	// corresponding to the repository root.
+	Root string
+
+	// Repo is the URL of the repository.
+	Repo string
+
+	// file and line are the URL templates
+	// for a file and a line fragment, and
+	// gddo is whether file is a go-source
+	// meta tag template.
+	file, line string
+	gddo       bool
+}
+
+// sourceTemplates holds the file and line fragment URL templates for each
+// forge kind. The templates are expanded by Source.FileURL.
+var sourceTemplates = map[string]struct{ file, line string }{
+	"github":       {file: "{repo}/blob/{commit}/{file}", line: "#L{line}"},
+	"gitlab":       {file: "{repo}/-/blob/{commit}/{file}", line: "#L{line}"},
+	"bitbucket":    {file: "{repo}/src/{commit}/{file}", line: "#lines-{line}"},
+	"gitee":        {file: "{repo}/blob/{commit}/{file}", line: "#L{line}"},
+	"sourcehut":    {file: "{repo}/tree/{commit}/item/{file}", line: "#L{line}"},
+	"gitea":        {file: "{repo}/src/{commit}/{file}", line: "#L{line}"},
+	"gogs":         {file: "{repo}/src/{commit}/{file}", line: "#L{line}"},
+	"googlesource": {file: "{repo}/+/{commit}/{file}", line: "#{line}"},
+	"cs":           {file: "{repo}/+/{commit}:{file}", line: ";l={line}"},
+}
+
+// SourceLinks returns the source link information for the repository
+// holding the module path. The client is used to fetch go-import and
+// go-source meta tags for vanity import paths. The templates of a go-source
+// meta tag are used in preference to those of a known forge.
+func SourceLinks(ctx context.Context, client Doer, mod string) (Source, error) {
+	if strings.HasPrefix(mod, "example.com/") {
+		// Treat example.com as if it used GitHub templates
+		// as is done by URL.
+		return newSource(mod, trimVCSSuffix("https://"+mod), "github"), nil
+	}
+
+	const standard = "std"
+	if mod == standard {
+		return newSource("", goSourceRepoURL, "cs"), nil
+	}
+
+	repo, _, err := matchStatic(mod)
+	if err == nil {
+		return newSource(repo, trimVCSSuffix("https://"+repo), forgeKind(repo)), nil
+	}
+	meta, err := fetchMeta(ctx, client, mod)
+	if err != nil {
+		return Source{}, err
+	}
+	repoURL := strings.TrimSuffix(meta.repoURL, "/")
+	if meta.fileTemplate != "" && meta.fileTemplate != "_" {
+		return Source{Root: meta.repoRootPrefix, Repo: repoURL, file: meta.fileTemplate, gddo: true}, nil
+	}
+	if strings.HasPrefix(mod, "golang.org/") {
+		if src, _ := adjustGoRepoInfo(repoURL, mod); src != repoURL {
+			return newSource(meta.repoRootPrefix, src, "cs"), nil
+		}
+	}
+	return newSource(meta.repoRootPrefix, repoURL, forgeKind(removeHTTPScheme(repoURL))), nil
+}
+
+// newSource returns a Source for the repository using the templates for
+// the forge kind.
+func newSource(root, repo, forge string) Source {
+	t := sourceTemplates[forge]
+	return Source{Root: root, Repo: repo, file: t.file, line: t.line}
+}
+
+// FileURL returns the URL of the file at the slash-separated path relative
+// to the repository root at the commit or tag ref, with a fragment for the
+// line if it is not zero. Templates from go-source meta tags have no
+// revision, so the ref is not used for them. If the templates for the
+// repository are not known, the empty string is returned.
+func (s Source) FileURL(ref, file string, line int) string {
+	if s.file == "" {
+		return ""
+	}
+	var lineStr string
+	if line > 0 {
+		lineStr = fmt.Sprint(line)
+	}
+	if s.gddo {
+		dir, base := path.Split(file)
+		dir = strings.TrimSuffix(dir, "/")
+		var slashDir, hashLine string
+		if dir != "" {
+			slashDir = "/" + dir
+		}
+		if lineStr != "" {
+			hashLine = "#" + lineStr
+		}
+		return strings.NewReplacer(
+			"{dir}", dir,
+			"{/dir}", slashDir,
+			"{file}", base,
+			"{line}", lineStr,
+			"{#line}", hashLine,
+		).Replace(s.file)
+	}
+	tmpl := s.file
+	if lineStr != "" {
+		tmpl += s.line
+	}
+	return strings.NewReplacer(
+		"{repo}", s.Repo,
+		"{commit}", ref,
+		"{file}", file,
+		"{line}", lineStr,
+	).Replace(tmpl)
+}
+
+// sshURL returns the ssh clone URL for the repo path matched by a static
+// pattern, or the empty string if the repo's host is not known to support
+// ssh clones.
+func sshURL(repo string) string {
+	for _, pat := range patterns {
+		if pat.ssh != nil && pat.re.MatchString(repo) {
+			return pat.ssh(repo)
+		}
+	}
+	return ""
+}
+
+// forgeKind returns the kind of forge hosting the repo path matched by a
+// static pattern, or the empty string if it is not known.
+func forgeKind(repo string) string {
+	for _, pat := range patterns {
+		if pat.re.MatchString(repo) {
+			return pat.forge
+		}
+	}
+	return ""
+}
+
+// scpURL returns the scp-like ssh URL for the repo path using the git user.
+func scpURL(repo string) string {
+	host, path, _ := strings.Cut(repo, "/")
+	return fmt.Sprintf("git@%s:%s.git", host, path)
+}
+
 // csNonXRepos is a set of repos hosted at https://cs.opensource.google/go,
 // that are not an x/repo.
 var csNonXRepos = map[string]bool{
@@ -163,36 +376,52 @@ var patterns = []struct {
 	pattern string // uncompiled regexp
 	re      *regexp.Regexp
 	issues  func(repo string) string
+	ssh     func(repo string) string // nil if ssh clones are not known
+	forge   string                   // empty if the forge kind is not known
 }{
 	{
 		pattern: `^(?P<repo>github\.com/[a-z0-9A-Z_.\-]+/[a-z0-9A-Z_.\-]+)`,
 		issues:  func(repo string) string { return fmt.Sprintf("%s/issues", repo) },
+		ssh:     scpURL,
+		forge:   "github",
 	},
 	{
 		// Assume that any site beginning with "github." works like github.com.
 		pattern: `^(?P<repo>github\.[a-z0-9A-Z.-]+/[a-z0-9A-Z_.\-]+/[a-z0-9A-Z_.\-]+)(\.git|$)`,
 		issues:  func(repo string) string { return fmt.Sprintf("%s/issues", repo) },
+		ssh:     scpURL,
+		forge:   "github",
 	},
 	{
 		pattern: `^(?P<repo>bitbucket\.org/[a-z0-9A-Z_.\-]+/[a-z0-9A-Z_.\-]+)`,
 		issues:  func(repo string) string { return fmt.Sprintf("%s/issues", repo) },
+		ssh:     scpURL,
+		forge:   "bitbucket",
 	},
 	{
 		pattern: `^(?P<repo>gitlab\.com/[a-z0-9A-Z_.\-]+/[a-z0-9A-Z_.\-]+)`,
 		issues:  func(repo string) string { return fmt.Sprintf("%s/-/issues", repo) },
+		ssh:     scpURL,
+		forge:   "gitlab",
 	},
 	{
 		// Assume that any site beginning with "gitlab." works like gitlab.com.
 		pattern: `^(?P<repo>gitlab\.[a-z0-9A-Z.-]+/[a-z0-9A-Z_.\-]+/[a-z0-9A-Z_.\-]+)(\.git|$)`,
 		issues:  func(repo string) string { return fmt.Sprintf("%s/-/issues", repo) },
+		ssh:     scpURL,
+		forge:   "gitlab",
 	},
 	{
 		pattern: `^(?P<repo>gitee\.com/[a-z0-9A-Z_.\-]+/[a-z0-9A-Z_.\-]+)(\.git|$)`,
 		issues:  func(repo string) string { return fmt.Sprintf("%s/issues", repo) },
+		ssh:     scpURL,
+		forge:   "gitee",
 	},
 	{
 		pattern: `^(?P<repo>git\.sr\.ht/~[a-z0-9A-Z_.\-]+/[a-z0-9A-Z_.\-]+)`,
 		issues:  func(repo string) string { return strings.Replace(repo, "git.sr.ht", "todo.sr.ht", 1) },
+		ssh:     func(repo string) string { return "git@git.sr.ht:" + strings.TrimPrefix(repo, "git.sr.ht/") },
+		forge:   "sourcehut",
 	},
 	{
 		pattern: `^(?P<repo>git\.fd\.io/[a-z0-9A-Z_.\-]+)`,
@@ -205,11 +434,14 @@ var patterns = []struct {
 	{
 		pattern: `^(?P<repo>gitea\.com/[a-z0-9A-Z_.\-]+/[a-z0-9A-Z_.\-]+)(\.git|$)`,
 		issues:  func(repo string) string { return fmt.Sprintf("%s/issues", repo) },
+		ssh:     scpURL,
+		forge:   "gitea",
 	},
 	{
 		// Assume that any site beginning with "gitea." works like gitea.com.
 		pattern: `^(?P<repo>gitea\.[a-z0-9A-Z.-]+/[a-z0-9A-Z_.\-]+/[a-z0-9A-Z_.\-]+)(\.git|$)`,
 		issues:  func(repo string) string { return fmt.Sprintf("%s/issues", repo) },
+		forge:   "gitea",
 	},
 	{
 		pattern: `^(?P<repo>go\.isomorphicgo\.org/[a-z0-9A-Z_.\-]+/[a-z0-9A-Z_.\-]+)(\.git|$)`,
@@ -222,6 +454,7 @@ var patterns = []struct {
 	{
 		pattern: `^(?P<repo>gogs\.[a-z0-9A-Z.-]+/[a-z0-9A-Z_.\-]+/[a-z0-9A-Z_.\-]+)(\.git|$)`,
 		issues:  func(repo string) string { return repo },
+		forge:   "gogs",
 	},
 	{
 		pattern: `^(?P<repo>dmitri\.shuralyov\.com\/.+)$`,
@@ -238,6 +471,7 @@ var patterns = []struct {
 	{
 		pattern: `^(?P<repo>[^.]+\.googlesource\.com/[^.]+)(\.git|$)`,
 		issues:  func(repo string) string { return repo },
+		forge:   "googlesource",
 	},
 	{
 		pattern: `^(?P<repo>git\.apache\.org/[^.]+)(\.git|$)`,
@@ -310,6 +544,8 @@ func removeHTTPScheme(url string) string {
 type sourceMeta struct {
 	repoRootPrefix string // import path prefix corresponding to repo root
 	repoURL        string // URL of the repo root
+	importURL      string // URL of the go-import git repo root, if any
+	fileTemplate   string // go-source file template, if any
 }
 
 // fetchMeta retrieves go-import and go-source meta tag information, using the import path to construct
@@ -321,7 +557,17 @@ type sourceMeta struct {
 // The discovery site only cares about linking to source, not fetching it (we
 // already have it in the module zip file). So we merge the go-import and
 // go-source meta tag information, preferring the latter.
-func fetchMeta(ctx context.Context, importPath string) (_ *sourceMeta, err error) {
+func fetchMeta(ctx context.Context, client Doer, importPath string) (_ *sourceMeta, err error) {
+	resp, err := fetchMetaPage(ctx, client, importPath)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return parseMeta(importPath, resp.Body)
+}
+
+// fetchMetaPage retrieves the go-get=1 page for the import path.
+func fetchMetaPage(ctx context.Context, client Doer, importPath string) (*http.Response, error) {
 	uri := importPath
 	if !strings.Contains(uri, "/") {
 		// Add slash for root of domain.
@@ -329,22 +575,88 @@ func fetchMeta(ctx context.Context, importPath string) (_ *sourceMeta, err error
 	}
 	uri = uri + "?go-get=1"
 
-	var client http.Client
-	resp, err := doURL(ctx, &client, "GET", "https://"+uri, true)
+	resp, err := doURL(ctx, client, "GET", "https://"+uri, true)
 	if err != nil {
-		resp, err = doURL(ctx, &client, "GET", "http://"+uri, false)
+		resp, err = doURL(ctx, client, "GET", "http://"+uri, false)
 		if err != nil {
 			return nil, err
 		}
 	}
+	return resp, nil
+}
+
+// Successor returns the import path prefix declared by the go-import meta
+// tag served for the module path when that prefix is not a prefix of the
+// module path. This indicates that the vanity import path now redirects
+// to a different repository root. If the module has not moved, ok is false.
+// The client is used to fetch the go-import meta tags.
+func Successor(ctx context.Context, client Doer, mod string) (successor string, ok bool, _ error) {
+	if mod == "std" || strings.HasPrefix(mod, "example.com/") {
+		return "", false, nil
+	}
+	if _, _, err := matchStatic(mod); err == nil {
+		// Not a vanity import path.
+		return "", false, nil
+	}
+	resp, err := fetchMetaPage(ctx, client, mod)
+	if err != nil {
+		return "", false, err
+	}
 	defer resp.Body.Close()
-	return parseMeta(importPath, resp.Body)
+	prefixes := importPrefixes(resp.Body)
+	for _, p := range prefixes {
+		if HasPathPrefix(mod, p) {
+			return "", false, nil
+		}
+	}
+	if len(prefixes) != 1 {
+		return "", false, nil
+	}
+	return prefixes[0], true, nil
+}
+
+// importPrefixes returns the import path prefixes of all the go-import
+// meta tags in the page read from r.
+func importPrefixes(r io.Reader) []string {
+	var prefixes []string
+	d := xml.NewDecoder(r)
+	d.Strict = false
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return prefixes
+		}
+		switch t := t.(type) {
+		case xml.EndElement:
+			if strings.EqualFold(t.Name.Local, "head") {
+				return prefixes
+			}
+		case xml.StartElement:
+			if strings.EqualFold(t.Name.Local, "body") {
+				return prefixes
+			}
+			if !strings.EqualFold(t.Name.Local, "meta") || attrValue(t.Attr, "name") != "go-import" {
+				continue
+			}
+			fields := strings.Fields(attrValue(t.Attr, "content"))
+			if len(fields) != 3 || fields[1] == "mod" {
+				continue
+			}
+			prefixes = append(prefixes, fields[0])
+		}
+	}
+}
+
+// HasPathPrefix reports whether the slash-separated path s has the
+// path prefix.
+func HasPathPrefix(s, prefix string) bool {
+	return s == prefix || strings.HasPrefix(s, prefix+"/")
 }
 
 // doURL makes an HTTP request using the given url and method. It returns an
 // error if the request returns an error. If only200 is true, it also returns an
 // error if any status code other than 200 is returned.
-func doURL(ctx context.Context, client *http.Client, method, url string, only200 bool) (_ *http.Response, err error) {
+func doURL(ctx context.Context, client Doer, method, url string, only200 bool) (_ *http.Response, err error) {
 	req, err := http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
 		return nil, err
@@ -416,6 +728,9 @@ metaScan:
 					repoRootPrefix: repoRootPrefix,
 					repoURL:        fields[2],
 				}
+				if fields[1] == "git" {
+					sm.importURL = fields[2]
+				}
 				// Keep going in the hope of finding a go-source tag.
 			case "go-source":
 				if len(fields) != 4 {
@@ -436,9 +751,15 @@ metaScan:
 					}
 					repoURL = sm.repoURL
 				}
+				var importURL string
+				if sm != nil {
+					importURL = sm.importURL
+				}
 				sm = &sourceMeta{
 					repoRootPrefix: repoRootPrefix,
 					repoURL:        repoURL,
+					importURL:      importURL,
+					fileTemplate:   fields[3],
 				}
 				break metaScan
 			}
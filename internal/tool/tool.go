@@ -7,14 +7,17 @@ package tool
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"reflect"
 	"runtime"
 	"runtime/pprof"
 	"runtime/trace"
+	"strings"
 	"time"
 )
 
@@ -29,7 +32,11 @@ import (
 //     }
 // It recursively scans the application object for fields with a tag containing
 //     `flag:"flagname" help:"short help text"``
-// uses all those fields to build command line flags.
+// uses all those fields to build command line flags. Alternate names for a
+// flag, such as a short form, may follow the flag name separated by commas
+//     `flag:"flagname,f" help:"short help text"``
+// Fields may also be tagged as required or as belonging to a group of
+// mutually exclusive flags; see constraints.
 // It expects the Application type to have a method
 //     Run(context.Context, args...string) error
 // which it invokes only after all command line flag processing has been finished.
@@ -77,10 +84,22 @@ func CommandLineErrorf(message string, args ...interface{}) error {
 	return commandLineError(fmt.Sprintf(message, args...))
 }
 
+// IsCommandLineError returns whether err was returned by CommandLineErrorf.
+func IsCommandLineError(err error) bool {
+	_, ok := err.(commandLineError)
+	return ok
+}
+
+// Localize returns the localized form of the help text s of a flag. It
+// may be replaced by applications that localize their help text.
+var Localize = func(s string) string { return s }
+
 // Main should be invoked directly by main function.
 // It will only return if there was no error.  If an error
 // was encountered it is printed to standard error and the
-// application exits with an exit code of 2.
+// application exits with an exit code of 2, or the code
+// returned by the error's ExitCode method if it has one.
+// Errors with a Report method are printed by that method.
 func Main(ctx context.Context, app Application, args []string) {
 	s := flag.NewFlagSet(app.Name(), flag.ExitOnError)
 	s.Usage = func() {
@@ -88,16 +107,63 @@ func Main(ctx context.Context, app Application, args []string) {
 		fmt.Fprintf(s.Output(), "\n\nUsage: %v [flags] %v\n", app.Name(), app.Usage())
 		app.DetailedHelp(s)
 	}
-	addFlags(s, reflect.StructField{}, reflect.ValueOf(app))
-	if err := Run(ctx, app, args); err != nil {
-		fmt.Fprintf(s.Output(), "%s: %v\n", app.Name(), err)
+	addFlags(s, &constraints{}, reflect.StructField{}, reflect.ValueOf(app))
+	args, err := ExpandArgs(args)
+	if err == nil {
+		err = Run(ctx, app, args)
+	}
+	if err != nil {
+		var reporter interface {
+			Report(w io.Writer, name string)
+		}
+		if errors.As(err, &reporter) {
+			reporter.Report(s.Output(), app.Name())
+		} else {
+			fmt.Fprintf(s.Output(), "%s: %v\n", app.Name(), err)
+		}
 		if _, printHelp := err.(commandLineError); printHelp {
 			s.Usage()
 		}
-		os.Exit(2)
+		code := 2
+		var coded interface{ ExitCode() int }
+		if errors.As(err, &coded) {
+			code = coded.ExitCode()
+		}
+		os.Exit(code)
 	}
 }
 
+// ExpandArgs returns args with each argument of the form @file replaced by
+// the lines of the named file, so that long flag values and argument lists
+// can be provided without exceeding command line length limits. Blank lines
+// and lines starting with # are ignored, and leading and trailing white
+// space is removed from each line. Expansion is not recursive. An argument
+// starting with @@ is passed on with the first @ removed.
+func ExpandArgs(args []string) ([]string, error) {
+	var expanded []string
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "@@"):
+			expanded = append(expanded, arg[1:])
+		case strings.HasPrefix(arg, "@") && len(arg) > 1:
+			b, err := os.ReadFile(arg[1:])
+			if err != nil {
+				return nil, err
+			}
+			for _, line := range strings.Split(string(b), "\n") {
+				line = strings.TrimSpace(line)
+				if line == "" || strings.HasPrefix(line, "#") {
+					continue
+				}
+				expanded = append(expanded, line)
+			}
+		default:
+			expanded = append(expanded, arg)
+		}
+	}
+	return expanded, nil
+}
+
 // Run is the inner loop for Main; invoked by Main, recursively by
 // Run, and by various tests.  It runs the application and returns an
 // error.
@@ -108,8 +174,12 @@ func Run(ctx context.Context, app Application, args []string) error {
 		fmt.Fprintf(s.Output(), "\n\nUsage: %v [flags] %v\n", app.Name(), app.Usage())
 		app.DetailedHelp(s)
 	}
-	p := addFlags(s, reflect.StructField{}, reflect.ValueOf(app))
+	c := &constraints{}
+	p := addFlags(s, c, reflect.StructField{}, reflect.ValueOf(app))
 	s.Parse(args)
+	if err := c.check(app.Name(), s); err != nil {
+		return err
+	}
 
 	if p != nil && p.CPU != "" {
 		f, err := os.Create(p.CPU)
@@ -153,16 +223,24 @@ func Run(ctx context.Context, app Application, args []string) error {
 	return app.Run(ctx, s.Args()...)
 }
 
+// FlagSet returns a flag set holding the flags of the application. It can be
+// used to inspect the flags of an application without running it.
+func FlagSet(app Application) *flag.FlagSet {
+	s := flag.NewFlagSet(app.Name(), flag.ContinueOnError)
+	addFlags(s, &constraints{}, reflect.StructField{}, reflect.ValueOf(app))
+	return s
+}
+
 // addFlags scans fields of structs recursively to find things with flag tags
 // and add them to the flag set.
-func addFlags(f *flag.FlagSet, field reflect.StructField, value reflect.Value) *Profile {
+func addFlags(f *flag.FlagSet, c *constraints, field reflect.StructField, value reflect.Value) *Profile {
 	// is it a field we are allowed to reflect on?
 	if field.PkgPath != "" {
 		return nil
 	}
 	// now see if is actually a flag
-	flagName, isFlag := field.Tag.Lookup("flag")
-	help := field.Tag.Get("help")
+	names, isFlag := field.Tag.Lookup("flag")
+	help := Localize(field.Tag.Get("help"))
 	if !isFlag {
 		// not a flag, but it might be a struct with flags in it
 		if value.Elem().Kind() != reflect.Struct {
@@ -179,12 +257,90 @@ func addFlags(f *flag.FlagSet, field reflect.StructField, value reflect.Value) *
 				v = v.Addr()
 			}
 			// check if that field is a flag or contains flags
-			if fp := addFlags(f, child, v); fp != nil {
+			if fp := addFlags(f, c, child, v); fp != nil {
 				p = fp
 			}
 		}
 		return p
 	}
+	// the first name is the flag name and any others are aliases
+	all := strings.Split(names, ",")
+	for i, flagName := range all {
+		if i != 0 {
+			help = "shorthand for -" + all[0]
+		}
+		addFlag(f, flagName, help, value)
+	}
+	c.add(all, field.Tag)
+	return nil
+}
+
+// constraints holds the required flags and the mutually exclusive flag
+// groups of an application. A flag is required if its field is tagged with
+// `required:"true"`, and flags whose fields are tagged with the same group
+// name, `exclusive:"group"`, may not be used together.
+type constraints struct {
+	// names maps flag names and aliases to the
+	// flag name.
+	names map[string]string
+
+	required  []string
+	exclusive map[string][]string
+	groups    []string
+}
+
+// add adds the constraints for the flag with the names held by the tag.
+func (c *constraints) add(names []string, tag reflect.StructTag) {
+	if c.names == nil {
+		c.names = make(map[string]string)
+		c.exclusive = make(map[string][]string)
+	}
+	for _, n := range names {
+		c.names[n] = names[0]
+	}
+	if tag.Get("required") == "true" {
+		c.required = append(c.required, names[0])
+	}
+	if g := tag.Get("exclusive"); g != "" {
+		if _, ok := c.exclusive[g]; !ok {
+			c.groups = append(c.groups, g)
+		}
+		c.exclusive[g] = append(c.exclusive[g], names[0])
+	}
+}
+
+// check returns a command line error if a required flag was not set in f
+// or more than one flag of an exclusive group was set.
+func (c *constraints) check(name string, f *flag.FlagSet) error {
+	set := make(map[string]bool)
+	f.Visit(func(fl *flag.Flag) {
+		set[c.names[fl.Name]] = true
+	})
+	for _, r := range c.required {
+		if !set[r] {
+			return CommandLineErrorf("%s requires the -%s flag", name, r)
+		}
+	}
+	for _, g := range c.groups {
+		var used []string
+		for _, n := range c.exclusive[g] {
+			if set[n] {
+				used = append(used, "-"+n)
+			}
+		}
+		switch len(used) {
+		case 0, 1:
+		case 2:
+			return CommandLineErrorf("%s %s and %s are mutually exclusive", name, used[0], used[1])
+		default:
+			return CommandLineErrorf("%s %s and %s are mutually exclusive", name, strings.Join(used[:len(used)-1], ", "), used[len(used)-1])
+		}
+	}
+	return nil
+}
+
+// addFlag adds the flag with the name and help to the flag set.
+func addFlag(f *flag.FlagSet, flagName, help string, value reflect.Value) {
 	switch v := value.Interface().(type) {
 	case flag.Value:
 		f.Var(v, flagName, help)
@@ -207,5 +363,4 @@ func addFlags(f *flag.FlagSet, field reflect.StructField, value reflect.Value) *
 	default:
 		log.Fatalf("Cannot understand flag of type %T", v)
 	}
-	return nil
 }
@@ -0,0 +1,49 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// last implements the last command.
+type last struct {
+	*ugbt
+}
+
+func (*last) Name() string      { return "last" }
+func (*last) Usage() string     { return "" }
+func (*last) ShortHelp() string { return text("print the results of the most recent bulk update") }
+func (*last) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The last command prints the time and the table of results of the most
+recent update of more than one executable, including updates made with
+update -all. The results are recorded in the ugbt state whether or not
+the -summary flag was given to update. Dry runs are not recorded.
+
+`))
+	f.PrintDefaults()
+}
+
+// Run runs the ugbt last command.
+func (l *last) Run(ctx context.Context, args ...string) error {
+	if len(args) != 0 {
+		return errors.New("last requires no arguments")
+	}
+	s, err := loadState()
+	if err != nil {
+		return err
+	}
+	if s.LastUpdate == nil {
+		return errors.New("no bulk update recorded")
+	}
+	fmt.Printf("update finished %s\n\n", s.LastUpdate.Time.Local().Format("_2 Jan 2006 15:04"))
+	_, err = writeResults(os.Stdout, s.LastUpdate.Results)
+	return err
+}
@@ -12,8 +12,31 @@
 //            information stored in the executable.
 //   update: update an executable to the latest release if it is newer
 //           than the installed version.
+//   outdated: print a table of executables with newer versions available.
+//   stale: print a table of executables built with an older Go toolchain.
+//   rebuild: reinstall an executable at its installed version.
+//   prefetch: download updates without installing them.
 //   repo: print the source code repository for the executable.
 //   bugs: print the issues link for the executable.
+//   download: download and extract the source of a module version without installing it.
+//   clone: clone the source of an executable at its installed version.
+//   src: print the source of an executable at its installed version.
+//   trace: annotate a stack trace from an executable with source URLs.
+//   retractions: print the retractions declared by a module.
+//   backups: list or prune backups of replaced executables.
+//   undo: revert the most recent install or update.
+//   last: print the results of the most recent bulk update.
+//   sdk: manage Go SDK archives.
+//   env: print shell commands that set up the environment for ugbt.
+//   bundle: build the tools of a manifest into a distributable bundle.
+//   editor: manage the Go tool sets used by editors.
+//   size: compare the size of an executable with another version.
+//   stats: summarise the release cadence of an executable's module.
+//   prompt: print a short update summary for shell prompts.
+//   audit: check executables against the team policy and known vulnerabilities.
+//   verify: check installed executables against the ugbt state.
+//   doctor: diagnose problems with the ugbt environment.
+//   telemetry: manage opt-in usage telemetry.
 //   version: print the ugbt version information
 //   help: output ugbt help information
 //
@@ -27,5 +50,6 @@ import (
 )
 
 func main() {
+	tool.Localize = text
 	tool.Main(context.Background(), newUggboot(os.Args[0], "", nil), os.Args[1:])
 }
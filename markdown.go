@@ -0,0 +1,91 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kortschak/ugbt/internal/tool"
+)
+
+// writeMarkdown writes a markdown reference page for ugbt and for each
+// command to dir.
+func (h *help) writeMarkdown(dir string) error {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	var index bytes.Buffer
+	fmt.Fprintf(&index, "# ugbt\n\n%s\n\n", h.ugbt.ShortHelp())
+	fmt.Fprintf(&index, "Usage: `ugbt %s`\n\n", h.ugbt.Usage())
+	fmt.Fprint(&index, "## Commands\n\n")
+	for _, c := range h.commands() {
+		fmt.Fprintf(&index, "- [%[1]s](%[1]s.md): %s\n", c.Name(), c.ShortHelp())
+	}
+	fmt.Fprint(&index, "\n")
+	writeFlagTable(&index, tool.FlagSet(h.ugbt))
+	err = os.WriteFile(filepath.Join(dir, "ugbt.md"), index.Bytes(), 0o644)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range h.commands() {
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "# ugbt %s\n\n%s\n\n", c.Name(), c.ShortHelp())
+		usage := "ugbt [flags] " + c.Name() + " [command-flags]"
+		if c.Usage() != "" {
+			usage += " " + c.Usage()
+		}
+		fmt.Fprintf(&buf, "Usage: `%s`\n", usage)
+
+		// Obtain the detailed help text without the flag
+		// defaults, which are rendered as a table.
+		var text strings.Builder
+		empty := flag.NewFlagSet(c.Name(), flag.ContinueOnError)
+		empty.SetOutput(&text)
+		c.DetailedHelp(empty)
+		if help := strings.TrimSpace(text.String()); help != "" {
+			fmt.Fprintf(&buf, "\n%s\n", help)
+		}
+		fmt.Fprint(&buf, "\n")
+
+		flags := tool.FlagSet(c)
+		if _, ok := c.(*help); ok {
+			// Don't document the hidden markdown flag.
+			flags = flag.NewFlagSet(c.Name(), flag.ContinueOnError)
+		}
+		writeFlagTable(&buf, flags)
+		err = os.WriteFile(filepath.Join(dir, c.Name()+".md"), bytes.TrimRight(buf.Bytes(), "\n"), 0o644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFlagTable writes a markdown table of the flags in f to w.
+func writeFlagTable(w io.Writer, f *flag.FlagSet) {
+	var n int
+	f.VisitAll(func(*flag.Flag) { n++ })
+	if n == 0 {
+		return
+	}
+	fmt.Fprint(w, "## Flags\n\n| Flag | Default | Description |\n| --- | --- | --- |\n")
+	f.VisitAll(func(fl *flag.Flag) {
+		def := fl.DefValue
+		if def != "" {
+			def = "`" + def + "`"
+		}
+		fmt.Fprintf(w, "| `-%s` | %s | %s |\n", fl.Name, def, strings.ReplaceAll(fl.Usage, "|", `\|`))
+	})
+	fmt.Fprint(w, "\n")
+}
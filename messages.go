@@ -0,0 +1,180 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/message/catalog"
+)
+
+// The help text and common status lines printed by ugbt are looked up in a
+// message catalog so that they can be localized. Messages are keyed by
+// their English text, with help text translated a paragraph at a time so
+// that a change to one paragraph does not invalidate the translations of
+// the others. Translations are read from JSON files in the
+// ugbt/messages directory of the user config directory, one for each
+// language, named by the language's BCP 47 tag, for example de.json. Each
+// file holds an object mapping English messages to their translations.
+// The language is chosen by the UGBT_LANG environment variable, or the
+// LC_ALL, LC_MESSAGES and LANG environment variables in turn.
+var (
+	messagesOnce sync.Once
+
+	// messages is the message catalog and messageTag
+	// is the language of the catalog that is used.
+	messages   *catalog.Builder
+	messageTag = language.English
+
+	// printer formats messages in messageTag.
+	printer *message.Printer
+)
+
+// loadMessages loads the message catalog and chooses the language of the
+// messages that are printed. Invalid translation files are ignored.
+func loadMessages() {
+	messagesOnce.Do(func() {
+		messages = catalog.NewBuilder(catalog.Fallback(language.English))
+		dir, err := messagesDir()
+		if err == nil {
+			paths, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+			for _, p := range paths {
+				addMessages(messages, p)
+			}
+		}
+		langs := messages.Languages()
+		if prefs := preferredLanguages(); len(prefs) != 0 && len(langs) != 0 {
+			_, i, conf := messages.Matcher().Match(prefs...)
+			if conf != language.No {
+				messageTag = langs[i]
+			}
+		}
+		printer = message.NewPrinter(messageTag, message.Catalog(messages))
+	})
+}
+
+// messagesDir returns the directory holding translation files.
+func messagesDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ugbt", "messages"), nil
+}
+
+// addMessages adds the translations in the file at path to the catalog.
+// The language of the translations is given by the file's name.
+func addMessages(c *catalog.Builder, path string) {
+	tag, err := language.Parse(strings.TrimSuffix(filepath.Base(path), ".json"))
+	if err != nil {
+		return
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var translations map[string]string
+	if json.Unmarshal(b, &translations) != nil {
+		return
+	}
+	for key, msg := range translations {
+		c.SetString(tag, key, msg)
+	}
+}
+
+// preferredLanguages returns the user's preferred languages from the
+// environment. The C and POSIX locales do not name a language.
+func preferredLanguages() []language.Tag {
+	var tags []language.Tag
+	for _, v := range []string{"UGBT_LANG", "LC_ALL", "LC_MESSAGES", "LANG"} {
+		// Locale names hold a language and region separated
+		// by an underscore, and optionally an encoding and
+		// modifier, for example de_DE.UTF-8@euro.
+		l := os.Getenv(v)
+		if i := strings.IndexAny(l, ".@"); i >= 0 {
+			l = l[:i]
+		}
+		if l == "" || l == "C" || l == "POSIX" {
+			continue
+		}
+		tag, err := language.Parse(strings.ReplaceAll(l, "_", "-"))
+		if err == nil {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// text returns the translation of the message s, which is not a format
+// string. Each paragraph of s, separated by a blank line, is translated
+// separately. Leading and trailing white space is not part of a paragraph's
+// key, and is retained in the translation. If a paragraph has no
+// translation, it is left unchanged.
+func text(s string) string {
+	loadMessages()
+	if messageTag == language.English {
+		return s
+	}
+	paras := strings.Split(s, "\n\n")
+	for i, p := range paras {
+		paras[i] = paragraph(p)
+	}
+	return strings.Join(paras, "\n\n")
+}
+
+// paragraph returns the translation of the paragraph p, or p if there is
+// no translation.
+func paragraph(p string) string {
+	key := strings.TrimSpace(p)
+	if key == "" {
+		return p
+	}
+	var r textRenderer
+	if messages.Context(messageTag, &r).Execute(key) != nil {
+		return p
+	}
+	start := strings.Index(p, key)
+	return p[:start] + r.String() + p[start+len(key):]
+}
+
+// textRenderer collects the text of a message without interpreting it as a
+// format string.
+type textRenderer struct {
+	strings.Builder
+}
+
+func (r *textRenderer) Render(s string)   { r.WriteString(s) }
+func (*textRenderer) Arg(int) interface{} { return nil }
+
+// sprintf returns the translation of the message with the provided format
+// formatted with args. As for text, leading and trailing white space is not
+// part of the message's key. If there are no translations, the message is
+// formatted as for fmt.Sprintf.
+func sprintf(format string, args ...interface{}) string {
+	loadMessages()
+	if messageTag == language.English {
+		// Keep the formatting of values identical
+		// to the untranslated output.
+		return fmt.Sprintf(format, args...)
+	}
+	key := strings.TrimSpace(format)
+	start := strings.Index(format, key)
+	return format[:start] + printer.Sprintf(key, args...) + format[start+len(key):]
+}
+
+// fprintf writes the translation of the message with the provided format
+// formatted with args to w. If there is no translation, the message is
+// formatted as for fmt.Fprintf.
+func fprintf(w io.Writer, format string, args ...interface{}) (int, error) {
+	return io.WriteString(w, sprintf(format, args...))
+}
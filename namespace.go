@@ -0,0 +1,336 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/mod/semver"
+
+	"github.com/kortschak/ugbt/internal/tool"
+)
+
+const (
+	// indexURL is the module index queried for the modules in a
+	// namespace.
+	indexURL = "https://index.golang.org/index"
+
+	// indexPage is the number of index entries requested at a time.
+	indexPage = 2000
+
+	// indexDefaultAge is how far back the index is read for a module
+	// path prefix that has not been scanned before when no -since
+	// date is given.
+	indexDefaultAge = 365 * 24 * time.Hour
+)
+
+// namespaceCache is the cached result of scanning the module index for the
+// modules with a module path prefix.
+type namespaceCache struct {
+	Prefix string `json:"prefix"`
+
+	// Since is the time of the last index entry read.
+	Since time.Time `json:"since"`
+
+	// Modules is the set of modules found with
+	// the prefix.
+	Modules []string `json:"modules"`
+
+	// Commands holds the command package paths
+	// keyed by module@version for the module
+	// versions that have been inspected.
+	Commands map[string][]string `json:"commands,omitempty"`
+}
+
+// namespaceCachePath returns the path of the cached index scan for the
+// module path prefix.
+func namespaceCachePath(prefix string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(prefix))
+	return filepath.Join(dir, "ugbt", "namespaces", hex.EncodeToString(sum[:])+".json"), nil
+}
+
+// loadNamespaceCache returns the cached index scan at path for the prefix.
+// A missing or invalid cache results in an empty scan.
+func loadNamespaceCache(path, prefix string) namespaceCache {
+	var c namespaceCache
+	buf, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(buf, &c)
+	}
+	if err != nil || c.Prefix != prefix {
+		c = namespaceCache{Prefix: prefix}
+	}
+	if c.Commands == nil {
+		c.Commands = make(map[string][]string)
+	}
+	return c
+}
+
+func (c namespaceCache) save(path string) error {
+	buf, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return writeCacheFile(path, buf)
+}
+
+// runNamespace runs the ugbt list command with the -module-prefix flag,
+// printing the latest version of each command module with the prefix.
+func (l *list) runNamespace(ctx context.Context, args ...string) error {
+	if len(args) != 0 {
+		return tool.CommandLineErrorf("list -module-prefix does not accept an executable")
+	}
+	suffix, err := regexp.Compile(l.PreRelease)
+	if err != nil {
+		return err
+	}
+	since, err := parseDate("since", l.Since, false)
+	if err != nil {
+		return err
+	}
+	prefix := l.ModulePrefix
+
+	cachePath, err := namespaceCachePath(prefix)
+	if err != nil {
+		return err
+	}
+	cache := loadNamespaceCache(cachePath, prefix)
+	mods, ok := l.config.Namespaces[prefix]
+	if !ok {
+		err = l.scanIndex(ctx, &cache, since)
+		if err != nil {
+			return err
+		}
+		mods = cache.Modules
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.DiscardEmptyColumns)
+	var n int
+	for _, mod := range mods {
+		versions, err := l.availableVersions(ctx, mod, "", true, versionFilter{
+			keep: func(version string) bool {
+				return suffix.MatchString(semver.Prerelease(version))
+			},
+			unretracted: true,
+			limit:       1,
+			quiet:       true,
+		})
+		if err != nil {
+			fprintf(os.Stderr, "skipping %s: %v\n", mod, err)
+			continue
+		}
+		if len(versions) == 0 {
+			continue
+		}
+		v := versions[0]
+		key := mod + "@" + v.Version
+		cmds, ok := cache.Commands[key]
+		if !ok {
+			cmds, err = l.commandPackages(ctx, mod, v.Version)
+			if err != nil {
+				fprintf(os.Stderr, "skipping %s: %v\n", key, err)
+				continue
+			}
+			cache.Commands[key] = cmds
+		}
+		if len(cmds) == 0 {
+			continue
+		}
+		names := make([]string, len(cmds))
+		for i, c := range cmds {
+			names[i] = exeName(c)
+		}
+		fmt.Fprintf(w, "%s\t%s", mod, v.Version)
+		if !v.Time.IsZero() {
+			fmt.Fprintf(w, "\t%s", v.Time.Format("_2 Jan 2006 15:04"))
+		}
+		fmt.Fprintf(w, "\t%s\n", strings.Join(names, " "))
+		n++
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	err = cache.save(cachePath)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		fmt.Fprintf(os.Stderr, "no command modules found with prefix %s\n", prefix)
+	}
+	return nil
+}
+
+// scanIndex reads the module index entries after the last entry read for
+// the cache's prefix, or after since if it is later, adding the modules with
+// the prefix to the cache. If the prefix has not been scanned before and
+// since is zero, the index is read from indexDefaultAge ago.
+func (u *ugbt) scanIndex(ctx context.Context, cache *namespaceCache, since time.Time) error {
+	start := cache.Since
+	if start.IsZero() && since.IsZero() {
+		since = time.Now().Add(-indexDefaultAge)
+		fmt.Fprintf(os.Stderr, "reading the module index from %s; use -since to read from an earlier date\n", since.Format("2006-01-02"))
+	}
+	if since.After(start) {
+		start = since
+	}
+	seen := make(map[string]bool)
+	for _, m := range cache.Modules {
+		seen[m] = true
+	}
+	for {
+		last := start
+		q := url.Values{
+			"since": {start.UTC().Format(time.RFC3339Nano)},
+			"limit": {fmt.Sprint(indexPage)},
+		}
+		buf, err := u.get(ctx, indexURL+"?"+q.Encode())
+		if err != nil {
+			return fmt.Errorf("query module index: %w", err)
+		}
+		var n int
+		sc := bufio.NewScanner(bytes.NewReader(buf))
+		for sc.Scan() {
+			var e struct {
+				Path      string
+				Version   string
+				Timestamp time.Time
+			}
+			err = json.Unmarshal(sc.Bytes(), &e)
+			if err != nil {
+				return fmt.Errorf("invalid module index entry: %w", err)
+			}
+			n++
+			if e.Timestamp.After(start) {
+				start = e.Timestamp
+			}
+			if strings.HasPrefix(e.Path, cache.Prefix) && !seen[e.Path] {
+				seen[e.Path] = true
+				cache.Modules = append(cache.Modules, e.Path)
+			}
+		}
+		err = sc.Err()
+		if err != nil {
+			return err
+		}
+		cache.Since = start
+		if n < indexPage || !start.After(last) {
+			break
+		}
+	}
+	sort.Strings(cache.Modules)
+	return nil
+}
+
+// commandPackages returns the paths of the main packages in the module at
+// the version, read from the module zip held by the module proxy. Packages
+// in testdata and vendor directories and in nested modules are not
+// included, and files are only considered if they match the build
+// constraints of the current platform.
+func (u *ugbt) commandPackages(ctx context.Context, mod, version string) ([]string, error) {
+	buf, ok, err := u.proxyFile(ctx, mod, version, ".zip")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, withKind(kindNotFound, fmt.Errorf("no proxy holds %s@%s", mod, version))
+	}
+	z, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid module zip: %w", err)
+	}
+	root := mod + "@" + version + "/"
+	nested := make(map[string]bool)
+	for _, f := range z.File {
+		name := strings.TrimPrefix(f.Name, root)
+		if path.Base(name) == "go.mod" && name != "go.mod" {
+			nested[path.Dir(name)] = true
+		}
+	}
+	dirs := make(map[string]bool)
+	for _, f := range z.File {
+		name := strings.TrimPrefix(f.Name, root)
+		dir := path.Dir(name)
+		if dirs[dir] || !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") || excludedDir(dir, nested) {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		src, err := io.ReadAll(io.LimitReader(r, 64<<10))
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		// Files excluded by build constraints, including
+		// the ignore tag used for generators, and by their
+		// GOOS and GOARCH suffixes are not built by go install.
+		ctxt := build.Default
+		ctxt.OpenFile = func(string) (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(src)), nil
+		}
+		match, err := ctxt.MatchFile(dir, path.Base(name))
+		if err != nil || !match {
+			continue
+		}
+		file, err := parser.ParseFile(token.NewFileSet(), name, src, parser.PackageClauseOnly)
+		if err != nil {
+			continue
+		}
+		if file.Name.Name == "main" {
+			dirs[dir] = true
+		}
+	}
+	pkgs := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		if dir == "." {
+			pkgs = append(pkgs, mod)
+		} else {
+			pkgs = append(pkgs, mod+"/"+dir)
+		}
+	}
+	sort.Strings(pkgs)
+	return pkgs, nil
+}
+
+// excludedDir returns whether the module zip directory dir is a testdata
+// or vendor directory, or is within a nested module.
+func excludedDir(dir string, nested map[string]bool) bool {
+	for d := dir; d != "." && d != "/"; d = path.Dir(d) {
+		switch path.Base(d) {
+		case "testdata", "vendor":
+			return true
+		}
+		if nested[d] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,146 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notifyInterval is the age of the cached update summary after which it
+// is refreshed in the background for update hints.
+const notifyInterval = 24 * time.Hour
+
+// notifySkip is the set of commands that do not print update hints since
+// they report or act on updates themselves, or print output for other
+// programs.
+var notifySkip = map[string]bool{
+	"list":     true,
+	"install":  true,
+	"update":   true,
+	"outdated": true,
+	"stale":    true,
+	"rebuild":  true,
+	"prefetch": true,
+	"undo":     true,
+	"editor":   true,
+	"prompt":   true,
+	"audit":    true,
+}
+
+// noteQueried records that the build information of the executable at
+// exepath has been read by the running command. An empty exepath refers
+// to ugbt.
+func (u *ugbt) noteQueried(exepath string) {
+	name := "ugbt"
+	if exepath != "" {
+		name = exeBase(exepath)
+	}
+	q := u.queried
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.paths == nil {
+		q.paths = make(map[string]string)
+	}
+	if _, ok := q.paths[name]; !ok {
+		q.paths[name] = exepath
+	}
+}
+
+// queriedSet is the set of executables queried by a command.
+type queriedSet struct {
+	mu    sync.Mutex
+	paths map[string]string
+}
+
+// notify writes a single line hint to w if a newer ugbt release is
+// available, and if the cached update summary used by the prompt command
+// shows that an executable queried by the command has an update. Hints are
+// only written when w is a terminal and they are not disabled in the ugbt
+// config. If the command queried an executable and the cached summary is
+// missing or older than notifyInterval, the summary is refreshed in the
+// background for later commands.
+func (u *ugbt) notify(ctx context.Context, w io.Writer, command string) {
+	if u.config.Notify.Disabled || notifySkip[command] || !isTerminal(w) {
+		return
+	}
+	self := u.notifySelf(ctx, w)
+
+	u.queried.mu.Lock()
+	queried := make(map[string]string, len(u.queried.paths)+1)
+	for name, exepath := range u.queried.paths {
+		queried[name] = exepath
+	}
+	u.queried.mu.Unlock()
+	if self {
+		delete(queried, "ugbt")
+	}
+	if len(queried) == 0 {
+		// Commands that do not act on executables
+		// have nothing to hint about.
+		return
+	}
+
+	path, err := promptCachePath()
+	if err != nil {
+		return
+	}
+	var cached promptCache
+	b, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(b, &cached)
+	}
+	if err != nil || time.Since(cached.Checked) > notifyInterval {
+		p := &prompt{ugbt: u}
+		p.refreshBackground(path)
+	}
+	if err != nil {
+		return
+	}
+
+	var names []string
+	for _, name := range cached.Outdated {
+		exepath, ok := queried[name]
+		if !ok {
+			continue
+		}
+		if exepath == "" {
+			exepath, err = os.Executable()
+			if err != nil {
+				continue
+			}
+		}
+		if fi, err := os.Stat(exepath); err == nil && fi.ModTime().After(cached.Checked) {
+			// The executable has been replaced since
+			// the summary was cached.
+			continue
+		}
+		names = append(names, name)
+	}
+	switch len(names) {
+	case 0:
+	case 1:
+		fprintf(w, "note: an update is available for %s; run ugbt list %[1]s for details\n", names[0])
+	default:
+		sort.Strings(names)
+		fprintf(w, "note: updates are available for %s; run ugbt list <name> for details\n", strings.Join(names, ", "))
+	}
+}
+
+// isTerminal returns whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
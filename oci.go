@@ -0,0 +1,252 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// imageBinDir and imageShareDir are the directories in tool
+	// images that the executables and SBOM of a bundle are placed in.
+	imageBinDir   = "usr/local/bin"
+	imageShareDir = "usr/local/share/ugbt"
+
+	ociIndexType    = "application/vnd.oci.image.index.v1+json"
+	ociManifestType = "application/vnd.oci.image.manifest.v1+json"
+	ociConfigType   = "application/vnd.oci.image.config.v1+json"
+	ociLayerType    = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+// ociDescriptor is an OCI content descriptor.
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ociIndex is an OCI image index.
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+// ociManifest is an OCI image manifest.
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// ociConfig is an OCI image configuration.
+type ociConfig struct {
+	Created      string         `json:"created"`
+	Architecture string         `json:"architecture"`
+	OS           string         `json:"os"`
+	Config       ociImageConfig `json:"config"`
+	RootFS       ociRootFS      `json:"rootfs"`
+}
+
+type ociImageConfig struct {
+	Env []string `json:"Env,omitempty"`
+}
+
+type ociRootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// splitOCIRef splits an OCI layout reference into the layout directory and
+// tag. The tag is "latest" if it is not given.
+func splitOCIRef(ref string) (dir, tag string) {
+	i := strings.LastIndex(ref, ":")
+	if i < 0 || strings.ContainsAny(ref[i+1:], `/\`) || i == 1 && filepath.VolumeName(ref) != "" {
+		return ref, "latest"
+	}
+	return ref[:i], ref[i+1:]
+}
+
+// writeOCILayout writes an OCI image layout to dir holding a single layer
+// image tagged with tag for goos and goarch. The layer holds the files in
+// src, with the executables in imageBinDir and the SBOM in imageShareDir.
+func writeOCILayout(dir, tag, src string, files []string, goos, goarch string) (err error) {
+	blobs := filepath.Join(dir, "blobs", "sha256")
+	err = os.MkdirAll(blobs, 0o755)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	layer, diffID, err := writeLayer(blobs, src, files)
+	if err != nil {
+		return err
+	}
+	config, err := writeBlob(blobs, ociConfigType, ociConfig{
+		Created:      time.Now().UTC().Format(time.RFC3339),
+		Architecture: goarch,
+		OS:           goos,
+		Config: ociImageConfig{
+			Env: []string{"PATH=/" + imageBinDir + ":/usr/local/sbin:/usr/sbin:/usr/bin:/sbin:/bin"},
+		},
+		RootFS: ociRootFS{Type: "layers", DiffIDs: []string{diffID}},
+	})
+	if err != nil {
+		return err
+	}
+	manifest, err := writeBlob(blobs, ociManifestType, ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestType,
+		Config:        config,
+		Layers:        []ociDescriptor{layer},
+	})
+	if err != nil {
+		return err
+	}
+	manifest.Annotations = map[string]string{"org.opencontainers.image.ref.name": tag}
+	err = writeJSON(filepath.Join(dir, "index.json"), ociIndex{
+		SchemaVersion: 2,
+		MediaType:     ociIndexType,
+		Manifests:     []ociDescriptor{manifest},
+	})
+	if err != nil {
+		return err
+	}
+	return writeJSON(filepath.Join(dir, "oci-layout"), struct {
+		Version string `json:"imageLayoutVersion"`
+	}{Version: "1.0.0"})
+}
+
+// imagePath returns the path of the bundle file name in a tool image.
+func imagePath(name string) string {
+	if name == sbomName {
+		return path.Join(imageShareDir, name)
+	}
+	return path.Join(imageBinDir, name)
+}
+
+// writeLayer writes a gzipped layer tarball holding the files in src to
+// the blobs directory and returns its descriptor and the digest of the
+// uncompressed tarball.
+func writeLayer(blobs, src string, files []string) (desc ociDescriptor, diffID string, err error) {
+	f, err := os.CreateTemp(blobs, ".layer-*")
+	if err != nil {
+		return ociDescriptor{}, "", err
+	}
+	defer func() {
+		f.Close()
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
+	compressed := sha256.New()
+	counter := &countWriter{w: io.MultiWriter(f, compressed)}
+	gz := gzip.NewWriter(counter)
+	uncompressed := sha256.New()
+	tw := tar.NewWriter(io.MultiWriter(gz, uncompressed))
+	for _, d := range []string{"usr/", "usr/local/", "usr/local/bin/", "usr/local/share/", "usr/local/share/ugbt/"} {
+		err = tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: d, Mode: 0o755})
+		if err != nil {
+			return ociDescriptor{}, "", err
+		}
+	}
+	for _, name := range files {
+		err = addTarFile(tw, filepath.Join(src, name), imagePath(name))
+		if err != nil {
+			return ociDescriptor{}, "", err
+		}
+	}
+	err = tw.Close()
+	if err != nil {
+		return ociDescriptor{}, "", err
+	}
+	err = gz.Close()
+	if err != nil {
+		return ociDescriptor{}, "", err
+	}
+	err = f.Close()
+	if err != nil {
+		return ociDescriptor{}, "", err
+	}
+	digest := hexDigest(compressed)
+	err = os.Rename(f.Name(), filepath.Join(blobs, digest))
+	if err != nil {
+		return ociDescriptor{}, "", err
+	}
+	desc = ociDescriptor{MediaType: ociLayerType, Digest: "sha256:" + digest, Size: counter.n}
+	return desc, "sha256:" + hexDigest(uncompressed), nil
+}
+
+// writeBlob writes the JSON encoding of v to the blobs directory and
+// returns its descriptor with the provided media type.
+func writeBlob(blobs, mediaType string, v interface{}) (ociDescriptor, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	sum := sha256.Sum256(buf)
+	digest := hex.EncodeToString(sum[:])
+	err = os.WriteFile(filepath.Join(blobs, digest), buf, 0o644)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	return ociDescriptor{MediaType: mediaType, Digest: "sha256:" + digest, Size: int64(len(buf))}, nil
+}
+
+// writeJSON writes the JSON encoding of v to path.
+func writeJSON(path string, v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf, 0o644)
+}
+
+func hexDigest(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+// writeDockerfile writes a Dockerfile to dir that copies the bundle files
+// into a scratch image at the locations used for OCI images.
+func writeDockerfile(dir, manifest string, files []string) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Generated by ugbt bundle from %s.\nFROM scratch\n", manifest)
+	for _, name := range files {
+		fmt.Fprintf(&buf, "COPY %s /%s\n", name, imagePath(name))
+	}
+	fmt.Fprintf(&buf, "ENV PATH=/%s:/usr/local/sbin:/usr/sbin:/usr/bin:/sbin:/bin\n", imageBinDir)
+	return os.WriteFile(filepath.Join(dir, "Dockerfile"), buf.Bytes(), 0o644)
+}
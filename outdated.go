@@ -0,0 +1,109 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"text/tabwriter"
+)
+
+// outdated implements the outdated command.
+type outdated struct {
+	*ugbt
+
+	PreRelease string `flag:"suffix,s" help:"only report versions with a pre-release matching the regexp pattern"`
+	Selection
+}
+
+func (*outdated) Name() string  { return "outdated" }
+func (*outdated) Usage() string { return "[/path/to/go/executable ...]" }
+func (*outdated) ShortHelp() string {
+	return text("print a table of executables with newer versions available")
+}
+func (*outdated) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The outdated command reads the build information of the provided Go
+executables, or of all the Go executables in the install directory, the bin
+directories of the GOPATH elements and the "dirs" of the "scan" section of
+the ugbt config if none are provided, queries the module proxies for their
+versions, and prints a table of the executables that have a newer version
+that update would install, with the installed and newer versions and the
+package the executable is built from. By default only releases are
+reported, or versions matching the "suffix" pre-release pattern configured
+for the executable. Nothing is installed.
+
+`+selectionHelp+`
+`))
+	f.PrintDefaults()
+}
+
+// Run runs the ugbt outdated command.
+func (o *outdated) Run(ctx context.Context, args ...string) error {
+	exes := args
+	if len(exes) == 0 {
+		var err error
+		exes, err = o.binExecutables(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	exes, err := o.selectExecutables(ctx, exes, o.Selection)
+	if err != nil {
+		return err
+	}
+	if len(exes) == 0 {
+		return errors.New("no Go executables found")
+	}
+	suffix, err := regexp.Compile(o.PreRelease)
+	if err != nil {
+		return err
+	}
+
+	u := &update{ugbt: o.ugbt, PreRelease: o.PreRelease}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	var n, failed int
+	for _, exe := range exes {
+		suffix := suffix
+		if tool, ok := o.tool(exe); ok && tool.Suffix != "" && o.PreRelease == "^$" {
+			// Use the tool's policy in place of the default.
+			suffix, err = regexp.Compile(tool.Suffix)
+			if err != nil {
+				return fmt.Errorf("invalid suffix for %s in config: %w", exeBase(exe), err)
+			}
+		}
+		t, ok, err := u.target(ctx, exe, suffix, io.Discard)
+		if err != nil {
+			fprintf(os.Stderr, "skipping %s: %v\n", exeBase(exe), err)
+			failed++
+			continue
+		}
+		if !ok {
+			continue
+		}
+		n++
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", exeBase(exe), t.current, t.version, t.path)
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	if n == 0 && failed == 0 {
+		fprintf(os.Stderr, "all %d executables are up to date\n", len(exes))
+	}
+	switch failed {
+	case 0:
+		return nil
+	case 1:
+		return errors.New("1 executable could not be checked")
+	default:
+		return fmt.Errorf("%d executables could not be checked", failed)
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+
+	"github.com/kortschak/ugbt/internal/modrepo"
+)
+
+// overrideModulePath is the module path of the temporary module that is
+// synthesized to build an executable with dependency overrides.
+const overrideModulePath = "ugbt.override"
+
+// overrides are the dependency overrides an executable is built with.
+type overrides struct {
+	// Replace holds the old[@v]=new[@v] replacements.
+	Replace []string `json:"replace,omitempty"`
+	// Require holds the mod@v requirements.
+	Require []string `json:"require,omitempty"`
+}
+
+// String returns a description of the overrides for messages.
+func (o *overrides) String() string {
+	var parts []string
+	for _, r := range o.Replace {
+		parts = append(parts, "-replace "+r)
+	}
+	for _, r := range o.Require {
+		parts = append(parts, "-require "+r)
+	}
+	return strings.Join(parts, " ")
+}
+
+// parseOverrides returns the overrides held in the comma-separated replace
+// and require flag values. Replacement targets without a version are local
+// directories and are made absolute relative to wd. It returns nil if there
+// are no overrides.
+func parseOverrides(replace, require, wd string) (*overrides, error) {
+	var o overrides
+	for _, r := range splitList(replace) {
+		old, new, ok := strings.Cut(r, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid -replace %q: want old[@v]=new[@v]", r)
+		}
+		oldPath, oldVersion, err := parseOverrideVersion(old, true)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -replace %q: %w", r, err)
+		}
+		if modfile.IsDirectoryPath(new) {
+			if !filepath.IsAbs(new) {
+				new = filepath.Join(wd, new)
+			}
+			if _, err := os.Stat(filepath.Join(new, "go.mod")); err != nil {
+				return nil, fmt.Errorf("invalid -replace %q: %w", r, err)
+			}
+		} else {
+			_, newVersion, err := parseOverrideVersion(new, true)
+			if err != nil {
+				return nil, fmt.Errorf("invalid -replace %q: %w", r, err)
+			}
+			if newVersion == "" {
+				return nil, fmt.Errorf("invalid -replace %q: replacement module requires a version or must be a local directory", r)
+			}
+		}
+		if oldVersion != "" {
+			old = oldPath + "@" + oldVersion
+		}
+		o.Replace = append(o.Replace, old+"="+new)
+	}
+	for _, r := range splitList(require) {
+		_, version, err := parseOverrideVersion(r, false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -require %q: %w", r, err)
+		}
+		if version == "" {
+			return nil, fmt.Errorf("invalid -require %q: want mod@v", r)
+		}
+		o.Require = append(o.Require, r)
+	}
+	if len(o.Replace) == 0 && len(o.Require) == 0 {
+		return nil, nil
+	}
+	return &o, nil
+}
+
+// parseOverrideVersion returns the module path and version of the mod[@v]
+// argument s. If exact is true, any version must be a semantic version
+// rather than a version query.
+func parseOverrideVersion(s string, exact bool) (mod, version string, err error) {
+	mod, version, _ = strings.Cut(s, "@")
+	err = module.CheckImportPath(mod)
+	if err != nil {
+		return "", "", err
+	}
+	if exact && version != "" && !semver.IsValid(version) {
+		return "", "", fmt.Errorf("%s is not a semantic version", version)
+	}
+	return mod, version, nil
+}
+
+// splitList returns the non-empty elements of the comma-separated list s.
+func splitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		e = strings.TrimSpace(e)
+		if e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
+
+// overrideModule returns a temporary directory holding a module that
+// requires the package path at the version with the overrides applied, so
+// that the package can be built with go install from within it. The caller
+// is responsible for removing the directory.
+func (u *ugbt) overrideModule(ctx context.Context, path, mod, version string, o *overrides, flags BuildFlags) (dir string, err error) {
+	f := &modfile.File{Syntax: &modfile.FileSyntax{}}
+	err = f.AddModuleStmt(overrideModulePath)
+	if err != nil {
+		return "", err
+	}
+	if semver.IsValid(version) {
+		// Use the tool's own language version so that its
+		// module graph is treated the same way as when it
+		// is installed directly. Failing this, go get will
+		// raise the go directive as needed.
+		if goVersion, _ := u.goDirective(ctx, mod, version); goVersion != "" {
+			err = f.AddGoStmt(goVersion)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	for _, r := range o.Replace {
+		oldPath, newPath, _ := strings.Cut(r, "=")
+		oldPath, oldVersion, _ := strings.Cut(oldPath, "@")
+		var newVersion string
+		if !modfile.IsDirectoryPath(newPath) {
+			newPath, newVersion, _ = strings.Cut(newPath, "@")
+		}
+		err = f.AddReplace(oldPath, oldVersion, newPath, newVersion)
+		if err != nil {
+			return "", err
+		}
+	}
+	buf, err := f.Format()
+	if err != nil {
+		return "", err
+	}
+
+	dir, err = os.MkdirTemp("", "ugbt-override-")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+	err = os.WriteFile(filepath.Join(dir, "go.mod"), buf, 0o644)
+	if err != nil {
+		return "", err
+	}
+
+	var stderr bytes.Buffer
+	args := append([]string{"get", path + "@" + version}, o.Require...)
+	cmd := u.cmd(ctx, nil, &stderr, args...)
+	cmd.Dir = dir
+	if pin := u.pin(path); pin != "" {
+		err = u.usePinned(ctx, cmd, pin, flags)
+		if err != nil {
+			return "", err
+		}
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, "GOFLAGS=-mod=mod", "GOWORK=off")
+	err = cmd.Run()
+	if err != nil {
+		return "", withKind(goOutputKind(stderr.String()), fmt.Errorf("go get: %s", strings.TrimSpace(stderr.String())))
+	}
+	return dir, nil
+}
+
+// builtModule returns the module providing the executable's package as
+// recorded in the build information. This is the main module unless the
+// executable was built with dependency overrides, in which case it is the
+// dependency holding the package.
+func builtModule(info *debug.BuildInfo) *debug.Module {
+	if info.Main.Path != overrideModulePath {
+		return &info.Main
+	}
+	var found *debug.Module
+	for _, m := range info.Deps {
+		if modrepo.HasPathPrefix(info.Path, m.Path) && (found == nil || len(m.Path) > len(found.Path)) {
+			found = m
+		}
+	}
+	if found == nil {
+		return &info.Main
+	}
+	return found
+}
+
+// installOverrides returns the dependency overrides recorded in the ugbt
+// state for the executable installed for the package path.
+func (u *ugbt) installOverrides(ctx context.Context, pkg string) (*overrides, bool) {
+	dst, err := u.installPath(ctx, pkg)
+	if err != nil {
+		return nil, false
+	}
+	s, err := loadState()
+	if err != nil {
+		return nil, false
+	}
+	inst, ok := s.Installed[dst]
+	return inst.Overrides, ok && inst.Overrides != nil
+}
@@ -0,0 +1,615 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+
+	"github.com/kortschak/ugbt/internal/tool"
+)
+
+// policy is a team policy declaring the versions of tools that may be
+// installed.
+type policy struct {
+	// Tools holds the policies for tools keyed by
+	// package path, module path or executable name.
+	Tools map[string]toolPolicy `json:"tools"`
+}
+
+// toolPolicy is the version policy for a tool.
+type toolPolicy struct {
+	// Allowed is a list of version ranges, each a space
+	// separated list of comparisons such as ">=v1.2.0 <v2.0.0".
+	// A version is allowed if it is in any of the ranges. If
+	// empty, all versions are allowed.
+	Allowed []string `json:"allowed"`
+
+	// Banned is a list of versions that are not allowed.
+	Banned []string `json:"banned"`
+
+	// Minimum is the oldest version that may be installed.
+	Minimum string `json:"minimum"`
+}
+
+// violation returns a description of how the version violates the tool
+// policy, or the empty string if it does not.
+func (p toolPolicy) violation(version string) string {
+	for _, b := range p.Banned {
+		if b == version {
+			return "banned"
+		}
+	}
+	if p.Minimum != "" && semver.Compare(version, p.Minimum) < 0 {
+		return "older than the required minimum " + p.Minimum
+	}
+	if len(p.Allowed) == 0 {
+		return ""
+	}
+	for _, r := range p.Allowed {
+		if inRange(version, r) {
+			return ""
+		}
+	}
+	return "outside the allowed versions " + strings.Join(p.Allowed, " or ")
+}
+
+// check returns an error if the tool policy is not valid.
+func (p toolPolicy) check() error {
+	for _, b := range p.Banned {
+		if !semver.IsValid(b) {
+			return fmt.Errorf("invalid banned version %q", b)
+		}
+	}
+	if p.Minimum != "" && !semver.IsValid(p.Minimum) {
+		return fmt.Errorf("invalid minimum version %q", p.Minimum)
+	}
+	for _, r := range p.Allowed {
+		f := strings.Fields(r)
+		if len(f) == 0 {
+			return errors.New("empty allowed version range")
+		}
+		for _, c := range f {
+			op, v := splitComparison(c)
+			if !validComparison(op) || !semver.IsValid(v) {
+				return fmt.Errorf("invalid allowed version range %q", r)
+			}
+		}
+	}
+	return nil
+}
+
+// inRange returns whether the version satisfies all the comparisons in
+// the version range r.
+func inRange(version, r string) bool {
+	for _, c := range strings.Fields(r) {
+		op, v := splitComparison(c)
+		cmp := semver.Compare(version, v)
+		var ok bool
+		switch op {
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "=", "":
+			ok = cmp == 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// validComparison returns whether op is a version comparison operator
+// accepted in allowed version ranges. An empty operator is an equality
+// comparison.
+func validComparison(op string) bool {
+	switch op {
+	case "<", "<=", ">", ">=", "=", "":
+		return true
+	default:
+		return false
+	}
+}
+
+// splitComparison splits a version comparison such as ">=v1.2.0" into its
+// operator and version.
+func splitComparison(c string) (op, version string) {
+	i := strings.IndexFunc(c, func(r rune) bool { return !strings.ContainsRune("<>=", r) })
+	if i < 0 {
+		return c, ""
+	}
+	return c[:i], c[i:]
+}
+
+// lookup returns the policy for the tool built from the package pkg in the
+// module mod with the executable name.
+func (p *policy) lookup(name, pkg, mod string) (toolPolicy, bool) {
+	if p == nil {
+		return toolPolicy{}, false
+	}
+	for _, key := range []string{pkg, mod, name} {
+		if key == "" {
+			continue
+		}
+		if tp, ok := p.Tools[key]; ok {
+			return tp, true
+		}
+	}
+	return toolPolicy{}, false
+}
+
+// policyCachePath returns the path of the cached copy of a policy fetched
+// from a URL.
+func policyCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ugbt", "policy.json"), nil
+}
+
+// teamPolicy returns the team policy named by the policy section of the
+// ugbt config, or nil if there is none. A policy at an http or https URL
+// is fetched on each run and cached, and the cached copy is used with a
+// warning if it can not be fetched. If a signature format is configured,
+// the policy is only used if its detached signature is valid.
+func (u *ugbt) teamPolicy(ctx context.Context) (*policy, error) {
+	u.policyOnce.Do(func() {
+		u.policy, u.policyErr = u.loadPolicy(ctx)
+	})
+	return u.policy, u.policyErr
+}
+
+// loadPolicy reads, verifies and validates the team policy named by the
+// policy section of the ugbt config. It returns nil if no policy is
+// configured.
+func (u *ugbt) loadPolicy(ctx context.Context) (*policy, error) {
+	cfg := u.config.Policy
+	src := cfg.Source
+	if src == "" {
+		return nil, nil
+	}
+	err := cfg.Signature.check()
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy signature config: %w", err)
+	}
+	signed := cfg.Signature.Format != ""
+	var sigSrc string
+	if signed {
+		sigSrc = cfg.Signature.location(src)
+	}
+
+	var (
+		buf, sig []byte
+		cache    string
+	)
+	if isURL(src) {
+		path, cacheErr := policyCachePath()
+		buf, sig, err = u.readSigned(ctx, src, sigSrc)
+		switch {
+		case err == nil && cacheErr == nil:
+			cache = path
+		case err != nil && cacheErr == nil:
+			fi, statErr := os.Stat(path)
+			if statErr != nil {
+				return nil, fmt.Errorf("fetch policy: %w", err)
+			}
+			fprintf(os.Stderr, "warning: could not fetch policy from %s: %v; using the copy fetched %s\n", src, err, fi.ModTime().Format(time.RFC3339))
+			var cachedSig string
+			if signed {
+				cachedSig = path + ".sig"
+			}
+			buf, sig, err = u.readSigned(ctx, path, cachedSig)
+		}
+	} else {
+		buf, sig, err = u.readSigned(ctx, src, sigSrc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read policy: %w", err)
+	}
+	if signed {
+		err = cfg.Signature.verify(ctx, buf, sig)
+		if err != nil {
+			return nil, fmt.Errorf("policy %s: %w", src, err)
+		}
+	}
+	var p policy
+	err = json.Unmarshal(buf, &p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", src, err)
+	}
+	for name, tp := range p.Tools {
+		err = tp.check()
+		if err != nil {
+			return nil, fmt.Errorf("invalid policy %s for %s: %w", src, name, err)
+		}
+	}
+	if cache != "" {
+		// Only cache a policy that has been verified so that
+		// the fallback copy is always trustworthy.
+		if writeCacheFile(cache, buf) == nil && signed {
+			writeCacheFile(cache+".sig", sig)
+		}
+	}
+	return &p, nil
+}
+
+// readSigned returns the contents of the file at the path or URL src and of
+// its signature at sigSrc. If sigSrc is empty, no signature is read.
+func (u *ugbt) readSigned(ctx context.Context, src, sigSrc string) (data, sig []byte, err error) {
+	data, err = u.readSource(ctx, src)
+	if err != nil || sigSrc == "" {
+		return data, nil, err
+	}
+	sig, err = u.readSource(ctx, sigSrc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("signature: %w", err)
+	}
+	return data, sig, nil
+}
+
+// readSource returns the contents of the file at the path or http or https
+// URL src.
+func (u *ugbt) readSource(ctx context.Context, src string) ([]byte, error) {
+	if isURL(src) {
+		return u.get(ctx, src)
+	}
+	return os.ReadFile(expandHome(src))
+}
+
+// isURL returns whether src is an http or https URL.
+func isURL(src string) bool {
+	return strings.HasPrefix(src, "https://") || strings.HasPrefix(src, "http://")
+}
+
+// writeCacheFile writes buf to the cache file at path.
+func writeCacheFile(path string, buf []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, buf, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// audit implements the audit command.
+type audit struct {
+	*ugbt
+
+	Health bool `flag:"health" help:"show the deps.dev OpenSSF Scorecard score and advisories of each executable's module."`
+	Vuln   bool `flag:"vuln" help:"check the modules each executable was built from for known vulnerabilities."`
+
+	Severity     string `flag:"severity" help:"only report vulnerabilities of at least this severity: LOW, MODERATE, HIGH or CRITICAL."`
+	FailSeverity string `flag:"fail-severity" help:"only fail for vulnerabilities of at least this severity."`
+	FailFixed    bool   `flag:"fail-fixed" help:"only fail for vulnerabilities that have a fixed version."`
+
+	Selection
+}
+
+func (*audit) Name() string  { return "audit" }
+func (*audit) Usage() string { return "[/path/to/go/executable ...]" }
+func (*audit) ShortHelp() string {
+	return text("check executables against the team policy and known vulnerabilities")
+}
+func (*audit) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The audit command checks the versions of the provided executables, or of
+the executables in the install directory and the other scanned directories
+if none are provided, against the team policy and prints the executables
+that violate it. An error is returned if any executable violates the policy.
+Executables with build information recording that they were built from a
+vcs working tree with uncommitted changes are listed as built with
+uncommitted changes, since their provenance is not fully recorded, but do
+not fail the audit.
+
+The policy is a JSON file at the path or http or https URL given by the
+"source" field of the "policy" section of the ugbt config. It holds the
+allowed version ranges, banned versions and required minimum version of
+tools, keyed by package path, module path or executable name. For example
+
+	{
+		"tools": {
+			"golang.org/x/tools/gopls": {
+				"allowed": [">=v0.14.0 <v0.16.0"],
+				"banned": ["v0.15.1"],
+				"minimum": "v0.14.2"
+			}
+		}
+	}
+
+The update command does not update executables to versions that violate
+the policy and warns when an installed version violates it.
+
+If the "signature" field of the "policy" section is set, the policy is only
+used if it has a valid detached signature. The "format" of the signature is
+"ssh", verified with ssh-keygen against the allowed signers file given by
+"key" for the signer "identity", "minisign", verified with minisign against
+the public key file given by "key", or "cosign", verified with cosign
+verify-blob against the public key given by "key". The signature is read
+from "location", or from the policy's path or URL with ".minisig" appended
+for minisign and ".sig" appended otherwise. ssh signatures must be made in
+the "ugbt" namespace, for example with
+
+	ssh-keygen -Y sign -f ~/.ssh/id_ed25519 -n ugbt policy.json
+
+If the -health flag is given, every audited executable is listed with the
+OpenSSF Scorecard score of its module's source repository and the security
+advisories affecting its version as reported by deps.dev, as a signal of
+the module's maintenance health, and a team policy is not required.
+
+If the -vuln flag is given, the main module, dependencies and standard
+library of each executable are checked for known vulnerabilities in the OSV
+database, and each vulnerability is listed with the earliest version that
+fixes it. Modules are queried with OSV batch queries, and the results are
+cached for the "cache_ttl" duration of the "vuln" section of the ugbt
+config, or for a day, so that audits of many executables make few
+requests. The -refresh flag ignores the cached results. A team policy is
+not required.
+
+Each vulnerability is reported with its severity, the highest of the
+severity ratings and CVSS v3 scores of its records. Only vulnerabilities
+of at least the -severity level are reported, and the audit fails with an
+exit status of 3 if any executable is affected by a reported vulnerability
+of at least the -fail-severity level, or if the -fail-fixed flag is given,
+by one that also has a fixed version. For example, to fail only on HIGH
+and CRITICAL vulnerabilities with a fix available
+
+	ugbt audit -vuln -fail-severity HIGH -fail-fixed
+
+Vulnerabilities of unknown severity are always reported and meet any
+severity threshold. The defaults for the flags are taken from the
+"severity", "fail_severity" and "fail_fixed" fields of the "vuln" section
+of the ugbt config.
+
+Vulnerabilities that are known not to matter can be acknowledged in the
+user's ugbt/acknowledgements.json file in the user configuration directory
+or in a project .ugbt-acknowledgements.json file in the working directory
+or its nearest parent holding one. Acknowledged vulnerabilities are not
+reported and do not fail the audit until the acknowledgement expires at
+the end of its expiry date, after which a warning is printed. Each
+acknowledgement gives the ID or an alias of the vulnerability, optionally
+the module it applies to, the expiry date and a reason. For example
+
+	{
+		"acknowledgements": [
+			{
+				"id": "GO-2024-2687",
+				"module": "golang.org/x/net",
+				"expires": "2025-06-30",
+				"reason": "the HTTP/2 server is not used"
+			}
+		]
+	}
+
+`))
+	fmt.Fprint(f.Output(), selectionHelp, "\n")
+	f.PrintDefaults()
+}
+
+// Run runs the ugbt audit command.
+func (a *audit) Run(ctx context.Context, args ...string) error {
+	pol, err := a.teamPolicy(ctx)
+	if err != nil {
+		return err
+	}
+	if pol == nil && !a.Health && !a.Vuln {
+		return errors.New("no team policy: set the source field of the policy section of the ugbt config")
+	}
+	exes := args
+	if len(exes) == 0 {
+		exes, err = a.binExecutables(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	exes, err = a.selectExecutables(ctx, exes, a.Selection)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	var (
+		violations int
+		audited    []auditedExe
+	)
+	for _, exe := range exes {
+		info, err := a.buildInfo(ctx, exe)
+		if err != nil {
+			fprintf(os.Stderr, "skipping %s: %v\n", exeBase(exe), err)
+			continue
+		}
+		pkg, mod, version, err := a.exeVersion(ctx, info, exe)
+		if err != nil {
+			fprintf(os.Stderr, "skipping %s: %v\n", exeBase(exe), err)
+			continue
+		}
+		if a.Vuln {
+			audited = append(audited, auditedExe{name: exeBase(exe), version: version, mods: vulnModules(info, mod, version)})
+		}
+		var v string
+		if pol != nil && semver.IsValid(version) {
+			tp, ok := pol.lookup(exeBase(exe), pkg, mod)
+			if ok {
+				v = tp.violation(version)
+			}
+		}
+		var notes []string
+		if v != "" {
+			violations++
+			notes = append(notes, v)
+		}
+		if vcsModified(info) {
+			notes = append(notes, "built with uncommitted changes")
+		}
+		if !a.Health {
+			if len(notes) != 0 {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", exeBase(exe), version, strings.Join(notes, "\t"))
+			}
+			continue
+		}
+		score, advisories := "-", "unknown advisories"
+		h, err := a.moduleHealth(ctx, mod, version)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "no health signal for %s: %v\n", exeBase(exe), err)
+		} else {
+			score, advisories = h.scorecard(), formatAdvisories(h.Advisories)
+		}
+		fmt.Fprintf(w, "%s\t%s\tscorecard %s\t%s", exeBase(exe), version, score, advisories)
+		for _, n := range notes {
+			fmt.Fprintf(w, "\t%s", n)
+		}
+		fmt.Fprintln(w)
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	var vulnerable int
+	if a.Vuln {
+		vulnerable, err = a.auditVulns(ctx, audited)
+		if err != nil {
+			return err
+		}
+	}
+	var failures []string
+	switch violations {
+	case 0:
+	case 1:
+		failures = append(failures, "1 executable violates the team policy")
+	default:
+		failures = append(failures, fmt.Sprintf("%d executables violate the team policy", violations))
+	}
+	switch vulnerable {
+	case 0:
+	case 1:
+		failures = append(failures, "1 executable has known vulnerabilities")
+	default:
+		failures = append(failures, fmt.Sprintf("%d executables have known vulnerabilities", vulnerable))
+	}
+	switch {
+	case vulnerable != 0:
+		return vulnError{msg: strings.Join(failures, "; ")}
+	case len(failures) != 0:
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// auditedExe is an executable checked for vulnerabilities by audit.
+type auditedExe struct {
+	name, version string
+	mods          []module.Version
+}
+
+// auditVulns prints the known vulnerabilities affecting the modules of
+// the executables at or above the -severity threshold and returns the
+// number of executables affected by vulnerabilities that fail the audit.
+// The modules of all the executables are queried together.
+func (a *audit) auditVulns(ctx context.Context, exes []auditedExe) (int, error) {
+	report, ok := parseSeverity(a.Severity)
+	if !ok && a.Severity != "" {
+		return 0, tool.CommandLineErrorf("invalid severity: %q", a.Severity)
+	}
+	fail, ok := parseSeverity(a.FailSeverity)
+	if !ok && a.FailSeverity != "" {
+		return 0, tool.CommandLineErrorf("invalid fail severity: %q", a.FailSeverity)
+	}
+
+	var mods []module.Version
+	for _, e := range exes {
+		mods = append(mods, e.mods...)
+	}
+	vulns, err := a.vulnerabilities(ctx, mods)
+	if err != nil {
+		return 0, err
+	}
+	acks, err := a.loadAcknowledgements()
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	warned := make(map[acknowledgement]bool)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	var vulnerable, suppressed int
+	for _, e := range exes {
+		var failed bool
+		for _, m := range e.mods {
+			for _, f := range findings(m.Path, m.Version, vulns[m]) {
+				ok, expired := acknowledged(acks, m.Path, f, now)
+				for _, ack := range expired {
+					if !warned[ack] {
+						fprintf(os.Stderr, "warning: acknowledgement of %s in %s expired on %s\n", ack.ID, ack.file, ack.Expires)
+						warned[ack] = true
+					}
+				}
+				if ok {
+					suppressed++
+					continue
+				}
+				// Vulnerabilities of unknown severity
+				// are always reported and fail.
+				known := f.severity != unknownSeverity
+				if known && f.severity < report {
+					continue
+				}
+				fix := "no fix"
+				if f.fix != "" {
+					fix = "fixed in " + f.fix
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s@%s\t%s\t%s\t%s\n", e.name, e.version, f.ID, m.Path, m.Version, f.severity, fix, f.Summary)
+				if (!known || f.severity >= fail) && (!a.FailFixed || f.fix != "") {
+					failed = true
+				}
+			}
+		}
+		if failed {
+			vulnerable++
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		return 0, err
+	}
+	switch suppressed {
+	case 0:
+	case 1:
+		fmt.Fprintln(os.Stderr, "1 acknowledged vulnerability not reported")
+	default:
+		fmt.Fprintf(os.Stderr, "%d acknowledged vulnerabilities not reported\n", suppressed)
+	}
+	return vulnerable, nil
+}
+
+// vulnError is returned by audit when executables are affected by
+// vulnerabilities that fail the audit.
+type vulnError struct {
+	msg string
+}
+
+func (e vulnError) Error() string { return e.msg }
+
+// ExitCode returns the exit code for failing vulnerability audits.
+func (vulnError) ExitCode() int { return exitCodes[kindVulnerable] }
@@ -0,0 +1,296 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sync"
+)
+
+// prefetch implements the prefetch command.
+type prefetch struct {
+	*ugbt
+
+	All        bool   `flag:"all,a" help:"prefetch updates for all Go executables in the install directory."`
+	PreRelease string `flag:"suffix,s" help:"only prefetch versions with a pre-release matching the regexp pattern"`
+	Verbose    bool   `flag:"v" help:"print the go command output."`
+	Commands   bool   `flag:"x" help:"print the commands run by the go tool."`
+	Selection
+}
+
+func (*prefetch) Name() string      { return "prefetch" }
+func (*prefetch) Usage() string     { return "[-all | /path/to/go/executable ...]" }
+func (*prefetch) ShortHelp() string { return text("download updates without installing them") }
+func (*prefetch) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The prefetch command resolves the version that the update command would
+install for each executable and downloads the source and metadata of the
+modules needed to build it into the module cache, without building or
+replacing anything. A later update then only needs to build the
+executables, and can be run without network access with GOPROXY=off. If the
+-all flag is given, all Go executables in the install directory are
+prefetched. If no executable is specified ugbt is prefetched.
+
+`+selectionHelp+`
+`))
+	f.PrintDefaults()
+}
+
+// Run runs the ugbt prefetch command.
+func (p *prefetch) Run(ctx context.Context, args ...string) error {
+	exes := args
+	if p.All {
+		if len(args) != 0 {
+			return errors.New("prefetch -all does not accept executable arguments")
+		}
+		var err error
+		exes, err = p.binExecutables(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	if len(exes) == 0 && !p.All {
+		// Work on ugbt.
+		exes = []string{""}
+	}
+	exes, err := p.selectExecutables(ctx, exes, p.Selection)
+	if err != nil {
+		return err
+	}
+
+	suffix, err := regexp.Compile(p.PreRelease)
+	if err != nil {
+		return err
+	}
+
+	u := &update{ugbt: p.ugbt, PreRelease: p.PreRelease}
+	var targets []target
+	for _, exe := range exes {
+		name := exe
+		if name == "" {
+			name = "ugbt"
+		}
+		t, ok, err := u.target(ctx, exe, suffix, os.Stderr)
+		if err != nil {
+			if p.All {
+				fprintf(os.Stderr, "skipping %s: %v\n", name, err)
+				continue
+			}
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if t.mod == "std" {
+			fprintf(os.Stderr, "skipping %s: Go toolchains are not prefetched\n", name)
+			continue
+		}
+		fprintf(os.Stderr, "prefetch %s %s@%s\n", name, t.mod, t.version)
+		targets = append(targets, t)
+	}
+	if len(targets) == 0 {
+		fmt.Fprintln(os.Stderr, text("no new version"))
+		return nil
+	}
+	return p.download(ctx, targets, BuildFlags{Verbose: p.Verbose, Commands: p.Commands})
+}
+
+// binExecutables returns the paths of the Go executables in the scan
+// directories, with the executables of each directory sorted lexically.
+// Executables in directories excluded by the scan section of the ugbt
+// config are omitted. If the scan section enables toolchain scanning, the
+// executables of the active Go toolchain follow those of the scan
+// directories.
+func (u *ugbt) binExecutables(ctx context.Context) ([]string, error) {
+	dirs, err := u.scanDirs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if u.config.Scan.Toolchain {
+		// The toolchain directories are not scan directories
+		// since their executables are updated by installing
+		// a new toolchain, not in place.
+		for _, v := range []string{"GOROOT", "GOTOOLDIR"} {
+			dir, err := u.goenv(ctx, v)
+			if err != nil {
+				return nil, err
+			}
+			if v == "GOROOT" && dir != "" {
+				dir = filepath.Join(dir, "bin")
+			}
+			if dir != "" {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	var candidates []string
+	for _, dir := range dirs {
+		found, err := u.scanCandidates(dir)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, found...)
+	}
+	return u.goExecutables(candidates), nil
+}
+
+// scanCandidates returns the paths of the regular files in dir sorted
+// lexically. Files excluded by the scan section of the ugbt config are
+// omitted. A missing directory holds no files.
+func (u *ugbt) scanCandidates(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var found []string
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if u.config.Scan.excluded(path) {
+			u.debugf("excluding %s", path)
+			continue
+		}
+		found = append(found, path)
+	}
+	return found, nil
+}
+
+// scanWorkers is the number of files read concurrently by goExecutables.
+// Reading build information is dominated by file system latency rather
+// than CPU, particularly on network file systems and spinning disks, so
+// more files are read than there are CPUs.
+var scanWorkers = 4 * runtime.NumCPU()
+
+// goExecutables returns the paths that are Go executables, in the order
+// they are given. The build information of the files is read concurrently.
+func (u *ugbt) goExecutables(paths []string) []string {
+	var (
+		wg    sync.WaitGroup
+		work  = make(chan int)
+		isExe = make([]bool, len(paths))
+	)
+	workers := scanWorkers
+	if workers > len(paths) {
+		workers = len(paths)
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				// Files without build information are
+				// not Go executables.
+				_, err := u.exeBuildInfo(paths[i])
+				isExe[i] = err == nil
+			}
+		}()
+	}
+	for i := range paths {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	var exes []string
+	for i, path := range paths {
+		if isExe[i] {
+			exes = append(exes, path)
+		}
+	}
+	return exes
+}
+
+// scanDirs returns the directories scanned for Go executables by bulk
+// commands. The first is the directory that go install writes executables
+// to, followed by the bin directories of any other GOPATH elements and the
+// directories in the scan section of the ugbt config.
+func (u *ugbt) scanDirs(ctx context.Context) ([]string, error) {
+	dir, err := u.binDir(ctx)
+	if err != nil {
+		return nil, err
+	}
+	candidates := []string{dir}
+	gopath, err := u.goenv(ctx, "GOPATH")
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range filepath.SplitList(gopath) {
+		if p != "" {
+			candidates = append(candidates, filepath.Join(p, "bin"))
+		}
+	}
+	for _, d := range u.config.Scan.Dirs {
+		if d = expandHome(d); d != "" {
+			candidates = append(candidates, d)
+		}
+	}
+	var dirs []string
+outer:
+	for _, c := range candidates {
+		for _, d := range dirs {
+			if sameFile(c, d) {
+				continue outer
+			}
+		}
+		dirs = append(dirs, c)
+	}
+	return dirs, nil
+}
+
+// installDir returns the directory that an update of the executable at
+// exepath should be installed to, if it is not the directory that go
+// install writes executables to. Executables found in other scan
+// directories are updated in place unless the -bin flag is given.
+func (u *ugbt) installDir(ctx context.Context, exepath string) (string, bool) {
+	if exepath == "" || u.Bin != "" {
+		return "", false
+	}
+	dirs, err := u.scanDirs(ctx)
+	if err != nil {
+		return "", false
+	}
+	dir := filepath.Dir(exepath)
+	for _, d := range dirs[1:] {
+		if sameFile(dir, d) && !sameFile(dir, dirs[0]) {
+			return d, true
+		}
+	}
+	return "", false
+}
+
+// withGOBIN returns a copy of u that installs executables to dir. The
+// copy shares the query limit and caches of u.
+func (u *ugbt) withGOBIN(dir string) *ugbt {
+	v := newUggboot(u.name, u.wd, append(u.env[:len(u.env):len(u.env)], "GOBIN="+dir))
+	v.Timeout = u.Timeout
+	v.Config = u.Config
+	v.GOPROXY = u.GOPROXY
+	v.MaxAge = u.MaxAge
+	v.Refresh = u.Refresh
+	v.Bin = u.Bin
+	v.Debug = u.Debug
+	v.Concurrency = u.Concurrency
+	v.JSONErrors = u.JSONErrors
+	v.Profile = u.Profile
+	v.client = u.client
+	v.config = u.config
+	v.queried = u.queried
+	v.buildInfos = u.buildInfos
+	v.queries = u.queries
+	return v
+}
@@ -0,0 +1,94 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// probeTimeout is the time an installed executable has to complete its
+// health probe.
+const probeTimeout = 10 * time.Second
+
+// defaultProbe is the arguments used to probe an installed executable
+// when none are configured. Executables using the flag package and most
+// command line frameworks print their usage and exit successfully.
+var defaultProbe = []string{"-h"}
+
+// probe runs the health probe of the executable installed for the package
+// path, returning an error if it fails to start, exits with a non-zero
+// status, crashes or does not exit within probeTimeout. The probe arguments
+// are taken from the executable's entry in the tools section of the ugbt
+// config if present.
+func (u *ugbt) probe(ctx context.Context, pkg string) error {
+	dst, err := u.installPath(ctx, pkg)
+	if err != nil {
+		return err
+	}
+	args := defaultProbe
+	if tool, ok := u.config.Tools[exeBase(dst)]; ok && len(tool.Probe) != 0 {
+		args = tool.Probe
+	}
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, dst, args...)
+	cmd.Dir = os.TempDir()
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err = cmd.Run()
+	probe := strings.Join(append([]string{exeBase(dst)}, args...), " ")
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%s did not exit within %v", probe, probeTimeout)
+	}
+	if err != nil {
+		// Only report the end of the output, which holds
+		// the panic or error message.
+		tail := bytes.TrimSpace(out.Bytes())
+		if lines := bytes.Split(tail, []byte("\n")); len(lines) > 5 {
+			tail = bytes.Join(lines[len(lines)-5:], []byte("\n"))
+		}
+		if len(tail) == 0 {
+			return fmt.Errorf("%s: %w", probe, err)
+		}
+		return fmt.Errorf("%s: %w\n%s", probe, err, tail)
+	}
+	return nil
+}
+
+// rollback restores the executable installed for the package path from
+// the backup saved before the install after it has failed its health
+// probe with probeErr, and reverts the install in the ugbt state.
+func (u *ugbt) rollback(ctx context.Context, pkg string, saved *backup, probeErr error) error {
+	if saved == nil {
+		return fmt.Errorf("installed executable failed health probe and was not backed up: %w", probeErr)
+	}
+	dst, err := u.installPath(ctx, pkg)
+	if err != nil {
+		return err
+	}
+	err = replaceFile(dst, saved.exe())
+	if err != nil {
+		return fmt.Errorf("installed executable failed health probe: %w: restore backup: %v", probeErr, err)
+	}
+	s, err := loadState()
+	if err != nil {
+		return err
+	}
+	s.revert()
+	err = s.save()
+	if err != nil {
+		return err
+	}
+	os.RemoveAll(saved.dir)
+	return fmt.Errorf("installed executable failed health probe, restored %s: %w", saved.Version, probeErr)
+}
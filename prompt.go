@@ -0,0 +1,171 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// prompt implements the prompt command.
+type prompt struct {
+	*ugbt
+
+	Format   string        `flag:"format" help:"format of the summary; %d is replaced by the number of executables with updates."`
+	Interval time.Duration `flag:"interval" help:"age of the cached summary after which it is refreshed in the background."`
+	Sync     bool          `flag:"sync" help:"refresh the cached summary before printing it."`
+}
+
+func (*prompt) Name() string      { return "prompt" }
+func (*prompt) Usage() string     { return "" }
+func (*prompt) ShortHelp() string { return text("print a short update summary for shell prompts") }
+func (*prompt) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The prompt command prints a short summary of the number of Go executables
+in the install directory that have updates, for use in shell prompts and
+status lines. Nothing is printed if there are no updates. The summary is
+printed from a cache so that the command returns quickly, and if the cache
+is older than the -interval duration, it is refreshed by a background ugbt
+process for use by later prompts. If the -sync flag is given, the cache is
+refreshed before the summary is printed.
+
+`))
+	f.PrintDefaults()
+}
+
+// promptCache is the cached update summary.
+type promptCache struct {
+	Checked  time.Time `json:"checked"`
+	Total    int       `json:"total"`
+	Outdated []string  `json:"outdated"`
+}
+
+// promptCachePath returns the path of the cached update summary.
+func promptCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ugbt", "prompt.json"), nil
+}
+
+// Run runs the ugbt prompt command.
+func (p *prompt) Run(ctx context.Context, args ...string) error {
+	if len(args) != 0 {
+		return errors.New("prompt does not accept arguments")
+	}
+	path, err := promptCachePath()
+	if err != nil {
+		return err
+	}
+	if p.Sync {
+		// Release the lock held for a background refresh.
+		defer os.Remove(path + ".lock")
+		err = p.refresh(ctx, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	var cached promptCache
+	b, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(b, &cached)
+	}
+	if err != nil || time.Since(cached.Checked) > p.Interval {
+		// Don't wait for the refresh; the next prompt
+		// will show its result.
+		p.refreshBackground(path)
+	}
+	if len(cached.Outdated) != 0 && p.Format != "" {
+		fmt.Println(strings.ReplaceAll(p.Format, "%d", fmt.Sprint(len(cached.Outdated))))
+	}
+	return nil
+}
+
+// refresh updates the cached summary at path.
+func (p *prompt) refresh(ctx context.Context, path string) error {
+	exes, err := p.binExecutables(ctx)
+	if err != nil {
+		return err
+	}
+	u := &update{ugbt: p.ugbt, PreRelease: "^$"}
+	def := regexp.MustCompile(u.PreRelease)
+	c := promptCache{Total: len(exes)}
+	for _, exe := range exes {
+		suffix := def
+		if tool, ok := u.tool(exe); ok && tool.Suffix != "" {
+			suffix, err = regexp.Compile(tool.Suffix)
+			if err != nil {
+				continue
+			}
+		}
+		_, ok, err := u.target(ctx, exe, suffix, io.Discard)
+		if err != nil || !ok {
+			continue
+		}
+		c.Outdated = append(c.Outdated, exeBase(exe))
+	}
+	c.Checked = time.Now()
+	buf, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return writeCacheFile(path, buf)
+}
+
+// refreshBackground starts a ugbt process to refresh the cached summary
+// at path unless a refresh is already in progress.
+func (p *prompt) refreshBackground(path string) {
+	lock := path + ".lock"
+	if fi, err := os.Stat(lock); err == nil && time.Since(fi.ModTime()) < p.Timeout+time.Minute {
+		// A refresh is in progress. Stale locks from
+		// refreshes that did not finish are ignored.
+		return
+	}
+	err := os.MkdirAll(filepath.Dir(lock), 0o755)
+	if err != nil {
+		return
+	}
+	err = os.WriteFile(lock, nil, 0o644)
+	if err != nil {
+		return
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		os.Remove(lock)
+		return
+	}
+	var args []string
+	if p.Config != "" {
+		args = append(args, "-config", p.Config)
+	}
+	if p.GOPROXY != "" {
+		args = append(args, "-goproxy", p.GOPROXY)
+	}
+	args = append(args, "-timeout", p.Timeout.String(), "prompt", "-sync", "-format", "")
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = p.wd
+	if len(p.env) != 0 {
+		cmd.Env = append(os.Environ(), p.env...)
+	}
+	err = cmd.Start()
+	if err != nil {
+		os.Remove(lock)
+		return
+	}
+	// The lock is removed by the refreshing process.
+	cmd.Process.Release()
+}
@@ -0,0 +1,101 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"golang.org/x/mod/semver"
+)
+
+// rebuild implements the rebuild command.
+type rebuild struct {
+	*ugbt
+
+	BuildFlags
+}
+
+func (*rebuild) Name() string      { return "rebuild" }
+func (*rebuild) Usage() string     { return "[/path/to/go/executable]" }
+func (*rebuild) ShortHelp() string { return text("reinstall an executable at its installed version") }
+func (*rebuild) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The rebuild command reinstalls the executable at the provided path at the
+module version recorded in its build information, for example to build it
+with a newer Go toolchain or to replace a damaged executable. If an
+executable path is not provided, ugbt is rebuilt. Executables in the other
+scan directories are rebuilt in place unless the ugbt -bin flag is given.
+
+Executables that were installed from a local working copy with install
+-from are rebuilt from the same working copy, and executables installed
+with dependency overrides are rebuilt with the same overrides. Since the
+version is already installed, its retraction or the deprecation of its
+module does not prevent the rebuild. Executables built from development
+versions without a module version can be rebuilt with install -same.
+
+The build flags are as for the install command.
+
+`))
+	f.PrintDefaults()
+}
+
+// Run runs the ugbt rebuild command.
+func (r *rebuild) Run(ctx context.Context, args ...string) error {
+	var exe string
+	switch len(args) {
+	case 0:
+		// Work on ugbt.
+	case 1:
+		exe = args[0]
+	default:
+		return errors.New("rebuild requires zero or one argument")
+	}
+
+	path, mod, version, err := r.version(ctx, exe)
+	if err != nil {
+		return err
+	}
+	if mod == "std" {
+		return errors.New("rebuild is not supported for the standard library")
+	}
+	return r.reinstall(ctx, exe, path, mod, version, r.BuildFlags)
+}
+
+// reinstall reinstalls the executable at exepath, built from the package
+// path in the module mod, at its installed version. Executables recorded in
+// the ugbt state as installed from a local working copy or with dependency
+// overrides are reinstalled in the same way.
+func (u *ugbt) reinstall(ctx context.Context, exepath, path, mod, version string, flags BuildFlags) error {
+	inst := u
+	if dir, ok := u.installDir(ctx, exepath); ok {
+		// Rebuild the executable in place.
+		inst = u.withGOBIN(dir)
+	}
+	name := exeBase(exepath)
+	if exepath == "" {
+		name = "ugbt"
+	}
+	flags = rebuildFlags(flags)
+	if dir, ok := inst.localSource(ctx, path); ok {
+		fprintf(os.Stderr, "rebuild %s from %s\n", name, dir)
+		return inst.installLocal(ctx, path, dir, flags)
+	}
+	if !semver.IsValid(version) || semver.Build(version) != "" {
+		// Versions stamped from a working tree with
+		// uncommitted changes can not be fetched.
+		return fmt.Errorf("%s has no module version to rebuild: use install -same to rebuild it from its vcs revision", name)
+	}
+	if o, ok := inst.installOverrides(ctx, path); ok {
+		fprintf(os.Stderr, "rebuild %s at %s with %s\n", name, version, o)
+		flags.overrides = o
+	} else {
+		fprintf(os.Stderr, "rebuild %s at %s\n", name, version)
+	}
+	return inst.install(ctx, path, mod, version, flags)
+}
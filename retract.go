@@ -0,0 +1,90 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/mod/semver"
+)
+
+// retractions implements the retractions command.
+type retractions struct {
+	*ugbt
+}
+
+func (*retractions) Name() string      { return "retractions" }
+func (*retractions) Usage() string     { return "[/path/to/go/executable|module]" }
+func (*retractions) ShortHelp() string { return text("print the retractions declared by a module") }
+func (*retractions) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The retractions command prints all the version retractions declared by the
+go.mod files of the module of the executable, or of the named module. Each
+retraction is printed with the version whose go.mod declared it, the
+available versions that it covers and its rationale. If no argument is
+provided, the retractions of ugbt are printed.
+
+`))
+	f.PrintDefaults()
+}
+
+// Run runs the ugbt retractions command.
+func (r *retractions) Run(ctx context.Context, args ...string) error {
+	var arg string
+	switch len(args) {
+	case 0:
+		// Work on ugbt.
+	case 1:
+		arg = args[0]
+	default:
+		return errors.New("retractions requires zero or one argument")
+	}
+
+	mod, err := r.targetModule(ctx, arg)
+	if err != nil {
+		return err
+	}
+	if mod == "std" {
+		return errors.New("the standard library does not declare retractions")
+	}
+	versions, retracted, err := r.moduleVersions(ctx, mod, "", true, versionFilter{})
+	if err != nil {
+		return err
+	}
+	if len(retracted) == 0 {
+		fmt.Fprintf(os.Stderr, "no retractions declared by %s\n", mod)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, rv := range retracted {
+		interval := rv.Low
+		if rv.High != rv.Low {
+			interval = "[" + rv.Low + ", " + rv.High + "]"
+		}
+		var covered []string
+		for _, v := range versions {
+			if semver.Compare(v.Version, rv.Low) >= 0 && semver.Compare(v.Version, rv.High) <= 0 {
+				covered = append(covered, v.Version)
+			}
+		}
+		covers := "covers no available version"
+		if len(covered) != 0 {
+			covers = "covers " + strings.Join(covered, " ")
+		}
+		fmt.Fprintf(w, "%s\tdeclared in %s\t%s", interval, rv.GoMod, covers)
+		if rv.Rationale != "" {
+			fmt.Fprintf(w, "\t%s", rv.Rationale)
+		}
+		fmt.Fprintln(w)
+	}
+	return w.Flush()
+}
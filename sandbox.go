@@ -0,0 +1,221 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/execabs"
+
+	"github.com/kortschak/ugbt/internal/tool"
+)
+
+// Sandbox modes.
+const (
+	// sandboxEnv runs the go command with its home, GOPATH, module
+	// cache and build cache in a temporary directory.
+	sandboxEnv = "env"
+	// sandboxBwrap runs the go command as for sandboxEnv inside a
+	// bubblewrap container where the user's home directory is not
+	// visible and the rest of the file system is read-only.
+	sandboxBwrap = "bwrap"
+)
+
+// sandboxEnvVars are the go env variables that are passed into a sandbox
+// since the user's go env configuration file is not visible within it.
+var sandboxEnvVars = []string{
+	"GOPROXY",
+	"GOSUMDB",
+	"GOPRIVATE",
+	"GONOPROXY",
+	"GONOSUMDB",
+	"GOINSECURE",
+	"GOFLAGS",
+	"GOTOOLCHAIN",
+}
+
+// sandbox configures cmd, a go command, to run in a sandbox of the kind
+// named by mode. Executables are installed to gobin, and src, if not empty,
+// is a local source directory that is made available to the command. The
+// GOROOT and the directory of the go command are made available to the
+// command since they may be in the user's home directory. The returned
+// function removes the sandbox and must be called after the command has
+// finished.
+func (u *ugbt) sandbox(ctx context.Context, cmd *execabs.Cmd, mode, gobin, src string) (cleanup func(), err error) {
+	var bwrap string
+	switch mode {
+	case sandboxEnv:
+	case sandboxBwrap:
+		bwrap, err = exec.LookPath("bwrap")
+		if err != nil {
+			return nil, fmt.Errorf("bwrap sandbox requires bubblewrap: %w", err)
+		}
+	default:
+		return nil, tool.CommandLineErrorf("unknown sandbox %q: must be %s or %s", mode, sandboxEnv, sandboxBwrap)
+	}
+
+	env := make([]string, 0, len(sandboxEnvVars)+10)
+	for _, name := range sandboxEnvVars {
+		if name == "GOPROXY" && u.GOPROXY != "" {
+			// The -goproxy flag is already in the
+			// command's environment.
+			continue
+		}
+		v, err := u.goenv(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if v != "" {
+			env = append(env, name+"="+v)
+		}
+	}
+
+	goroot, err := commandGOROOT(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	env = append(env, "GOROOT="+goroot)
+
+	dir, err := os.MkdirTemp("", "ugbt-sandbox-*")
+	if err != nil {
+		return nil, err
+	}
+	cleanup = func() { removeSandbox(dir) }
+	home := filepath.Join(dir, "home")
+	for _, d := range []string{home, filepath.Join(dir, "config"), filepath.Join(dir, "cache")} {
+		err = os.Mkdir(d, 0o755)
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+	}
+	env = append(env,
+		"HOME="+home,
+		"USERPROFILE="+home,
+		"XDG_CONFIG_HOME="+filepath.Join(dir, "config"),
+		"XDG_CACHE_HOME="+filepath.Join(dir, "cache"),
+		"GOENV=off",
+		"GOPATH="+filepath.Join(dir, "gopath"),
+		"GOMODCACHE="+filepath.Join(dir, "gopath", "pkg", "mod"),
+		"GOCACHE="+filepath.Join(dir, "cache", "go-build"),
+		"GOBIN="+gobin,
+	)
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, env...)
+	if src == "" {
+		cmd.Dir = dir
+	}
+
+	if mode == sandboxBwrap {
+		realHome, err := os.UserHomeDir()
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		err = os.MkdirAll(gobin, 0o755)
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		args := []string{
+			bwrap,
+			"--ro-bind", "/", "/",
+			"--dev", "/dev",
+			"--proc", "/proc",
+			"--tmpfs", realHome,
+			"--bind", dir, dir,
+			"--bind", gobin, gobin,
+		}
+		// Toolchains installed by the user are commonly in
+		// the home directory, so make them visible.
+		for _, d := range toolchainDirs(cmd.Path, goroot) {
+			args = append(args, "--ro-bind", d, d)
+		}
+		if src != "" {
+			args = append(args, "--ro-bind", src, src)
+		}
+		args = append(args,
+			"--unshare-all",
+			"--share-net",
+			"--die-with-parent",
+			"--chdir", cmd.Dir,
+			"--",
+			cmd.Path,
+		)
+		cmd.Path = bwrap
+		cmd.Args = append(args, cmd.Args[1:]...)
+	}
+	return cleanup, nil
+}
+
+// commandGOROOT returns the GOROOT of the go command run by cmd. The GOROOT
+// is that of the command itself rather than of a toolchain that it would
+// switch to, which is selected again within the sandbox.
+func commandGOROOT(ctx context.Context, cmd *execabs.Cmd) (string, error) {
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	for i := len(env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(env[i], "GOROOT=") && env[i] != "GOROOT=" {
+			return strings.TrimPrefix(env[i], "GOROOT="), nil
+		}
+	}
+	var stdout, stderr strings.Builder
+	c := execabs.CommandContext(ctx, cmd.Path, "env", "GOROOT")
+	c.Env = append(env[:len(env):len(env)], "GOTOOLCHAIN=local")
+	c.Dir = cmd.Dir
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	err := c.Run()
+	if err != nil {
+		return "", fmt.Errorf("go env GOROOT: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+	goroot := strings.TrimSpace(stdout.String())
+	if goroot == "" {
+		return "", errors.New("go env GOROOT: no GOROOT")
+	}
+	return goroot, nil
+}
+
+// toolchainDirs returns the GOROOT and the directories holding the go
+// command at path, following symbolic links, without repeats.
+func toolchainDirs(path, goroot string) []string {
+	dirs := []string{goroot}
+	add := func(d string) {
+		for _, e := range dirs {
+			if e == d {
+				return
+			}
+		}
+		dirs = append(dirs, d)
+	}
+	add(filepath.Dir(path))
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		add(filepath.Dir(resolved))
+	}
+	return dirs
+}
+
+// removeSandbox removes the sandbox directory. The module cache is made
+// read-only by the go command, so permissions are restored before removal.
+func removeSandbox(dir string) {
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			os.Chmod(path, 0o755)
+		}
+		return nil
+	})
+	os.RemoveAll(dir)
+}
@@ -0,0 +1,599 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/kortschak/ugbt/internal/tool"
+)
+
+// sdk implements the sdk command.
+type sdk struct {
+	*ugbt
+}
+
+func (*sdk) Name() string      { return "sdk" }
+func (*sdk) Usage() string     { return "<install> [sub-command-flags] [sub-command-args]" }
+func (*sdk) ShortHelp() string { return text("manage Go SDK archives") }
+func (s *sdk) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The sdk command manages Go SDKs using the official release archives
+published at https://go.dev/dl, without using the golang.org/dl wrappers.
+
+Available sub commands are:
+`))
+	for _, c := range s.commands() {
+		fmt.Fprintf(f.Output(), "  %s: %v\n", c.Name(), c.ShortHelp())
+	}
+	fmt.Fprintln(f.Output())
+	f.PrintDefaults()
+}
+
+// Run runs the ugbt sdk command.
+func (s *sdk) Run(ctx context.Context, args ...string) error {
+	if len(args) == 0 {
+		return tool.CommandLineErrorf("sdk requires a sub command")
+	}
+	command, args := args[0], args[1:]
+	for _, c := range s.commands() {
+		if c.Name() == command {
+			return tool.Run(ctx, c, args)
+		}
+	}
+	return tool.CommandLineErrorf("Unknown sdk command %v", command)
+}
+
+// commands returns the set of sub commands supported by the sdk command.
+func (s *sdk) commands() []tool.Application {
+	return []tool.Application{
+		&sdkInstall{ugbt: s.ugbt, GOOS: runtime.GOOS, GOARCH: runtime.GOARCH, Dir: "."},
+	}
+}
+
+// sdkInstall implements the sdk install command.
+type sdkInstall struct {
+	*ugbt
+
+	GOOS   string `flag:"goos" help:"operating system of the SDK."`
+	GOARCH string `flag:"goarch" help:"architecture of the SDK."`
+	Dir    string `flag:"dir" help:"directory to unpack the SDK into."`
+	GOROOT string `flag:"goroot" help:"directory to use as the GOROOT of the SDK instead of the go directory in -dir."`
+	Link   string `flag:"link" help:"directory to place go and gofmt symbolic links to the SDK's commands in."`
+}
+
+func (*sdkInstall) Name() string      { return "install" }
+func (*sdkInstall) Usage() string     { return "<version>" }
+func (*sdkInstall) ShortHelp() string { return text("download and unpack a Go SDK archive") }
+func (*sdkInstall) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The install sub command downloads the release archive for the requested
+Go version and platform, verifies its SHA-256 checksum against the value
+published at https://go.dev/dl, and unpacks it into the go directory
+within the -dir directory, or into the directory given by -goroot. The
+"latest" version refers to the latest release.
+
+If the -link flag is given, symbolic links to the go and gofmt commands
+of the SDK are created in the provided directory, replacing any existing
+links. This allows a system-wide toolchain to be installed without the
+golang.org/dl wrapper indirection.
+
+`))
+	f.PrintDefaults()
+}
+
+// Run runs the ugbt sdk install command.
+func (i *sdkInstall) Run(ctx context.Context, args ...string) error {
+	if len(args) != 1 {
+		return errors.New("sdk install requires one argument")
+	}
+	file, err := i.sdkArchive(ctx, args[0], i.GOOS, i.GOARCH)
+	if err != nil {
+		return err
+	}
+	goroot := i.GOROOT
+	if goroot == "" {
+		goroot = filepath.Join(i.Dir, "go")
+	}
+	_, err = os.Stat(goroot)
+	if err == nil {
+		return fmt.Errorf("%s already exists", goroot)
+	}
+	fmt.Fprintf(os.Stderr, "download %s\n", file.Filename)
+	archive, err := i.fetchArchive(ctx, file, "")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive)
+	fmt.Fprintf(os.Stderr, "verified %s sha256:%s\n", file.Filename, file.SHA256)
+	err = unpackSDK(goroot, archive, file.Filename)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%s %s/%s available in %s\n", file.Version, file.OS, file.Arch, goroot)
+	if i.Link == "" {
+		return nil
+	}
+	if file.OS != runtime.GOOS || file.Arch != runtime.GOARCH {
+		fprintf(os.Stderr, "warning: linking commands for %s/%s on %s/%s\n", file.OS, file.Arch, runtime.GOOS, runtime.GOARCH)
+	}
+	return linkSDK(i.Link, goroot, file.OS)
+}
+
+// unpackSDK unpacks the SDK release archive at path so that goroot is
+// the root of the SDK. The archive is unpacked into a temporary directory
+// beside goroot and then moved into place so that an incomplete SDK is
+// never left at goroot.
+func unpackSDK(goroot, path, name string) error {
+	parent := filepath.Dir(goroot)
+	err := os.MkdirAll(parent, 0o755)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(parent, ".ugbt-sdk-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	err = unpackArchive(tmp, path, name)
+	if err != nil {
+		return err
+	}
+	// Go release archives hold the SDK in a go directory.
+	return os.Rename(filepath.Join(tmp, "go"), goroot)
+}
+
+// linkSDK creates symbolic links in dir to the go and gofmt commands of
+// the SDK at goroot built for goos.
+func linkSDK(dir, goroot, goos string) error {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+	goroot, err = filepath.Abs(goroot)
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{"go", "gofmt"} {
+		if goos == "windows" {
+			name += ".exe"
+		}
+		link := filepath.Join(dir, name)
+		fi, err := os.Lstat(link)
+		switch {
+		case err == nil && fi.Mode()&fs.ModeSymlink == 0:
+			return fmt.Errorf("%s exists and is not a symbolic link", link)
+		case err == nil:
+			err = os.Remove(link)
+			if err != nil {
+				return err
+			}
+		case !errors.Is(err, fs.ErrNotExist):
+			return err
+		}
+		err = os.Symlink(filepath.Join(goroot, "bin", name), link)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "linked %s\n", link)
+	}
+	return nil
+}
+
+// installedSDKs returns the set of Go releases that are present locally,
+// either as the go command's toolchain, as golang.org/dl wrappers in the
+// go install directory, or as SDKs unpacked into $HOME/sdk.
+func (u *ugbt) installedSDKs(ctx context.Context) (map[string]bool, error) {
+	installed := make(map[string]bool)
+
+	current, err := u.goenv(ctx, "GOVERSION")
+	if err != nil {
+		return nil, err
+	}
+	if current != "" {
+		installed[current] = true
+	}
+
+	bin, err := u.binDir(ctx)
+	if err != nil {
+		return nil, err
+	}
+	wrappers, err := os.ReadDir(bin)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range wrappers {
+		name := e.Name()
+		if runtime.GOOS == "windows" {
+			name = strings.TrimSuffix(name, ".exe")
+		}
+		if isGoRelease(name) {
+			installed[name] = true
+		}
+	}
+
+	root, err := sdkRoot()
+	if err != nil {
+		return installed, nil
+	}
+	sdks, err := os.ReadDir(root)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, e := range sdks {
+		if !e.IsDir() || !isGoRelease(e.Name()) {
+			continue
+		}
+		// The golang.org/dl tools mark a completed download
+		// with a .unpacked-success file.
+		_, err := os.Stat(filepath.Join(root, e.Name(), ".unpacked-success"))
+		if err == nil {
+			installed[e.Name()] = true
+		}
+	}
+	return installed, nil
+}
+
+// sdkRoot returns the directory that the golang.org/dl tools unpack SDKs
+// into.
+func sdkRoot() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "sdk"), nil
+}
+
+// isGoRelease reports whether name is the name of a Go release or the
+// development tip, as used for golang.org/dl wrapper names.
+func isGoRelease(name string) bool {
+	return name == "gotip" || goRelease.MatchString(name)
+}
+
+// goRelease matches Go release names.
+var goRelease = regexp.MustCompile(`^go1(\.[0-9]+){0,2}((beta|rc)[0-9]+)?$`)
+
+// releaseHistory is the Go release history page.
+const releaseHistory = "https://go.dev/doc/devel/release"
+
+// releaseNote matches a release note paragraph in the Go release history.
+var releaseNote = regexp.MustCompile(`(?s)<p id="(go[0-9a-z.]+)"[^>]*>(.*?)</p>`)
+
+// markSecurityReleases marks versions that are described as including
+// security fixes by the Go release history.
+func (u *ugbt) markSecurityReleases(ctx context.Context, versions []info) error {
+	buf, err := u.get(ctx, releaseHistory)
+	if err != nil {
+		return fmt.Errorf("query release history: %w", err)
+	}
+	security := make(map[string]bool)
+	for _, m := range releaseNote.FindAllSubmatch(buf, -1) {
+		if bytes.Contains(m[2], []byte("security fix")) {
+			security[string(m[1])] = true
+		}
+	}
+	for i, v := range versions {
+		versions[i].isSecurity = security[v.Version]
+	}
+	return nil
+}
+
+// warnSecurity writes a warning to w listing the security releases in
+// versions that are newer than the current Go release.
+func warnSecurity(w io.Writer, current string, versions []info) {
+	var missing []string
+	for _, v := range versions {
+		if semverCompare(v.Version, current) <= 0 {
+			break
+		}
+		if v.isSecurity {
+			missing = append(missing, v.Version)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	fprintf(w, "warning: %s is missing the security fixes in %s\n", current, strings.Join(missing, ", "))
+}
+
+// dlRelease returns the Go release installed by a golang.org/dl wrapper
+// with the provided package and module paths. If the paths are not for
+// a wrapper, ok is false.
+func dlRelease(pkg, mod string) (release string, ok bool) {
+	if mod != "golang.org/dl" {
+		return "", false
+	}
+	release = strings.TrimPrefix(pkg, "golang.org/dl/")
+	return release, isGoRelease(release)
+}
+
+// goMinorRelease matches the minor release series of a Go release.
+var goMinorRelease = regexp.MustCompile(`^go1(\.[0-9]+)?`)
+
+// goMinor returns the minor release series of the Go release v, for
+// example go1.22 for go1.22.1 and go1.22rc1.
+func goMinor(v string) string {
+	return goMinorRelease.FindString(v)
+}
+
+// removeSDK removes the golang.org/dl wrapper and the unpacked SDK for
+// the Go release.
+func (u *ugbt) removeSDK(ctx context.Context, release string) error {
+	if !isGoRelease(release) {
+		return fmt.Errorf("invalid Go release: %q", release)
+	}
+	wrapper, err := u.installPath(ctx, "golang.org/dl/"+release)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(wrapper)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	root, err := sdkRoot()
+	if err != nil {
+		return err
+	}
+	err = os.RemoveAll(filepath.Join(root, release))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "removed %s\n", release)
+	return nil
+}
+
+// sdkFile is a file published for a Go release at https://go.dev/dl.
+type sdkFile struct {
+	Filename string `json:"filename"`
+	OS       string `json:"os"`
+	Arch     string `json:"arch"`
+	Version  string `json:"version"`
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+	Kind     string `json:"kind"`
+}
+
+// sdkDownloadURL is the location of the Go release archives.
+const sdkDownloadURL = "https://dl.google.com/go/"
+
+// sdkArchive returns the release archive for the Go version, operating
+// system and architecture. The "latest" version is the latest release.
+func (u *ugbt) sdkArchive(ctx context.Context, version, goos, goarch string) (sdkFile, error) {
+	versions, err := u.releases(ctx)
+	if err != nil {
+		return sdkFile{}, err
+	}
+	if len(versions) == 0 {
+		return sdkFile{}, errors.New("no Go releases found")
+	}
+	if version == "latest" {
+		for _, v := range versions {
+			if v.Stable {
+				version = v.Version
+				break
+			}
+		}
+	}
+	for _, v := range versions {
+		if v.Version != version {
+			continue
+		}
+		for _, f := range v.Files {
+			if f.Kind == "archive" && f.OS == goos && f.Arch == goarch {
+				return f, nil
+			}
+		}
+		return sdkFile{}, fmt.Errorf("no %s archive for %s/%s", version, goos, goarch)
+	}
+	return sdkFile{}, withKind(kindNotFound, fmt.Errorf("unknown Go release %s", version))
+}
+
+// fetchArchive downloads the release archive to a temporary file in dir,
+// verifying its checksum, and returns the path to the file. If dir is
+// empty, the default directory for temporary files is used. The caller
+// is responsible for removing the file.
+func (u *ugbt) fetchArchive(ctx context.Context, file sdkFile, dir string) (path string, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", sdkDownloadURL+file.Filename, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := u.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", statusError{status: resp.Status, code: resp.StatusCode}
+	}
+
+	f, err := os.CreateTemp(dir, "ugbt-*-"+file.Filename)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), resp.Body)
+	if err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if sum != file.SHA256 {
+		return "", fmt.Errorf("checksum mismatch for %s: got sha256:%s want sha256:%s", file.Filename, sum, file.SHA256)
+	}
+	return f.Name(), f.Close()
+}
+
+// unpackArchive unpacks the tar.gz or zip archive at path into dir.
+// The name of the archive determines its format.
+func unpackArchive(dir, path, name string) error {
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"):
+		return untar(dir, path)
+	case strings.HasSuffix(name, ".zip"):
+		return unzip(dir, path)
+	default:
+		return fmt.Errorf("unknown archive format: %s", name)
+	}
+}
+
+// untar unpacks the gzipped tar archive at path into dir.
+func untar(dir, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	z, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	r := tar.NewReader(z)
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		dst, err := archivePath(dir, h.Name)
+		if err != nil {
+			return err
+		}
+		switch h.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(dst, 0o755)
+		case tar.TypeReg:
+			err = writeFile(dst, r, h.FileInfo().Mode())
+		default:
+			// Go release archives hold only directories
+			// and regular files.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// unzip unpacks the zip archive at path into dir.
+func unzip(dir, path string) error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		dst, err := archivePath(dir, f.Name)
+		if err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			err = os.MkdirAll(dst, 0o755)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		src, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = writeFile(dst, src, f.Mode())
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archivePath returns the path in dir for the archive entry name,
+// returning an error if the entry would be written outside dir.
+func archivePath(dir, name string) (string, error) {
+	rel := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid archive entry: %q", name)
+	}
+	return filepath.Join(dir, rel), nil
+}
+
+// writeFile writes the contents of r to a new file at path with the
+// provided mode, creating the parent directories if necessary.
+func writeFile(path string, r io.Reader, mode fs.FileMode) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// seedSDK downloads and verifies the release archive for the Go release
+// into the SDK directory used by its golang.org/dl wrapper. The wrapper
+// uses an existing archive of the expected size rather than downloading
+// it again, so this ensures that the unpacked SDK is obtained from an
+// archive that matches the checksum published at https://go.dev/dl.
+func (u *ugbt) seedSDK(ctx context.Context, release string) error {
+	root, err := sdkRoot()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(root, release)
+	_, err = os.Stat(filepath.Join(dir, ".unpacked-success"))
+	if err == nil {
+		// Already downloaded.
+		return nil
+	}
+	file, err := u.sdkArchive(ctx, release, runtime.GOOS, runtime.GOARCH)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+	archive, err := u.fetchArchive(ctx, file, dir)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(archive, filepath.Join(dir, file.Filename))
+	if err != nil {
+		os.Remove(archive)
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "verified %s sha256:%s\n", file.Filename, file.SHA256)
+	return nil
+}
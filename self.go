@@ -0,0 +1,111 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/mod/semver"
+)
+
+// selfCheckTimeout is the time allowed for querying the latest ugbt
+// version, so that an unreachable proxy does not delay commands.
+const selfCheckTimeout = 2 * time.Second
+
+// selfCache is the cached result of the last check for a new ugbt version.
+type selfCache struct {
+	Checked time.Time `json:"checked"`
+	Latest  string    `json:"latest"`
+}
+
+// selfCachePath returns the path of the cached ugbt version check.
+func selfCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ugbt", "self.json"), nil
+}
+
+// notifySelf writes a hint to w if a newer release of ugbt than the running
+// one is available. The latest release is queried at most once each
+// notifyInterval; otherwise the cached result of the last query is used.
+// It returns whether a hint was written.
+func (u *ugbt) notifySelf(ctx context.Context, w io.Writer) bool {
+	if u.config.Notify.DisableSelfCheck {
+		return false
+	}
+	info, err := u.readBuildInfo(ctx, "")
+	if err != nil {
+		return false
+	}
+	_, mod, current, err := modVersion(info, "")
+	if err != nil || !semver.IsValid(current) {
+		// Development builds are not checked.
+		return false
+	}
+	path, err := selfCachePath()
+	if err != nil {
+		return false
+	}
+	var cached selfCache
+	b, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(b, &cached)
+	}
+	if err != nil || time.Since(cached.Checked) > notifyInterval {
+		cached, err = u.checkSelf(ctx, path, mod, current, cached)
+		if err != nil {
+			u.debugf("could not check for a new ugbt version: %v", err)
+		}
+	}
+	if !semver.IsValid(cached.Latest) || semver.Compare(cached.Latest, current) <= 0 {
+		return false
+	}
+	fprintf(w, "note: ugbt %s is available, running %s; run ugbt install latest to update\n", cached.Latest, current)
+	return true
+}
+
+// checkSelf queries the latest release of the ugbt module mod newer than
+// current and caches the result at path. The check time is cached even if
+// the query fails so that the query is made at most once each
+// notifyInterval; the latest version of the previous check is then
+// retained.
+func (u *ugbt) checkSelf(ctx context.Context, path, mod, current string, last selfCache) (selfCache, error) {
+	ctx, cancel := context.WithTimeout(ctx, selfCheckTimeout)
+	defer cancel()
+	versions, queryErr := u.availableVersions(ctx, mod, current, false, versionFilter{
+		keep:        func(version string) bool { return semver.Prerelease(version) == "" },
+		unretracted: true,
+		limit:       1,
+		quiet:       true,
+	})
+	c := selfCache{Checked: time.Now(), Latest: last.Latest}
+	if queryErr == nil {
+		c.Latest = current
+		if len(versions) != 0 {
+			c.Latest = versions[0].Version
+		}
+	}
+	err := c.save(path)
+	if queryErr != nil {
+		return c, queryErr
+	}
+	return c, err
+}
+
+// save writes the cached check to path.
+func (c selfCache) save(path string) error {
+	buf, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return writeCacheFile(path, buf)
+}
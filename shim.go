@@ -0,0 +1,75 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// resolveShim returns the path of the versioned executable that the
+// executable at exepath runs if it is an asdf or mise shim, and the name
+// of the version manager. If exepath is not a shim or the version manager
+// can not resolve it, ok is false.
+func (u *ugbt) resolveShim(ctx context.Context, exepath string) (resolved, manager string, ok bool) {
+	if exepath == "" {
+		return "", "", false
+	}
+	exepath, err := exec.LookPath(exepath)
+	if err != nil {
+		return "", "", false
+	}
+	manager = shimManager(exepath)
+	if manager == "" {
+		return "", "", false
+	}
+	name := exeBase(exepath)
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, manager, "which", name)
+	cmd.Dir = u.wd
+	if len(u.env) != 0 {
+		cmd.Env = append(os.Environ(), u.env...)
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		u.debugf("could not resolve %s shim %s: %v %s", manager, exepath, err, bytes.TrimSpace(stderr.Bytes()))
+		return "", manager, false
+	}
+	resolved = strings.TrimSpace(stdout.String())
+	if resolved == "" || sameFile(resolved, exepath) {
+		return "", manager, false
+	}
+	u.debugf("%s is a shim for %s managed by %s", exepath, resolved, manager)
+	return resolved, manager, true
+}
+
+// shimManager returns the name of the version manager that the executable
+// at path is a shim for, or the empty string if it is not a shim. mise
+// shims are links to the mise executable and asdf shims are scripts that
+// run asdf exec.
+func shimManager(path string) string {
+	if target, err := filepath.EvalSymlinks(path); err == nil && exeBase(target) == "mise" {
+		return "mise"
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	head := make([]byte, 1024)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+	if bytes.HasPrefix(head, []byte("#!")) && bytes.Contains(head, []byte("asdf exec")) {
+		return "asdf"
+	}
+	return ""
+}
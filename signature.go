@@ -0,0 +1,99 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/execabs"
+)
+
+// sshSigNamespace is the namespace that ssh signatures of files verified
+// by ugbt must be made in.
+const sshSigNamespace = "ugbt"
+
+// location returns the location of the signature for the file at src.
+func (c signatureConfig) location(src string) string {
+	if c.Location != "" {
+		return c.Location
+	}
+	if c.Format == "minisign" {
+		return src + ".minisig"
+	}
+	return src + ".sig"
+}
+
+// check returns an error if the signature configuration is not valid.
+func (c signatureConfig) check() error {
+	switch c.Format {
+	case "":
+		return nil
+	case "ssh":
+		if c.Identity == "" {
+			return errors.New("ssh signature verification requires an identity")
+		}
+	case "minisign", "cosign":
+	default:
+		return fmt.Errorf("unknown signature format %q", c.Format)
+	}
+	if c.Key == "" {
+		return fmt.Errorf("%s signature verification requires a key", c.Format)
+	}
+	return nil
+}
+
+// verify verifies that sig is a valid detached signature of data using the
+// ssh-keygen, minisign or cosign command for the configured format.
+func (c signatureConfig) verify(ctx context.Context, data, sig []byte) error {
+	err := c.check()
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "ugbt-verify-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "data")
+	err = os.WriteFile(file, data, 0o600)
+	if err != nil {
+		return err
+	}
+	sigFile := filepath.Join(dir, "data.sig")
+	err = os.WriteFile(sigFile, sig, 0o600)
+	if err != nil {
+		return err
+	}
+
+	key := expandHome(c.Key)
+	var cmd *execabs.Cmd
+	switch c.Format {
+	case "ssh":
+		cmd = execabs.CommandContext(ctx, "ssh-keygen", "-Y", "verify", "-f", key, "-I", c.Identity, "-n", sshSigNamespace, "-s", sigFile)
+		cmd.Stdin = bytes.NewReader(data)
+	case "minisign":
+		cmd = execabs.CommandContext(ctx, "minisign", "-V", "-q", "-p", key, "-m", file, "-x", sigFile)
+	case "cosign":
+		cmd = execabs.CommandContext(ctx, "cosign", "verify-blob", "--key", key, "--signature", sigFile, file)
+	}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err = cmd.Run()
+	if err != nil {
+		msg := strings.TrimSpace(out.String())
+		if msg == "" {
+			return fmt.Errorf("%s signature verification failed: %w", c.Format, err)
+		}
+		return fmt.Errorf("%s signature verification failed: %w: %s", c.Format, err, msg)
+	}
+	return nil
+}
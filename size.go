@@ -0,0 +1,211 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"debug/buildinfo"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"text/tabwriter"
+)
+
+// size implements the size command.
+type size struct {
+	*ugbt
+
+	Deps bool `flag:"deps" help:"also compare the number of module dependencies."`
+	BuildFlags
+}
+
+func (*size) Name() string  { return "size" }
+func (*size) Usage() string { return "[/path/to/go/executable] <version>" }
+func (*size) ShortHelp() string {
+	return text("compare the size of an executable with another version")
+}
+func (*size) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The size command builds the requested version of the executable into a
+temporary directory and prints the size of the installed executable and of
+the candidate, and the difference between them. The installed executable
+is not replaced. If the -deps flag is given, the number of module
+dependencies of each is also compared. If an executable path is not
+provided, the ugbt command is compared.
+
+The candidate is built with the same build flags as install, so the
+default values of the -trimpath and -strip flags are taken from the
+"install" section of the ugbt config. For a fair comparison these should
+match the flags that the installed executable was built with.
+
+`))
+	f.PrintDefaults()
+}
+
+// Run runs the ugbt size command.
+func (s *size) Run(ctx context.Context, args ...string) error {
+	var exe, version string
+	switch len(args) {
+	case 1:
+		version = args[0]
+	case 2:
+		exe, version = args[0], args[1]
+	default:
+		return errors.New("size requires one or two arguments")
+	}
+
+	path, mod, current, err := s.version(ctx, exe)
+	if err != nil {
+		return err
+	}
+	if mod == "std" {
+		return errors.New("size is not supported for the standard library")
+	}
+	installed, err := exePath(exe)
+	if err != nil {
+		return err
+	}
+	// The comparison build does not install an executable, so a
+	// deprecated module is only warned about.
+	err = s.preflight(ctx, mod, version, s.pin(path), true, s.BuildFlags)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "ugbt-size-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	var buf bytes.Buffer
+	stderr := io.Writer(&buf)
+	if s.Verbose || s.Commands {
+		stderr = io.MultiWriter(os.Stderr, stderr)
+	}
+	cmd := s.cmd(ctx, nil, stderr, append(s.installArgs(), path+"@"+version)...)
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, "GOBIN="+dir)
+	if pin := s.pin(path); pin != "" {
+		err = s.usePinned(ctx, cmd, pin, s.BuildFlags)
+		if err != nil {
+			return err
+		}
+	}
+	if s.Sandbox != "" {
+		cleanup, err := s.sandbox(ctx, cmd, s.Sandbox, dir, "")
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+	}
+	err = cmd.Run()
+	if err != nil {
+		if s.Verbose || s.Commands {
+			return fmt.Errorf("go install: %w", err)
+		}
+		return s.sumDBError(ctx, mod, buf.String())
+	}
+	name := exeName(path)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	candidate := filepath.Join(dir, name)
+
+	old, err := measure(installed)
+	if err != nil {
+		return err
+	}
+	new, err := measure(candidate)
+	if err != nil {
+		return err
+	}
+	if new.version == "" {
+		new.version = version
+	}
+	if old.version == "" {
+		old.version = current
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "installed\t%s\t%s", old.version, formatBytes(old.size))
+	if s.Deps {
+		fmt.Fprintf(w, "\t%d deps", old.deps)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "candidate\t%s\t%s\t%s", new.version, formatBytes(new.size), formatDelta(old.size, new.size))
+	if s.Deps {
+		fmt.Fprintf(w, "\t%d deps\t%+d", new.deps, new.deps-old.deps)
+	}
+	fmt.Fprintln(w)
+	return w.Flush()
+}
+
+// exePath returns the path of the executable, or of the running ugbt if
+// exe is empty.
+func exePath(exe string) (string, error) {
+	if exe == "" {
+		return os.Executable()
+	}
+	return exec.LookPath(exe)
+}
+
+// measurement is the size and dependency count of an executable.
+type measurement struct {
+	version string
+	size    int64
+	deps    int
+}
+
+// measure returns the measurement of the executable at path.
+func measure(path string) (measurement, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return measurement{}, err
+	}
+	m := measurement{size: fi.Size()}
+	info, err := buildinfo.ReadFile(path)
+	if err == nil {
+		m.version = builtModule(info).Version
+		m.deps = len(info.Deps)
+	}
+	return m, nil
+}
+
+// formatBytes returns a human readable representation of n bytes.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit && n > -unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit || m <= -unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatDelta returns the difference between old and new sizes in bytes
+// and as a percentage of old.
+func formatDelta(old, new int64) string {
+	d := new - old
+	sign := "+"
+	if d < 0 {
+		sign = "-"
+		d = -d
+	}
+	if old == 0 {
+		return sign + formatBytes(d)
+	}
+	return fmt.Sprintf("%s%s (%s%.1f%%)", sign, formatBytes(d), sign, 100*float64(d)/float64(old))
+}
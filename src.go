@@ -0,0 +1,309 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+	"golang.org/x/mod/sumdb/dirhash"
+
+	"github.com/kortschak/ugbt/internal/browser"
+	"github.com/kortschak/ugbt/internal/modrepo"
+	"github.com/kortschak/ugbt/internal/tool"
+)
+
+// src implements the src command.
+type src struct {
+	*ugbt
+
+	Open bool `flag:"o" help:"open the source file in a browser instead of printing it."`
+}
+
+func (*src) Name() string  { return "src" }
+func (*src) Usage() string { return "</path/to/go/executable> [path/in/module[:line]]" }
+func (*src) ShortHelp() string {
+	return text("print the source of an executable at its installed version")
+}
+func (*src) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The src command prints the source file at the path within the executable's
+module at the installed version, read from the module cache or from the
+module zip held by the module proxy. If the path is a directory, the files
+it holds are listed. If no path is given, the files of the executable's
+main package are listed.
+
+The path may be given as it appears in a stack trace, including the module
+cache or -trimpath prefix of the module and a trailing :line, so that files
+referenced by a panic from an installed tool can be found without checking
+out its source.
+
+If the -o flag is given, the file is opened at the line in the module's
+repository in a browser, using the repository's go-source meta tag
+templates or the URL templates of its forge, and the URL is printed if no
+browser can be opened.
+
+`))
+	f.PrintDefaults()
+}
+
+// Run runs the ugbt src command.
+func (s *src) Run(ctx context.Context, args ...string) error {
+	var exe, file string
+	switch len(args) {
+	case 1:
+		exe = args[0]
+	case 2:
+		exe, file = args[0], args[1]
+	default:
+		return tool.CommandLineErrorf("src requires an executable path and an optional path in its module")
+	}
+	info, err := s.buildInfo(ctx, exe)
+	if err != nil {
+		return err
+	}
+	pkg, mod, version, err := s.exeVersion(ctx, info, exe)
+	if err != nil {
+		return err
+	}
+	rel, line := moduleRelPath(mod, version, file)
+	if rel == "" {
+		rel = pkgDir(pkg, mod)
+	}
+
+	if s.Open {
+		ref := version
+		if !semver.IsValid(version) && mod != "std" {
+			// Built from a local checkout.
+			for _, set := range info.Settings {
+				if set.Key == "vcs.revision" {
+					ref = set.Value
+				}
+			}
+		}
+		links, err := modrepo.SourceLinks(ctx, doFunc(s.do), mod)
+		if err != nil {
+			return err
+		}
+		url := sourceURL(links, mod, ref, rel, line)
+		if url == "" {
+			return fmt.Errorf("no source file URL templates are known for %s", links.Repo)
+		}
+		if !browser.Open(url) {
+			fmt.Println(url)
+		}
+		return nil
+	}
+
+	fsys, err := s.moduleSource(ctx, mod, version)
+	if err != nil {
+		return err
+	}
+	fi, err := fs.Stat(fsys, rel)
+	if err != nil {
+		return fmt.Errorf("%s@%s: %w", mod, version, err)
+	}
+	if !fi.IsDir() {
+		b, err := fs.ReadFile(fsys, rel)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	entries, err := fs.ReadDir(fsys, rel)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		fmt.Println(path.Join(rel, name))
+	}
+	return nil
+}
+
+// sourceURL returns the repository URL of the slash-separated path rel in
+// the module at the ref, which is a module version or a commit hash, with
+// a fragment for the line if it is not zero. The empty string is returned
+// if no URL templates are known for the module's repository.
+func sourceURL(links modrepo.Source, mod, ref, rel string, line int) string {
+	var subdir string
+	switch {
+	case mod == "std":
+		subdir = "src"
+	case semver.IsValid(ref):
+		ref, subdir = versionRef(mod, links.Root, ref)
+	default:
+		_, subdir = versionRef(mod, links.Root, "")
+	}
+	return links.FileURL(ref, path.Join(subdir, rel), line)
+}
+
+// moduleSource returns the source of the module at the version, from the
+// module cache if it is present there, otherwise from the module zip held
+// by the module proxy, checked against the checksum database. The source
+// of the standard library is taken from the active Go toolchain if its
+// version matches.
+func (u *ugbt) moduleSource(ctx context.Context, mod, version string) (fs.FS, error) {
+	if mod == "std" {
+		goversion, err := u.goenv(ctx, "GOVERSION")
+		if err != nil {
+			return nil, err
+		}
+		if goversion != version {
+			return nil, fmt.Errorf("the active Go toolchain is %s, not %s", goversion, version)
+		}
+		goroot, err := u.goenv(ctx, "GOROOT")
+		if err != nil {
+			return nil, err
+		}
+		return os.DirFS(filepath.Join(goroot, "src")), nil
+	}
+	if !semver.IsValid(version) {
+		return nil, fmt.Errorf("%s was built from a local checkout at %s", mod, version)
+	}
+	modcache, err := u.goenv(ctx, "GOMODCACHE")
+	if err != nil {
+		return nil, err
+	}
+	if modcache != "" {
+		emod, err := module.EscapePath(mod)
+		if err != nil {
+			return nil, err
+		}
+		evers, err := module.EscapeVersion(version)
+		if err != nil {
+			return nil, err
+		}
+		dir := filepath.Join(modcache, filepath.FromSlash(emod)+"@"+evers)
+		if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+			return os.DirFS(dir), nil
+		}
+	}
+	buf, ok, err := u.proxyFile(ctx, mod, version, ".zip")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("no proxy holds %s@%s", mod, version)
+	}
+	z, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid module zip: %w", err)
+	}
+	hash, err := zipHash(z)
+	if err != nil {
+		return nil, fmt.Errorf("invalid module zip: %w", err)
+	}
+	verified, err := u.verifyZipHash(ctx, mod, version, hash)
+	if err != nil {
+		return nil, err
+	}
+	if !verified {
+		fprintf(os.Stderr, "warning: %s@%s is not checked against the checksum database\n", mod, version)
+	}
+	return fs.Sub(z, mod+"@"+version)
+}
+
+// zipHash returns the h1 hash of the module zip held in z, as recorded
+// in go.sum files.
+func zipHash(z *zip.Reader) (string, error) {
+	files := make([]string, 0, len(z.File))
+	zfiles := make(map[string]*zip.File, len(z.File))
+	for _, f := range z.File {
+		files = append(files, f.Name)
+		zfiles[f.Name] = f
+	}
+	return dirhash.Hash1(files, func(name string) (io.ReadCloser, error) {
+		f, ok := zfiles[name]
+		if !ok {
+			return nil, fmt.Errorf("file %q not found in zip", name)
+		}
+		return f.Open()
+	})
+}
+
+// moduleRelPath returns the slash-separated path relative to the root of
+// the module at the version for the file p, and the line number following
+// a trailing colon, or zero if there is none. The path may be relative to
+// the module root, or be a path within the module as it appears in a stack
+// trace, either in the module cache, with a -trimpath module@version prefix,
+// or as an import path.
+func moduleRelPath(mod, version, p string) (rel string, line int) {
+	if i := strings.LastIndex(p, ":"); i >= 0 {
+		n, err := strconv.Atoi(p[i+1:])
+		if err == nil {
+			p, line = p[:i], n
+		}
+	}
+	p = filepath.ToSlash(p)
+	prefix := mod
+	if mod != "std" {
+		if emod, err := module.EscapePath(mod); err == nil {
+			if evers, err := module.EscapeVersion(version); err == nil {
+				if i := strings.Index(p, emod+"@"+evers+"/"); i >= 0 {
+					return p[i+len(emod+"@"+evers+"/"):], line
+				}
+			}
+		}
+		if i := strings.Index(p, mod+"@"+version+"/"); i >= 0 {
+			return p[i+len(mod+"@"+version+"/"):], line
+		}
+	} else if rel, ok := stdRelPath(p); ok {
+		return rel, line
+	}
+	if strings.HasPrefix(p, prefix+"/") {
+		return strings.TrimPrefix(p, prefix+"/"), line
+	}
+	return strings.TrimPrefix(path.Clean("/"+p), "/"), line
+}
+
+// pkgDir returns the directory of the package pkg relative to the root of
+// the module mod.
+func pkgDir(pkg, mod string) string {
+	if mod == "std" {
+		return pkg
+	}
+	rel := strings.TrimPrefix(strings.TrimPrefix(pkg, mod), "/")
+	if rel == "" {
+		return "."
+	}
+	return rel
+}
+
+// stdRelPath returns the slash-separated path p relative to GOROOT/src if
+// it appears to be the path of a standard library source file, either in a
+// GOROOT or with a -trimpath GOROOT prefix.
+func stdRelPath(p string) (rel string, ok bool) {
+	if i := strings.LastIndex(p, "/src/"); i >= 0 {
+		root := p[:i]
+		if !strings.HasPrefix(path.Base(root), "go") && !strings.Contains(root, "golang.org/toolchain@") {
+			return "", false
+		}
+		p = p[i+len("/src/"):]
+	} else if path.IsAbs(p) || filepath.IsAbs(filepath.FromSlash(p)) {
+		return "", false
+	}
+	elem, _, found := strings.Cut(p, "/")
+	if !found || strings.Contains(elem, ".") {
+		return "", false
+	}
+	return p, true
+}
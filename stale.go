@@ -0,0 +1,177 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/mod/semver"
+)
+
+// stale implements the stale command.
+type stale struct {
+	*ugbt
+
+	Fix bool `flag:"fix" help:"reinstall the stale executables at their installed module version."`
+	Selection
+	BuildFlags
+}
+
+func (*stale) Name() string  { return "stale" }
+func (*stale) Usage() string { return "[/path/to/go/executable ...]" }
+func (*stale) ShortHelp() string {
+	return text("print a table of executables built with an older Go toolchain")
+}
+func (*stale) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The stale command reads the build information of the provided Go
+executables, or of all the Go executables in the install directory, the bin
+directories of the GOPATH elements and the "dirs" of the "scan" section of
+the ugbt config if none are provided, and prints a table of the executables
+that were built with an older Go release than the installed go command,
+with the Go release they were built with, the release they should be built
+with and their package and module version. Since Go point releases carry
+security fixes to the standard library, executables built with an older
+point release of the same minor release are stale. Executables pinned to a
+Go release by the "go" field of their entry in the "tools" section of the
+ugbt config are compared with the pinned release instead. Commands in the
+Go distribution and executables built from development versions of Go are
+not reported.
+
+If the -fix flag is given, the stale executables are reinstalled at the
+module version they were built from, so that they are rebuilt with the
+current toolchain without being updated, as they would be by the rebuild
+command. The build flags are as for the install command.
+
+`+selectionHelp+`
+`))
+	f.PrintDefaults()
+}
+
+// staleExe is an executable built with an older Go release.
+type staleExe struct {
+	name, exe, path, mod, version string
+}
+
+// Run runs the ugbt stale command.
+func (s *stale) Run(ctx context.Context, args ...string) error {
+	exes := args
+	if len(exes) == 0 {
+		var err error
+		exes, err = s.binExecutables(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	exes, err := s.selectExecutables(ctx, exes, s.Selection)
+	if err != nil {
+		return err
+	}
+	if len(exes) == 0 {
+		return errors.New("no Go executables found")
+	}
+	have, err := s.goenv(ctx, "GOVERSION")
+	if err != nil {
+		return err
+	}
+	if have == "" {
+		return withKind(kindToolchain, errors.New("no go command found"))
+	}
+	if !goRelease.MatchString(have) {
+		return fmt.Errorf("go command is a development version: %s", have)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	var found []staleExe
+	for _, exe := range exes {
+		info, err := s.buildInfo(ctx, exe)
+		if err != nil {
+			fprintf(os.Stderr, "skipping %s: %v\n", exeBase(exe), err)
+			continue
+		}
+		path, mod, version, err := s.exeVersion(ctx, info, exe)
+		if err != nil {
+			fprintf(os.Stderr, "skipping %s: %v\n", exeBase(exe), err)
+			continue
+		}
+		if mod == "std" {
+			continue
+		}
+		built, want, ok := s.staleToolchain(ctx, info, path)
+		if !ok {
+			continue
+		}
+		found = append(found, staleExe{name: exeBase(exe), exe: exe, path: path, mod: mod, version: version})
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s@%s\n", exeBase(exe), built, want, path, version)
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		fmt.Fprintf(os.Stderr, "all %d executables are built with %s\n", len(exes), have)
+		return nil
+	}
+	if !s.Fix {
+		return nil
+	}
+
+	var failed int
+	for _, e := range found {
+		err = s.reinstall(ctx, e.exe, e.path, e.mod, e.version, s.BuildFlags)
+		if err != nil {
+			fprintf(os.Stderr, "failed to rebuild %s: %v\n", e.name, err)
+			failed++
+		}
+	}
+	switch failed {
+	case 0:
+		return nil
+	case 1:
+		return errors.New("1 executable could not be rebuilt")
+	default:
+		return fmt.Errorf("%d executables could not be rebuilt", failed)
+	}
+}
+
+// staleToolchain returns the Go release that the executable with the build
+// information was built with and the release it should be built with, and
+// whether the executable is stale because the first is older. The release
+// it should be built with is the release pinned for the package path in
+// the ugbt config, or the go command's release. Executables are not stale
+// if either release is a development version.
+func (u *ugbt) staleToolchain(ctx context.Context, info *debug.BuildInfo, path string) (built, want string, stale bool) {
+	if f := strings.Fields(info.GoVersion); len(f) != 0 {
+		// Strip any GOEXPERIMENT suffix.
+		built = f[0]
+	}
+	want = u.pin(path)
+	if want == "" {
+		var err error
+		want, err = u.goenv(ctx, "GOVERSION")
+		if err != nil {
+			return built, "", false
+		}
+	}
+	if !goRelease.MatchString(built) || !goRelease.MatchString(want) {
+		return built, want, false
+	}
+	return built, want, semver.Compare(goSemver(built), goSemver(want)) < 0
+}
+
+// rebuildFlags returns the build flags for rebuilding an installed version,
+// allowing the version to be retracted or its module deprecated.
+func rebuildFlags(flags BuildFlags) BuildFlags {
+	flags.AllowRetracted = true
+	flags.AllowDeprecated = true
+	return flags
+}
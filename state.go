@@ -0,0 +1,173 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// state is the persistent record of the executables installed by ugbt.
+// It is stored as JSON in ugbt/state.json in the user's configuration
+// directory.
+type state struct {
+	// Installed is the set of executables installed by ugbt keyed
+	// by their install path.
+	Installed map[string]installed `json:"installed,omitempty"`
+
+	// History is the record of install operations, oldest first.
+	History []operation `json:"history,omitempty"`
+
+	// LastUpdate is the record of the most recent bulk update.
+	LastUpdate *updateRun `json:"last_update,omitempty"`
+}
+
+// updateRun is the record of the results of a bulk update.
+type updateRun struct {
+	// Time is the time the update finished.
+	Time time.Time `json:"time"`
+	// Results holds the outcome for each executable.
+	Results []updateResult `json:"results"`
+}
+
+// installed is the state of an installed executable.
+type installed struct {
+	// Package is the package path of the executable.
+	Package string `json:"package"`
+	// Module and Version are the module path and version of the
+	// executable.
+	Module  string `json:"module"`
+	Version string `json:"version"`
+	// Local is the directory of the local working copy the
+	// executable was built from. It is empty if the executable
+	// was built from a module version.
+	Local string `json:"local,omitempty"`
+	// Overrides are the dependency overrides the executable
+	// was built with. It is nil if there were none.
+	Overrides *overrides `json:"overrides,omitempty"`
+	// Time is the time the executable was installed.
+	Time time.Time `json:"time"`
+	// Sum is the module checksum recorded in the build information
+	// of the executable, and SHA256 is the hex encoded SHA-256 digest
+	// of the executable when it was installed. They are empty for
+	// installs recorded by older versions of ugbt.
+	Sum    string `json:"sum,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// operation is a record of an executable being installed.
+type operation struct {
+	// Path is the install path of the executable.
+	Path string `json:"path"`
+	// Previous is the state of the executable before the operation.
+	// It is nil if the executable had not been installed by ugbt.
+	Previous *installed `json:"previous,omitempty"`
+	// Current is the state of the executable after the operation.
+	Current installed `json:"current"`
+	// Backup is the directory holding the backup of the replaced
+	// executable, if one was made.
+	Backup string `json:"backup,omitempty"`
+}
+
+// maxHistory is the maximum number of operations retained in the state
+// history.
+const maxHistory = 100
+
+// statePath returns the path to the ugbt state file.
+func statePath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ugbt", "state.json"), nil
+}
+
+// loadState returns the stored ugbt state. A missing state file is not
+// an error and results in an empty state.
+func loadState() (*state, error) {
+	path, err := statePath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return &state{}, nil
+		}
+		return nil, err
+	}
+	var s state
+	err = json.Unmarshal(b, &s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// save writes the state to the ugbt state file.
+func (s *state) save() error {
+	path, err := statePath()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, b, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// record adds an install of the executable at path to the state.
+func (s *state) record(path string, current installed, backup string) {
+	op := operation{Path: path, Current: current, Backup: backup}
+	if prev, ok := s.Installed[path]; ok {
+		op.Previous = &prev
+	}
+	if s.Installed == nil {
+		s.Installed = make(map[string]installed)
+	}
+	s.Installed[path] = current
+	s.History = append(s.History, op)
+	if len(s.History) > maxHistory {
+		s.History = s.History[len(s.History)-maxHistory:]
+	}
+}
+
+// last returns the most recent operation in the state history.
+func (s *state) last() (operation, bool) {
+	if len(s.History) == 0 {
+		return operation{}, false
+	}
+	return s.History[len(s.History)-1], true
+}
+
+// revert reverts the most recent operation in the state history. It
+// does not alter any installed executable.
+func (s *state) revert() {
+	op, ok := s.last()
+	if !ok {
+		return
+	}
+	s.History = s.History[:len(s.History)-1]
+	if op.Previous == nil {
+		delete(s.Installed, op.Path)
+	} else {
+		s.Installed[op.Path] = *op.Previous
+	}
+}
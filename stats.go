@@ -0,0 +1,142 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/mod/semver"
+
+	"github.com/kortschak/ugbt/internal/tool"
+)
+
+// stats implements the stats command.
+type stats struct {
+	*ugbt
+
+	Pre bool `flag:"pre" help:"include pre-release versions."`
+}
+
+func (*stats) Name() string  { return "stats" }
+func (*stats) Usage() string { return "</path/to/go/executable>" }
+func (*stats) ShortHelp() string {
+	return text("summarise the release cadence of an executable's module")
+}
+func (*stats) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The stats command summarises the release cadence of the module of a Go
+executable from the publication times of its versions held by the module
+proxy: the number of releases, the first and last releases and how long
+ago the last was published, the median time between releases, and the
+number of releases in each year. Pre-release versions are only counted if
+the -pre flag is given. Versions without a publication time are not
+counted.
+
+`))
+	f.PrintDefaults()
+}
+
+// Run runs the ugbt stats command.
+func (s *stats) Run(ctx context.Context, args ...string) error {
+	if len(args) != 1 {
+		return tool.CommandLineErrorf("stats requires an executable path")
+	}
+	_, mod, current, err := s.version(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	filter := versionFilter{}
+	if !s.Pre {
+		filter.keep = func(version string) bool { return semver.Prerelease(version) == "" }
+	}
+	versions, err := s.availableVersions(ctx, mod, "", true, filter)
+	if err != nil {
+		return err
+	}
+	var (
+		released  []info
+		retracted int
+	)
+	for _, v := range versions {
+		if v.Time.IsZero() {
+			continue
+		}
+		released = append(released, v)
+		if v.isRetracted {
+			retracted++
+		}
+	}
+	if len(released) == 0 {
+		return fmt.Errorf("no dated releases of %s found", mod)
+	}
+	sort.Slice(released, func(i, j int) bool {
+		ti, tj := released[i].Time, released[j].Time
+		if ti.Equal(tj) {
+			return semverCompare(released[i].Version, released[j].Version) < 0
+		}
+		return ti.Before(tj)
+	})
+	first, last := released[0], released[len(released)-1]
+	now := time.Now()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "module\t%s\n", mod)
+	fmt.Fprintf(w, "installed\t%s\n", current)
+	fmt.Fprintf(w, "releases\t%d", len(released))
+	if retracted != 0 {
+		fmt.Fprintf(w, " (%d retracted)", retracted)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "first release\t%s\t%s\n", first.Version, first.Time.Local().Format("_2 Jan 2006"))
+	fmt.Fprintf(w, "last release\t%s\t%s\t%s ago\n", last.Version, last.Time.Local().Format("_2 Jan 2006"), formatDays(now.Sub(last.Time)))
+	if len(released) > 1 {
+		gaps := make([]time.Duration, len(released)-1)
+		for i := range gaps {
+			gaps[i] = released[i+1].Time.Sub(released[i].Time)
+		}
+		fmt.Fprintf(w, "median gap\t%s\n", formatDays(median(gaps)))
+	}
+	var recent int
+	for _, v := range released {
+		if now.Sub(v.Time) <= 365*24*time.Hour {
+			recent++
+		}
+	}
+	fmt.Fprintf(w, "last 12 months\t%d\n", recent)
+	perYear := make(map[int]int)
+	for _, v := range released {
+		perYear[v.Time.Local().Year()]++
+	}
+	for y := first.Time.Local().Year(); y <= now.Year(); y++ {
+		fmt.Fprintf(w, "releases in %d\t%d\n", y, perYear[y])
+	}
+	return w.Flush()
+}
+
+// median returns the median of d, which must not be empty. d is sorted
+// in place.
+func median(d []time.Duration) time.Duration {
+	sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	n := len(d)
+	if n%2 == 1 {
+		return d[n/2]
+	}
+	return (d[n/2-1] + d[n/2]) / 2
+}
+
+// formatDays returns d formatted as a whole number of days.
+func formatDays(d time.Duration) string {
+	days := int(d.Round(24*time.Hour) / (24 * time.Hour))
+	if days == 1 {
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", days)
+}
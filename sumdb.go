@@ -0,0 +1,360 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/sumdb"
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// noSumDB returns whether the checksum database is not consulted for the
+// module, either because GOSUMDB is off or because the module matches a
+// GONOSUMDB pattern. The go command defaults GONOSUMDB to GOPRIVATE.
+func (u *ugbt) noSumDB(ctx context.Context, mod string) (bool, error) {
+	sumdb, err := u.goenv(ctx, "GOSUMDB")
+	if err != nil {
+		return false, err
+	}
+	if sumdb == "off" {
+		return true, nil
+	}
+	return u.isPrivate(ctx, mod, "GONOSUMDB")
+}
+
+// sumDBError returns an error describing the failure of go install for
+// the module with the provided stderr output. If the failure was caused
+// by checksum verification, the returned error explains the cause.
+func (u *ugbt) sumDBError(ctx context.Context, mod, stderr string) error {
+	msg := strings.TrimSpace(stderr)
+	switch {
+	case strings.Contains(msg, "SECURITY ERROR"), strings.Contains(msg, "checksum mismatch"):
+		return fmt.Errorf("%s\n\nchecksum verification failed for %s: the downloaded module does not match its recorded checksum; the version may have been altered after it was published and should not be trusted", msg, mod)
+	case strings.Contains(msg, "verifying module:") || strings.Contains(msg, "verifying go.mod:"):
+		skip, err := u.noSumDB(ctx, mod)
+		if err != nil || skip {
+			return withKind(goOutputKind(msg), errors.New(msg))
+		}
+		if strings.Contains(msg, "404 Not Found") || strings.Contains(msg, "410 Gone") {
+			return fmt.Errorf("%s\n\n%s is not in the checksum database: if it is a private module, add it to GOPRIVATE or GONOSUMDB", msg, mod)
+		}
+	}
+	return withKind(goOutputKind(msg), errors.New(msg))
+}
+
+// sumDBUnreachable returns whether the go command stderr output shows that
+// a module was downloaded but could not be verified because the checksum
+// database could not be reached.
+func sumDBUnreachable(stderr string) bool {
+	if !strings.Contains(stderr, "verifying module:") && !strings.Contains(stderr, "verifying go.mod:") {
+		return false
+	}
+	for _, s := range []string{
+		"dial tcp",
+		"no such host",
+		"i/o timeout",
+		"connection refused",
+		"connection reset",
+		"network is unreachable",
+		"TLS handshake timeout",
+		"Client.Timeout exceeded",
+	} {
+		if strings.Contains(stderr, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipSumDB returns the environment variable that excludes the module from
+// checksum database verification, extending the go env GONOSUMDB patterns,
+// and the name of the checksum database. The module's dependencies are
+// still verified against the go.sum file of the module.
+func (u *ugbt) skipSumDB(ctx context.Context, mod string) (env, name string, _ error) {
+	nosumdb, err := u.goenv(ctx, "GONOSUMDB")
+	if err != nil {
+		return "", "", err
+	}
+	if nosumdb != "" {
+		nosumdb += ","
+	}
+	gosumdb, err := u.goenv(ctx, "GOSUMDB")
+	if err != nil {
+		return "", "", err
+	}
+	name = "sum.golang.org"
+	if f := strings.Fields(gosumdb); len(f) != 0 {
+		name, _, _ = strings.Cut(f[0], "+")
+	}
+	return "GONOSUMDB=" + nosumdb + mod, name, nil
+}
+
+// sumGolangOrgKey is the verifier key for sum.golang.org.
+const sumGolangOrgKey = "sum.golang.org+033de0ae+Ac4zctda0e5eza+HJyk9SxEdh+s3Ux18htTTAD8OuAn8"
+
+// sumDBChecker checks module versions against the checksum database.
+type sumDBChecker struct {
+	client *sumdb.Client
+	ops    *sumDBOps
+}
+
+// sumDB returns a checksum database checker for the GOSUMDB database. If
+// GOSUMDB is off, the returned checker is nil.
+func (u *ugbt) sumDB(ctx context.Context) (*sumDBChecker, error) {
+	gosumdb, err := u.goenv(ctx, "GOSUMDB")
+	if err != nil {
+		return nil, err
+	}
+	if gosumdb == "off" {
+		return nil, nil
+	}
+	ops := &sumDBOps{ctx: ctx, ugbt: u}
+	switch gosumdb {
+	case "", "sum.golang.org":
+		ops.key, ops.url = sumGolangOrgKey, "https://sum.golang.org"
+	case "sum.golang.google.cn":
+		ops.key, ops.url = sumGolangOrgKey, "https://sum.golang.google.cn"
+	default:
+		f := strings.Fields(gosumdb)
+		if len(f) == 0 || len(f) > 2 {
+			return nil, fmt.Errorf("invalid GOSUMDB: %q", gosumdb)
+		}
+		ops.key = f[0]
+		if len(f) == 2 {
+			ops.url = strings.TrimSuffix(f[1], "/")
+		} else {
+			name, _, _ := strings.Cut(ops.key, "+")
+			ops.url = "https://" + name
+		}
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	ops.dir = filepath.Join(dir, "ugbt", "sumdb")
+	nosumdb, err := u.goenv(ctx, "GONOSUMDB")
+	if err != nil {
+		return nil, err
+	}
+	c := sumdb.NewClient(ops)
+	c.SetGONOSUMDB(nosumdb)
+	return &sumDBChecker{client: c, ops: ops}, nil
+}
+
+// sumDBOps implements sumdb.ClientOps, caching checksum database tiles and
+// signed tree heads in the ugbt cache directory.
+type sumDBOps struct {
+	ctx context.Context
+	*ugbt
+
+	key string // key is the verifier key for the database.
+	url string // url is the base URL of the database.
+	dir string // dir is the cache directory.
+
+	mu      sync.Mutex
+	missing map[string]bool // missing is the set of unknown lookups.
+}
+
+func (o *sumDBOps) ReadRemote(path string) ([]byte, error) {
+	buf, err := o.get(o.ctx, o.url+path)
+	var status statusError
+	if errors.As(err, &status) && (status.code == http.StatusNotFound || status.code == http.StatusGone) {
+		o.mu.Lock()
+		if o.missing == nil {
+			o.missing = make(map[string]bool)
+		}
+		o.missing[path] = true
+		o.mu.Unlock()
+	}
+	return buf, err
+}
+
+// isMissing returns whether a lookup of mod@version found no record.
+func (o *sumDBOps) isMissing(mod, version string) bool {
+	emod, err := module.EscapePath(mod)
+	if err != nil {
+		return false
+	}
+	evers, err := module.EscapeVersion(version)
+	if err != nil {
+		return false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.missing["/lookup/"+emod+"@"+evers]
+}
+
+func (o *sumDBOps) ReadConfig(file string) ([]byte, error) {
+	if file == "key" {
+		return []byte(o.key), nil
+	}
+	buf, err := os.ReadFile(filepath.Join(o.dir, "config", filepath.FromSlash(file)))
+	if errors.Is(err, fs.ErrNotExist) {
+		// Start with an empty signed tree.
+		return nil, nil
+	}
+	return buf, err
+}
+
+func (o *sumDBOps) WriteConfig(file string, old, new []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	path := filepath.Join(o.dir, "config", filepath.FromSlash(file))
+	buf, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if !bytes.Equal(buf, old) {
+		return sumdb.ErrWriteConflict
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, new, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (o *sumDBOps) ReadCache(file string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(o.dir, "cache", filepath.FromSlash(file)))
+}
+
+func (o *sumDBOps) WriteCache(file string, data []byte) {
+	path := filepath.Join(o.dir, "cache", filepath.FromSlash(file))
+	if os.MkdirAll(filepath.Dir(path), 0o755) != nil {
+		return
+	}
+	os.WriteFile(path, data, 0o644)
+}
+
+func (o *sumDBOps) Log(msg string) {}
+
+func (o *sumDBOps) SecurityError(msg string) {
+	fmt.Fprintln(os.Stderr, msg)
+}
+
+// checksumStatus returns a description of the checksum database status of
+// the module version. If the checksum database records the version and the
+// module cache holds it, the cached hashes are compared with the recorded
+// hashes. If the module is not checked against the database, the empty
+// string is returned.
+func (u *ugbt) checksumStatus(ctx context.Context, sum *sumDBChecker, mod, version string) (string, error) {
+	if sum == nil {
+		return "", nil
+	}
+	lines, err := sum.client.Lookup(mod, version)
+	if err != nil {
+		if errors.Is(err, sumdb.ErrGONOSUMDB) {
+			return "", nil
+		}
+		if errors.Is(err, sumdb.ErrSecurity) {
+			return "", err
+		}
+		if sum.ops.isMissing(mod, version) {
+			return "not in sumdb", nil
+		}
+		return "", err
+	}
+	if len(lines) == 0 {
+		return "not in sumdb", nil
+	}
+	recorded := make(map[string]string)
+	for _, l := range lines {
+		f := strings.Fields(l)
+		if len(f) == 3 {
+			recorded[f[1]] = f[2]
+		}
+	}
+	cached, err := u.cachedHashes(ctx, mod, version)
+	if err != nil {
+		return "", err
+	}
+	for v, h := range cached {
+		if want, ok := recorded[v]; ok && h != want {
+			return "checksum mismatch", nil
+		}
+	}
+	return "verified", nil
+}
+
+// cachedHashes returns the hashes of the module zip and go.mod for the
+// module version held in the module cache, keyed by the version and
+// version/go.mod, as they appear in go.sum lines. Hashes for files that
+// are not in the cache are omitted.
+func (u *ugbt) cachedHashes(ctx context.Context, mod, version string) (map[string]string, error) {
+	dir, err := u.modCacheVersions(ctx, mod)
+	if err != nil || dir == "" {
+		return nil, err
+	}
+	evers, err := module.EscapeVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Join(dir, evers)
+	hashes := make(map[string]string)
+	ziphash, err := os.ReadFile(base + ".ziphash")
+	if err == nil {
+		hashes[version] = strings.TrimSpace(string(ziphash))
+	}
+	modfile := base + ".mod"
+	if _, err := os.Stat(modfile); err == nil {
+		h, err := dirhash.Hash1([]string{"go.mod"}, func(string) (io.ReadCloser, error) {
+			return os.Open(modfile)
+		})
+		if err != nil {
+			return nil, err
+		}
+		hashes[version+"/go.mod"] = h
+	}
+	return hashes, nil
+}
+
+// verifyZipHash checks the hash of the module zip for the module version
+// against the checksum database. It returns whether the hash was checked,
+// and an error if the database records a different hash. The hash is not
+// checked if GOSUMDB is off or the module is excluded by GONOSUMDB.
+func (u *ugbt) verifyZipHash(ctx context.Context, mod, version, hash string) (bool, error) {
+	sum, err := u.sumDB(ctx)
+	if err != nil || sum == nil {
+		return false, err
+	}
+	lines, err := sum.client.Lookup(mod, version)
+	if err != nil {
+		if errors.Is(err, sumdb.ErrGONOSUMDB) {
+			return false, nil
+		}
+		if sum.ops.isMissing(mod, version) {
+			return false, fmt.Errorf("%s@%s is not in the checksum database: if it is a private module, add it to GOPRIVATE or GONOSUMDB", mod, version)
+		}
+		return false, fmt.Errorf("verify %s@%s: %w", mod, version, err)
+	}
+	for _, l := range lines {
+		f := strings.Fields(l)
+		if len(f) != 3 || f[1] != version {
+			continue
+		}
+		if f[2] != hash {
+			return false, fmt.Errorf("checksum mismatch for %s@%s: downloaded %s but the checksum database records %s; the version may have been altered after it was published and should not be trusted", mod, version, hash, f[2])
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("no zip hash for %s@%s in the checksum database", mod, version)
+}
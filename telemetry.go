@@ -0,0 +1,363 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kortschak/ugbt/internal/tool"
+)
+
+// Telemetry modes. These follow the modes of the Go toolchain's telemetry.
+const (
+	// telemetryOff disables counting.
+	telemetryOff = "off"
+	// telemetryLocal counts command use and errors in local files
+	// that are not uploaded.
+	telemetryLocal = "local"
+	// telemetryOn counts as for telemetryLocal and permits the
+	// counts of completed weeks to be uploaded by the telemetry
+	// -upload command.
+	telemetryOn = "on"
+)
+
+// telemetryConfig holds the opt-in usage telemetry configuration.
+type telemetryConfig struct {
+	// Mode is the telemetry mode, one of "off", "local" or "on".
+	// If empty, telemetry is off.
+	Mode string `json:"mode"`
+
+	// UploadURL is the URL that counts are posted to by the
+	// telemetry -upload command when Mode is "on".
+	UploadURL string `json:"upload_url"`
+}
+
+// mode returns the effective telemetry mode. Telemetry is off unless it
+// has been enabled in the ugbt config, and it is also off if Go toolchain
+// telemetry has been turned off by go telemetry off.
+func (c telemetryConfig) mode() string {
+	switch c.Mode {
+	case telemetryLocal, telemetryOn:
+	default:
+		return telemetryOff
+	}
+	if goTelemetryMode() == telemetryOff {
+		return telemetryOff
+	}
+	return c.Mode
+}
+
+// goTelemetryMode returns the Go toolchain telemetry mode recorded in the
+// user config directory, or the empty string if none is recorded.
+func goTelemetryMode() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "go", "telemetry", "mode"))
+	if err != nil {
+		return ""
+	}
+	// The mode file may hold the time the mode was set
+	// after the mode.
+	mode, _, _ := strings.Cut(strings.TrimSpace(string(b)), " ")
+	return mode
+}
+
+// telemetryDir returns the directory holding the local telemetry counts.
+// As for the Go toolchain, counts are held in a file for each week.
+func telemetryDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ugbt", "telemetry", "local"), nil
+}
+
+// counts is the set of telemetry counters for a week.
+type counts struct {
+	// Week is the date of the first day of the week.
+	Week string `json:"week"`
+	// Program and Version identify the counting program.
+	Program string `json:"program"`
+	Version string `json:"version"`
+	// Counters holds the counts keyed by counter name.
+	Counters map[string]int64 `json:"counters"`
+	// Uploaded is the time the counts were uploaded.
+	Uploaded *time.Time `json:"uploaded,omitempty"`
+}
+
+// weekOf returns the date of the Monday of the week holding t.
+func weekOf(t time.Time) string {
+	t = t.UTC()
+	day := (int(t.Weekday()) + 6) % 7
+	return t.AddDate(0, 0, -day).Format("2006-01-02")
+}
+
+// count increments the telemetry counters for running the named command
+// with the result err. Nothing is counted unless telemetry is enabled.
+// Failing to count is not reported.
+func (u *ugbt) count(command string, err error) {
+	if u.config.Telemetry.mode() == telemetryOff {
+		return
+	}
+	dir, dirErr := telemetryDir()
+	if dirErr != nil {
+		return
+	}
+	week := weekOf(time.Now())
+	path := filepath.Join(dir, week+".json")
+	c, readErr := readCounts(path)
+	if readErr != nil {
+		if !errors.Is(readErr, fs.ErrNotExist) {
+			return
+		}
+		c = counts{Week: week, Program: "ugbt", Version: "(devel)"}
+		if info, ok := debug.ReadBuildInfo(); ok {
+			c.Version = info.Main.Version
+		}
+	}
+	if c.Counters == nil {
+		c.Counters = make(map[string]int64)
+	}
+	c.Counters["command/"+command]++
+	if err != nil {
+		c.Counters["error/"+command+":"+errorClass(err)]++
+	}
+	_ = writeCounts(path, c)
+}
+
+// errorClass returns a coarse class for err that does not include any
+// details of the user's environment.
+func errorClass(err error) string {
+	var (
+		urlErr  *url.Error
+		opErr   *net.OpError
+		exitErr *exec.ExitError
+	)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case tool.IsCommandLineError(err):
+		return "usage"
+	case errors.Is(err, exec.ErrNotFound):
+		return "no-go-command"
+	case errors.As(err, &exitErr):
+		return "go-command"
+	case errors.As(err, &urlErr), errors.As(err, &opErr):
+		return "network"
+	case errors.Is(err, fs.ErrNotExist):
+		return "not-exist"
+	case errors.Is(err, fs.ErrPermission):
+		return "permission"
+	default:
+		return "other"
+	}
+}
+
+// readCounts returns the counts stored at path.
+func readCounts(path string) (counts, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return counts{}, err
+	}
+	var c counts
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return counts{}, fmt.Errorf("invalid telemetry counts %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// writeCounts writes the counts to path.
+func writeCounts(path string, c counts) error {
+	b, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeCacheFile(path, append(b, '\n'))
+}
+
+// telemetry implements the telemetry command.
+type telemetry struct {
+	*ugbt
+
+	Mode   string `flag:"mode" help:"set the telemetry mode in the ugbt config (off, local or on)."`
+	Upload bool   `flag:"upload" help:"upload the counts of completed weeks to the configured upload URL."`
+}
+
+func (*telemetry) Name() string      { return "telemetry" }
+func (*telemetry) Usage() string     { return "" }
+func (*telemetry) ShortHelp() string { return text("manage opt-in usage telemetry") }
+func (*telemetry) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The telemetry command prints the telemetry mode and the locally recorded
+usage counts. Telemetry is off unless enabled in the "telemetry" section of
+the ugbt config or with the -mode flag. In the local mode, the number of
+times each command is run and the class of any error it returns are counted
+in files in ugbt/telemetry/local in the user config directory. No paths,
+arguments or module names are recorded. In the on mode, counts are also
+recorded and the counts of completed weeks may be uploaded to the config's
+"upload_url" with the -upload flag; counts are never uploaded otherwise.
+Telemetry is off if Go telemetry has been turned off with go telemetry off.
+
+`))
+	f.PrintDefaults()
+}
+
+// Run runs the ugbt telemetry command.
+func (t *telemetry) Run(ctx context.Context, args ...string) error {
+	if len(args) != 0 {
+		return errors.New("telemetry does not accept arguments")
+	}
+	if t.Mode != "" {
+		switch t.Mode {
+		case telemetryOff, telemetryLocal, telemetryOn:
+		default:
+			return tool.CommandLineErrorf("invalid telemetry mode %q", t.Mode)
+		}
+		t.config.Telemetry.Mode = t.Mode
+		path, err := saveConfig(t.Config, t.config)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "recorded telemetry mode %s in %s\n", t.Mode, path)
+		if t.Mode == telemetryOn && t.config.Telemetry.UploadURL == "" {
+			fmt.Fprintln(os.Stderr, `no "upload_url" is configured, so counts will only be recorded locally`)
+		}
+	}
+	if t.Upload {
+		return t.upload(ctx)
+	}
+
+	mode := t.config.Telemetry.mode()
+	if mode == telemetryOff && t.config.Telemetry.Mode != "" && t.config.Telemetry.Mode != telemetryOff {
+		fmt.Printf("mode: off (go telemetry is off)\n")
+	} else {
+		fmt.Printf("mode: %s\n", mode)
+	}
+	all, err := t.allCounts()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, c := range all {
+		status := ""
+		if c.Uploaded != nil {
+			status = " (uploaded)"
+		}
+		fmt.Fprintf(w, "\nweek of %s%s\n", c.Week, status)
+		names := make([]string, 0, len(c.Counters))
+		for n := range c.Counters {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			fmt.Fprintf(w, "  %s\t%d\n", n, c.Counters[n])
+		}
+	}
+	return w.Flush()
+}
+
+// allCounts returns all the locally stored counts, oldest first.
+func (t *telemetry) allCounts() ([]counts, error) {
+	dir, err := telemetryDir()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	var all []counts
+	for _, p := range paths {
+		c, err := readCounts(p)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, c)
+	}
+	return all, nil
+}
+
+// upload posts the counts of completed weeks that have not been uploaded
+// to the configured upload URL. Uploading requires the on mode.
+func (t *telemetry) upload(ctx context.Context) error {
+	if t.config.Telemetry.mode() != telemetryOn {
+		return errors.New(`uploading telemetry requires the "on" telemetry mode`)
+	}
+	dst := t.config.Telemetry.UploadURL
+	if dst == "" {
+		return errors.New(`no telemetry "upload_url" is configured`)
+	}
+	dir, err := telemetryDir()
+	if err != nil {
+		return err
+	}
+	all, err := t.allCounts()
+	if err != nil {
+		return err
+	}
+	current := weekOf(time.Now())
+	var n int
+	for _, c := range all {
+		if c.Week >= current || c.Uploaded != nil {
+			continue
+		}
+		b, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, dst, bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := t.do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("uploading counts for week of %s: %s", c.Week, resp.Status)
+		}
+		now := time.Now()
+		c.Uploaded = &now
+		err = writeCounts(filepath.Join(dir, c.Week+".json"), c)
+		if err != nil {
+			return err
+		}
+		n++
+	}
+	switch n {
+	case 0:
+		fmt.Fprintln(os.Stderr, "no counts to upload")
+	case 1:
+		fmt.Fprintln(os.Stderr, "uploaded counts for 1 week")
+	default:
+		fmt.Fprintf(os.Stderr, "uploaded counts for %d weeks\n", n)
+	}
+	return nil
+}
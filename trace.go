@@ -0,0 +1,222 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+
+	"github.com/kortschak/ugbt/internal/modrepo"
+	"github.com/kortschak/ugbt/internal/tool"
+)
+
+// trace implements the trace command.
+type trace struct {
+	*ugbt
+}
+
+func (*trace) Name() string  { return "trace" }
+func (*trace) Usage() string { return "</path/to/go/executable> < trace.txt" }
+func (*trace) ShortHelp() string {
+	return text("annotate a stack trace from an executable with source URLs")
+}
+func (*trace) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The trace command reads a panic or other goroutine stack trace printed by
+the executable from standard input and writes it to standard output with
+each frame annotated with the URL of its source line in the repository of
+the frame's module at the version the executable was built with. Frames
+in the standard library are linked at the Go version the executable was
+built with. Frames whose module or repository can not be determined are
+left unannotated.
+
+`))
+	f.PrintDefaults()
+}
+
+// frameLine matches the file and line of a stack trace frame.
+var frameLine = regexp.MustCompile(`^\t(.+):([0-9]+)(?: \+0x[0-9a-f]+)?$`)
+
+// Run runs the ugbt trace command.
+func (t *trace) Run(ctx context.Context, args ...string) error {
+	if len(args) != 1 {
+		return tool.CommandLineErrorf("trace requires an executable path")
+	}
+	info, err := t.buildInfo(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	_, mod, version, err := t.exeVersion(ctx, info, args[0])
+	if err != nil {
+		return err
+	}
+	r := newFrameResolver(ctx, t.ugbt, info, mod, version)
+
+	w := bufio.NewWriter(os.Stdout)
+	sc := bufio.NewScanner(os.Stdin)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		text := sc.Text()
+		fmt.Fprintln(w, text)
+		m := frameLine.FindStringSubmatch(strings.TrimSuffix(text, "\r"))
+		if m == nil {
+			continue
+		}
+		line, err := strconv.Atoi(m[2])
+		if err != nil {
+			continue
+		}
+		url := r.resolve(m[1], line)
+		if url != "" {
+			fmt.Fprintf(w, "\t\t%s\n", url)
+		}
+	}
+	err = sc.Err()
+	if err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// frameResolver resolves the source URLs of stack trace frames from an
+// executable.
+type frameResolver struct {
+	ctx context.Context
+	u   *ugbt
+
+	// prefixes are the module@version path prefixes
+	// of frames in the modules the executable was
+	// built from, and mods are the module and version
+	// each prefix belongs to.
+	prefixes []string
+	mods     []module.Version
+
+	// main and rev are the main module and its VCS
+	// revision if it was built from a local checkout.
+	main, rev string
+
+	goversion string
+
+	links map[string]*modrepo.Source
+}
+
+func newFrameResolver(ctx context.Context, u *ugbt, info *debug.BuildInfo, mod, version string) *frameResolver {
+	r := &frameResolver{
+		ctx:   ctx,
+		u:     u,
+		links: make(map[string]*modrepo.Source),
+	}
+	// Strip any GOEXPERIMENT suffix from the Go version.
+	if f := strings.Fields(info.GoVersion); len(f) != 0 && strings.HasPrefix(f[0], "go") {
+		r.goversion = f[0]
+	}
+	if mod != "std" {
+		mods := append([]*debug.Module{{Path: mod, Version: version, Replace: builtModule(info).Replace}}, info.Deps...)
+		for _, m := range mods {
+			if m.Replace != nil {
+				if m.Replace.Version == "" {
+					// Local directory replacements have no
+					// source in a repository.
+					continue
+				}
+				m = m.Replace
+			}
+			if !semver.IsValid(m.Version) {
+				if m.Path == mod {
+					for _, set := range info.Settings {
+						if set.Key == "vcs.revision" {
+							r.main, r.rev = mod, set.Value
+						}
+					}
+				}
+				continue
+			}
+			r.add(m.Path, m.Version)
+		}
+	}
+	return r
+}
+
+// add adds the module path prefixes of frames in mod at version to the
+// resolver.
+func (r *frameResolver) add(mod, version string) {
+	v := module.Version{Path: mod, Version: version}
+	r.prefixes = append(r.prefixes, mod+"@"+version+"/")
+	r.mods = append(r.mods, v)
+	emod, err := module.EscapePath(mod)
+	if err != nil {
+		return
+	}
+	evers, err := module.EscapeVersion(version)
+	if err != nil {
+		return
+	}
+	if emod != mod || evers != version {
+		r.prefixes = append(r.prefixes, emod+"@"+evers+"/")
+		r.mods = append(r.mods, v)
+	}
+}
+
+// resolve returns the source URL of the file and line of a frame, or the
+// empty string if it can not be determined.
+func (r *frameResolver) resolve(file string, line int) string {
+	p := filepath.ToSlash(file)
+
+	// Find the longest module@version prefix that is at the
+	// start of the path or is preceded by a path separator.
+	var (
+		mod  module.Version
+		rel  string
+		best int
+	)
+	for i, prefix := range r.prefixes {
+		j := strings.Index(p, prefix)
+		if j < 0 || (j != 0 && p[j-1] != '/') || len(prefix) <= best {
+			continue
+		}
+		mod, rel, best = r.mods[i], p[j+len(prefix):], len(prefix)
+	}
+	switch {
+	case best != 0:
+		return r.url(mod.Path, mod.Version, rel, line)
+	case r.main != "" && strings.HasPrefix(p, r.main+"/"):
+		// A -trimpath build from a local checkout.
+		return r.url(r.main, r.rev, strings.TrimPrefix(p, r.main+"/"), line)
+	}
+	if rel, ok := stdRelPath(p); ok && r.goversion != "" {
+		return r.url("std", r.goversion, rel, line)
+	}
+	return ""
+}
+
+// url returns the source URL of the path rel in mod at ref, which is a
+// version or a VCS revision.
+func (r *frameResolver) url(mod, ref, rel string, line int) string {
+	links, ok := r.links[mod]
+	if !ok {
+		l, err := modrepo.SourceLinks(r.ctx, doFunc(r.u.do), mod)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not find repository for %s: %v\n", mod, err)
+		} else {
+			links = &l
+		}
+		r.links[mod] = links
+	}
+	if links == nil {
+		return ""
+	}
+	return sourceURL(*links, mod, ref, rel, line)
+}
@@ -0,0 +1,140 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// undo implements the undo command.
+type undo struct {
+	*ugbt
+
+	DryRun bool `flag:"dry-run,n" help:"don't restore anything, just print what would be restored."`
+}
+
+func (*undo) Name() string      { return "undo" }
+func (*undo) Usage() string     { return "" }
+func (*undo) ShortHelp() string { return text("revert the most recent install or update") }
+func (*undo) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The undo command reverts the most recent install or update performed by
+ugbt, restoring the replaced executable from its backup and reverting
+the ugbt state. Backups must be enabled in the ugbt config for an
+operation that replaced an executable to be undone. Undoing the install
+of a new executable without a backup removes the executable.
+
+`))
+	f.PrintDefaults()
+}
+
+// Run runs the ugbt undo command.
+func (u *undo) Run(ctx context.Context, args ...string) error {
+	if len(args) != 0 {
+		return errors.New("undo requires no arguments")
+	}
+
+	s, err := loadState()
+	if err != nil {
+		return err
+	}
+	op, ok := s.last()
+	if !ok {
+		return errors.New("nothing to undo")
+	}
+	name := filepath.Base(op.Path)
+	if op.Backup == "" {
+		if op.Previous != nil {
+			return fmt.Errorf("no backup of %s %s: use ugbt install %s %s", name, op.Previous.Version, op.Path, op.Previous.Version)
+		}
+		// The executable was newly installed, so undoing
+		// the install removes it unless it has since been
+		// replaced.
+		if op.Current.SHA256 != "" {
+			sum, err := fileSHA256(op.Path)
+			if err == nil && sum != op.Current.SHA256 {
+				return fmt.Errorf("%s has changed since it was installed", op.Path)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "remove %s %s\n", name, op.Current.Version)
+		if u.DryRun {
+			return nil
+		}
+		err = os.Remove(op.Path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		s.revert()
+		return s.save()
+	}
+	b := backup{Path: op.Path, dir: op.Backup}
+	_, err = os.Stat(b.exe())
+	if err != nil {
+		return fmt.Errorf("backup of %s not available: %w", name, err)
+	}
+	prev := "backup"
+	if op.Previous != nil {
+		prev = op.Previous.Version
+	}
+	fmt.Fprintf(os.Stderr, "restore %s %s to %s\n", name, op.Current.Version, prev)
+	if u.DryRun {
+		return nil
+	}
+	err = replaceFile(op.Path, b.exe())
+	if err != nil {
+		return err
+	}
+	s.revert()
+	err = s.save()
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(op.Backup)
+}
+
+// replaceFile replaces the file at dst with a copy of the file at src.
+// The copy is made beside dst and then renamed over it so that a running
+// executable at dst is not modified in place. On Windows, where a running
+// executable can not be replaced, dst is first renamed aside and removed
+// if it is not running.
+func replaceFile(dst, src string) error {
+	tmp := dst + ".ugbt"
+	err := copyFile(tmp, src)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if runtime.GOOS == "windows" {
+		old := dst + ".old"
+		os.Remove(old)
+		err = os.Rename(dst, old)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			os.Remove(tmp)
+			return err
+		}
+		err = os.Rename(tmp, dst)
+		if err != nil {
+			os.Rename(old, dst)
+			os.Remove(tmp)
+			return err
+		}
+		// This fails if the old executable is running,
+		// in which case it is removed by a later replacement.
+		os.Remove(old)
+		return nil
+	}
+	err = os.Rename(tmp, dst)
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,174 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"debug/buildinfo"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/kortschak/ugbt/internal/tool"
+)
+
+// verify implements the verify command.
+type verify struct {
+	*ugbt
+
+	All bool `flag:"all" help:"verify all the executables installed by ugbt."`
+}
+
+func (*verify) Name() string      { return "verify" }
+func (*verify) Usage() string     { return "[-all | /path/to/go/executable ...]" }
+func (*verify) ShortHelp() string { return text("check installed executables against the ugbt state") }
+func (*verify) DetailedHelp(f *flag.FlagSet) {
+	fmt.Fprint(f.Output(), text(`
+The verify command checks that the executables installed by ugbt have not
+been changed since they were installed. Each executable is compared with
+the module, version, module checksum and SHA-256 digest recorded in the
+ugbt state when it was installed, and executables that are missing, have
+been modified, or have been replaced by another installer are reported. If
+the -all flag is given, all the executables recorded in the state are
+verified. An error is returned if any executable fails verification.
+
+Executables installed by older versions of ugbt have no recorded digest,
+so only their module and version are checked.
+
+`))
+	f.PrintDefaults()
+}
+
+// Run runs the ugbt verify command.
+func (v *verify) Run(ctx context.Context, args ...string) error {
+	if v.All == (len(args) != 0) {
+		return tool.CommandLineErrorf("verify requires either -all or executable paths")
+	}
+	s, err := loadState()
+	if err != nil {
+		return err
+	}
+	var paths []string
+	if v.All {
+		for path := range s.Installed {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+	} else {
+		for _, exe := range args {
+			path, err := exePath(exe)
+			if err != nil {
+				return err
+			}
+			path, err = filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		fmt.Fprintln(os.Stderr, "no executables installed by ugbt")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	var failed int
+	for _, path := range paths {
+		want, ok := s.Installed[path]
+		if !ok {
+			fmt.Fprintf(w, "%s\tunknown\tnot installed by ugbt\n", path)
+			failed++
+			continue
+		}
+		status, detail := verifyInstalled(path, want)
+		fmt.Fprintf(w, "%s\t%s\t%s\n", path, status, detail)
+		if status != "ok" {
+			failed++
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+	switch failed {
+	case 0:
+		return nil
+	case 1:
+		return errors.New("1 executable failed verification")
+	default:
+		return fmt.Errorf("%d executables failed verification", failed)
+	}
+}
+
+// verifyInstalled checks the executable at path against its recorded
+// state and returns a status of ok, missing, replaced or modified with a
+// description of any difference.
+func verifyInstalled(path string, want installed) (status, detail string) {
+	recorded := want.Module + "@" + want.Version
+	info, err := buildinfo.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "missing", "expected " + recorded
+		}
+		return "replaced", fmt.Sprintf("expected %s: %v", recorded, err)
+	}
+	m := builtModule(info)
+	mod, version := m.Path, m.Version
+	if mod == "" && want.Module == "std" {
+		mod, version = "std", info.GoVersion
+	}
+	if mod != want.Module || version != want.Version {
+		return "replaced", fmt.Sprintf("expected %s, found %s@%s", recorded, mod, version)
+	}
+	if want.Sum != "" && moduleSum(info) != want.Sum {
+		return "modified", fmt.Sprintf("%s module checksum %s does not match the recorded %s", recorded, moduleSum(info), want.Sum)
+	}
+	if want.SHA256 == "" {
+		return "ok", recorded + " (no recorded digest)"
+	}
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return "modified", err.Error()
+	}
+	if sum != want.SHA256 {
+		return "modified", recorded + " has been changed since it was installed"
+	}
+	return "ok", recorded
+}
+
+// moduleSum returns the module checksum of the executable's module
+// recorded in the build information, following any replacement.
+func moduleSum(info *debug.BuildInfo) string {
+	m := builtModule(info)
+	if m.Replace != nil {
+		return m.Replace.Sum
+	}
+	return m.Sum
+}
+
+// fileSHA256 returns the hex encoded SHA-256 digest of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
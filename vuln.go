@@ -0,0 +1,682 @@
+// Copyright ©2021 Dan Kortschak. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+const (
+	// osvDefaultAPI is the root of the OSV API used when
+	// none is configured.
+	osvDefaultAPI = "https://api.osv.dev"
+
+	// osvBatchLimit is the maximum number of queries in
+	// an OSV batch query.
+	osvBatchLimit = 1000
+
+	// osvDefaultTTL is how long OSV query results are
+	// cached when no TTL is configured.
+	osvDefaultTTL = 24 * time.Hour
+)
+
+// osvPackage is an OSV package identifier.
+type osvPackage struct {
+	Name      string `json:"name"`
+	Ecosystem string `json:"ecosystem"`
+}
+
+// osvQuery is a query for the vulnerabilities affecting a package version.
+type osvQuery struct {
+	Package   osvPackage `json:"package"`
+	Version   string     `json:"version"`
+	PageToken string     `json:"page_token,omitempty"`
+}
+
+// osvRef is a reference to a vulnerability returned by a batch query.
+type osvRef struct {
+	ID       string    `json:"id"`
+	Modified time.Time `json:"modified"`
+}
+
+// osvVuln is an OSV vulnerability record.
+type osvVuln struct {
+	ID       string        `json:"id"`
+	Modified time.Time     `json:"modified"`
+	Summary  string        `json:"summary"`
+	Aliases  []string      `json:"aliases"`
+	Affected []osvAffected `json:"affected"`
+	Severity []struct {
+		Type  string `json:"type"`
+		Score string `json:"score"`
+	} `json:"severity"`
+	DatabaseSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"database_specific"`
+}
+
+// severity returns the severity of the vulnerability, taken from the
+// severity rating given by the database or the CVSS v3 base score.
+func (v osvVuln) severity() severity {
+	if s, ok := parseSeverity(v.DatabaseSpecific.Severity); ok {
+		return s
+	}
+	sev := unknownSeverity
+	for _, s := range v.Severity {
+		if s.Type != "CVSS_V3" {
+			continue
+		}
+		score, ok := cvss3Score(s.Score)
+		if ok && cvssSeverity(score) > sev {
+			sev = cvssSeverity(score)
+		}
+	}
+	return sev
+}
+
+// osvAffected describes the affected versions of a package.
+type osvAffected struct {
+	Package osvPackage `json:"package"`
+	Ranges  []struct {
+		Type   string `json:"type"`
+		Events []struct {
+			Introduced string `json:"introduced"`
+			Fixed      string `json:"fixed"`
+		} `json:"events"`
+	} `json:"ranges"`
+}
+
+// fixed returns the earliest version of the module mod that fixes the
+// vulnerability at or after version, or the empty string if no fix is
+// known.
+func (v osvVuln) fixed(mod, version string) string {
+	name, osvVersion := osvName(module.Version{Path: mod, Version: version})
+	var fix, fixVersion string
+	for _, a := range v.Affected {
+		if a.Package.Ecosystem != "Go" || a.Package.Name != name {
+			continue
+		}
+		for _, r := range a.Ranges {
+			if r.Type != "SEMVER" {
+				continue
+			}
+			for _, e := range r.Events {
+				if e.Fixed == "" {
+					continue
+				}
+				if semver.Compare("v"+e.Fixed, "v"+osvVersion) <= 0 {
+					continue
+				}
+				if fix == "" || semver.Compare("v"+e.Fixed, fixVersion) < 0 {
+					fix = "v" + e.Fixed
+					if mod == "std" {
+						fix = "go" + e.Fixed
+					}
+					fixVersion = "v" + e.Fixed
+				}
+			}
+		}
+	}
+	return fix
+}
+
+// finding is a vulnerability affecting a module version, merged with the
+// records of its aliases.
+type finding struct {
+	osvVuln
+
+	// severity is the highest severity of the
+	// vulnerability's records.
+	severity severity
+
+	// fix is the earliest version of the module
+	// that fixes the vulnerability, or empty if
+	// there is none.
+	fix string
+
+	// ids holds the IDs and aliases of all the
+	// merged records.
+	ids map[string]bool
+}
+
+// hasID returns whether id is the ID or an alias of one of the records of
+// the finding.
+func (f finding) hasID(id string) bool {
+	return f.ids[id]
+}
+
+// findings returns the vulnerabilities affecting mod at version, with
+// records that share an ID or alias merged. The ID of a merged finding
+// is a Go vulnerability database ID if one of its records has one.
+func findings(mod, version string, vulns []osvVuln) []finding {
+	var found []finding
+	merged := make([]bool, len(vulns))
+	for i := range vulns {
+		if merged[i] {
+			continue
+		}
+		merged[i] = true
+		group := []osvVuln{vulns[i]}
+		ids := map[string]bool{vulns[i].ID: true}
+		for _, a := range vulns[i].Aliases {
+			ids[a] = true
+		}
+		for added := true; added; {
+			added = false
+			for j := range vulns {
+				if merged[j] || !sharesID(vulns[j], ids) {
+					continue
+				}
+				merged[j], added = true, true
+				group = append(group, vulns[j])
+				ids[vulns[j].ID] = true
+				for _, a := range vulns[j].Aliases {
+					ids[a] = true
+				}
+			}
+		}
+		f := finding{osvVuln: group[0], ids: ids}
+		for _, v := range group {
+			if strings.HasPrefix(v.ID, "GO-") && !strings.HasPrefix(f.ID, "GO-") {
+				f.osvVuln = v
+			}
+			if s := v.severity(); s > f.severity {
+				f.severity = s
+			}
+			if fix := v.fixed(mod, version); fix != "" && (f.fix == "" || semverCompare(fix, f.fix) < 0) {
+				f.fix = fix
+			}
+		}
+		found = append(found, f)
+	}
+	return found
+}
+
+// sharesID returns whether the ID or an alias of v is in ids.
+func sharesID(v osvVuln, ids map[string]bool) bool {
+	if ids[v.ID] {
+		return true
+	}
+	for _, a := range v.Aliases {
+		if ids[a] {
+			return true
+		}
+	}
+	return false
+}
+
+// osvName returns the OSV Go ecosystem package name and version of the
+// module version. The standard library is the "stdlib" package, and its
+// versions are the semantic versions of the Go releases, so go1.20 is
+// 1.20.0 and go1.21rc2 is 1.21.0-rc.2, as in the Go vulnerability
+// database.
+func osvName(m module.Version) (name, version string) {
+	if m.Path == "std" {
+		v := strings.TrimPrefix(goSemver(m.Version), "v")
+		if i := strings.Index(v, "-"); i >= 0 {
+			pre := v[i+1:]
+			if j := strings.IndexAny(pre, "0123456789"); j > 0 {
+				v = v[:i+1] + pre[:j] + "." + pre[j:]
+			}
+		}
+		return "stdlib", v
+	}
+	return m.Path, strings.TrimPrefix(m.Version, "v")
+}
+
+// vulnModules returns the module versions the executable described by
+// info was built from that can be checked for vulnerabilities: the main
+// module and its dependencies at released or pseudo-versions, following
+// replacements, and the standard library of the Go release it was built
+// with.
+func vulnModules(info *debug.BuildInfo, mod, version string) []module.Version {
+	var mods []module.Version
+	if f := strings.Fields(info.GoVersion); len(f) != 0 && isGoRelease(f[0]) {
+		mods = append(mods, module.Version{Path: "std", Version: f[0]})
+	}
+	if mod == "std" {
+		return mods
+	}
+	main := builtModule(info)
+	for _, m := range append([]*debug.Module{{Path: mod, Version: version, Replace: main.Replace}}, info.Deps...) {
+		if m == main {
+			// The executable was built with dependency overrides
+			// and its module is already included.
+			continue
+		}
+		if m.Replace != nil {
+			m = m.Replace
+		}
+		if semver.IsValid(m.Version) {
+			mods = append(mods, module.Version{Path: m.Path, Version: m.Version})
+		}
+	}
+	return mods
+}
+
+// vulnerabilities returns the OSV vulnerabilities affecting each of the
+// module versions. Modules that are not cached within the configured TTL
+// are queried with OSV batch queries, and the records of the reported
+// vulnerabilities are fetched unless an up to date record is cached. The
+// -refresh flag ignores the cached query results.
+func (u *ugbt) vulnerabilities(ctx context.Context, mods []module.Version) (map[module.Version][]osvVuln, error) {
+	api := strings.TrimSuffix(u.config.Vuln.API, "/")
+	if api == "" {
+		api = osvDefaultAPI
+	}
+	ttl := time.Duration(u.config.Vuln.CacheTTL)
+	if ttl == 0 {
+		ttl = osvDefaultTTL
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	dir = filepath.Join(dir, "ugbt", "osv")
+
+	refs := make(map[module.Version][]osvRef)
+	var missing []module.Version
+	for _, m := range mods {
+		if _, ok := refs[m]; ok {
+			continue
+		}
+		r, ok := cachedVulnQuery(dir, m, ttl)
+		if ok && !u.Refresh {
+			refs[m] = r
+			continue
+		}
+		refs[m] = nil
+		missing = append(missing, m)
+	}
+	if len(missing) != 0 {
+		u.debugf("querying OSV for %d of %d modules", len(missing), len(refs))
+	}
+	for len(missing) != 0 {
+		n := len(missing)
+		if n > osvBatchLimit {
+			n = osvBatchLimit
+		}
+		batch := missing[:n]
+		missing = missing[n:]
+		err = u.queryVulnBatch(ctx, api, batch, refs)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range batch {
+			// Failing to update the cache is not fatal.
+			_ = writeVulnQuery(dir, m, refs[m])
+		}
+	}
+
+	records := make(map[string]osvVuln)
+	vulns := make(map[module.Version][]osvVuln)
+	for m, rs := range refs {
+		for _, r := range rs {
+			v, ok := records[r.ID]
+			if !ok {
+				v, err = u.vulnRecord(ctx, api, dir, r)
+				if err != nil {
+					return nil, err
+				}
+				records[r.ID] = v
+			}
+			vulns[m] = append(vulns[m], v)
+		}
+	}
+	return vulns, nil
+}
+
+// securityFixes returns the IDs of the known vulnerabilities affecting the
+// installed version of the target's executable that are fixed by updating
+// to the target version, either in the executable's module or in the
+// dependencies required by the target version's go.mod file.
+func (u *ugbt) securityFixes(ctx context.Context, t target) ([]string, error) {
+	var mods []module.Version
+	if t.mod == "std" {
+		mods = []module.Version{{Path: "std", Version: t.current}}
+	} else {
+		info, err := u.buildInfo(ctx, t.exe)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range vulnModules(info, t.mod, t.current) {
+			if m.Path != "std" {
+				mods = append(mods, m)
+			}
+		}
+	}
+	vulns, err := u.vulnerabilities(ctx, mods)
+	if err != nil {
+		return nil, err
+	}
+
+	var required map[string]string
+	var fixes []string
+	for _, m := range mods {
+		for _, f := range findings(m.Path, m.Version, vulns[m]) {
+			if f.fix == "" {
+				continue
+			}
+			if m.Path == t.mod {
+				if semverCompare(t.version, f.fix) >= 0 {
+					fixes = append(fixes, f.ID)
+				}
+				continue
+			}
+			if required == nil {
+				required, err = u.requirements(ctx, t.mod, t.version)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if v, ok := required[m.Path]; ok && semver.Compare(v, f.fix) >= 0 {
+				fixes = append(fixes, f.ID)
+			}
+		}
+	}
+	return fixes, nil
+}
+
+// requirements returns the versions of the modules required by the go.mod
+// file of the module at the version, keyed by module path. Replacements are
+// not considered since go install rejects modules with replace directives.
+func (u *ugbt) requirements(ctx context.Context, mod, version string) (map[string]string, error) {
+	buf, ok, err := u.proxyFile(ctx, mod, version, ".mod")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("no proxy holds %s@%s", mod, version)
+	}
+	f, err := modfile.ParseLax(mod+"@"+version+"/go.mod", buf, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modfile: %w", err)
+	}
+	required := make(map[string]string)
+	for _, r := range f.Require {
+		required[r.Mod.Path] = r.Mod.Version
+	}
+	return required, nil
+}
+
+// queryVulnBatch queries the OSV API for the vulnerabilities affecting the
+// module versions in batch, adding them to refs. Queries with more results
+// than are returned in a single response are followed until all the
+// results have been read.
+func (u *ugbt) queryVulnBatch(ctx context.Context, api string, batch []module.Version, refs map[module.Version][]osvRef) error {
+	queries := make([]osvQuery, len(batch))
+	for i, m := range batch {
+		name, version := osvName(m)
+		queries[i] = osvQuery{Package: osvPackage{Name: name, Ecosystem: "Go"}, Version: version}
+	}
+	for len(queries) != 0 {
+		body, err := json.Marshal(struct {
+			Queries []osvQuery `json:"queries"`
+		}{queries})
+		if err != nil {
+			return err
+		}
+		buf, err := u.post(ctx, api+"/v1/querybatch", "application/json", body)
+		if err != nil {
+			return fmt.Errorf("query OSV: %w", err)
+		}
+		var resp struct {
+			Results []struct {
+				Vulns         []osvRef `json:"vulns"`
+				NextPageToken string   `json:"next_page_token"`
+			} `json:"results"`
+		}
+		err = json.Unmarshal(buf, &resp)
+		if err != nil {
+			return fmt.Errorf("invalid OSV batch response: %w", err)
+		}
+		if len(resp.Results) != len(queries) {
+			return fmt.Errorf("invalid OSV batch response: %d results for %d queries", len(resp.Results), len(queries))
+		}
+		var (
+			next    []osvQuery
+			pending []module.Version
+		)
+		for i, r := range resp.Results {
+			refs[batch[i]] = append(refs[batch[i]], r.Vulns...)
+			if r.NextPageToken != "" {
+				q := queries[i]
+				q.PageToken = r.NextPageToken
+				next = append(next, q)
+				pending = append(pending, batch[i])
+			}
+		}
+		queries, batch = next, pending
+	}
+	return nil
+}
+
+// vulnRecord returns the OSV record for the vulnerability, from the cache
+// if it is at least as recent as the reference.
+func (u *ugbt) vulnRecord(ctx context.Context, api, dir string, r osvRef) (osvVuln, error) {
+	path := filepath.Join(dir, "vulns", url.PathEscape(r.ID)+".json")
+	var v osvVuln
+	buf, err := os.ReadFile(path)
+	if err == nil && json.Unmarshal(buf, &v) == nil && v.ID == r.ID && !v.Modified.Before(r.Modified) {
+		return v, nil
+	}
+	buf, err = u.get(ctx, api+"/v1/vulns/"+url.PathEscape(r.ID))
+	if err != nil {
+		return osvVuln{}, fmt.Errorf("fetch %s: %w", r.ID, err)
+	}
+	v = osvVuln{}
+	err = json.Unmarshal(buf, &v)
+	if err != nil {
+		return osvVuln{}, fmt.Errorf("invalid OSV record for %s: %w", r.ID, err)
+	}
+	// Failing to update the cache is not fatal.
+	_ = writeCacheFile(path, buf)
+	return v, nil
+}
+
+// vulnQueryCache is a cached OSV query result.
+type vulnQueryCache struct {
+	Module  string    `json:"module"`
+	Version string    `json:"version"`
+	Fetched time.Time `json:"fetched"`
+	Vulns   []osvRef  `json:"vulns"`
+}
+
+// vulnQueryPath returns the path of the cached query result for m.
+func vulnQueryPath(dir string, m module.Version) string {
+	sum := sha256.Sum256([]byte(m.Path + "@" + m.Version))
+	return filepath.Join(dir, "queries", hex.EncodeToString(sum[:])+".json")
+}
+
+// cachedVulnQuery returns the cached query result for m if it was fetched
+// within the ttl.
+func cachedVulnQuery(dir string, m module.Version, ttl time.Duration) ([]osvRef, bool) {
+	buf, err := os.ReadFile(vulnQueryPath(dir, m))
+	if err != nil {
+		return nil, false
+	}
+	var c vulnQueryCache
+	err = json.Unmarshal(buf, &c)
+	if err != nil || c.Module != m.Path || c.Version != m.Version || time.Since(c.Fetched) > ttl {
+		return nil, false
+	}
+	return c.Vulns, true
+}
+
+// writeVulnQuery writes the query result for m to the cache.
+func writeVulnQuery(dir string, m module.Version, refs []osvRef) error {
+	buf, err := json.Marshal(vulnQueryCache{
+		Module:  m.Path,
+		Version: m.Version,
+		Fetched: time.Now(),
+		Vulns:   refs,
+	})
+	if err != nil {
+		return err
+	}
+	return writeCacheFile(vulnQueryPath(dir, m), buf)
+}
+
+// post returns the body of a POST request of body with the provided
+// content type to url. Any non 200 response status is returned as an
+// error. Requests that are rejected by rate limiting are retried after
+// the delay requested by the server.
+func (u *ugbt) post(ctx context.Context, url, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := u.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return nil, statusError{status: resp.Status, code: resp.StatusCode}
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// severity is a vulnerability severity rating.
+type severity int
+
+const (
+	unknownSeverity severity = iota
+	lowSeverity
+	moderateSeverity
+	highSeverity
+	criticalSeverity
+)
+
+func (s severity) String() string {
+	switch s {
+	case lowSeverity:
+		return "LOW"
+	case moderateSeverity:
+		return "MODERATE"
+	case highSeverity:
+		return "HIGH"
+	case criticalSeverity:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// parseSeverity returns the severity with the name s, ignoring case.
+// MEDIUM is accepted as MODERATE.
+func parseSeverity(s string) (severity, bool) {
+	switch strings.ToUpper(s) {
+	case "LOW":
+		return lowSeverity, true
+	case "MODERATE", "MEDIUM":
+		return moderateSeverity, true
+	case "HIGH":
+		return highSeverity, true
+	case "CRITICAL":
+		return criticalSeverity, true
+	default:
+		return unknownSeverity, false
+	}
+}
+
+// cvssSeverity returns the qualitative severity rating of a CVSS score.
+func cvssSeverity(score float64) severity {
+	switch {
+	case score >= 9:
+		return criticalSeverity
+	case score >= 7:
+		return highSeverity
+	case score >= 4:
+		return moderateSeverity
+	case score > 0:
+		return lowSeverity
+	default:
+		return unknownSeverity
+	}
+}
+
+// cvss3Score returns the CVSS v3 base score of the vector.
+func cvss3Score(vector string) (float64, bool) {
+	metrics := strings.Split(vector, "/")
+	if len(metrics) == 0 || !strings.HasPrefix(metrics[0], "CVSS:3.") {
+		return 0, false
+	}
+	weights := map[string]map[string]float64{
+		"AV": {"N": 0.85, "A": 0.62, "L": 0.55, "P": 0.2},
+		"AC": {"L": 0.77, "H": 0.44},
+		"PR": {"N": 0.85, "L": 0.62, "H": 0.27},
+		"UI": {"N": 0.85, "R": 0.62},
+		"C":  {"H": 0.56, "L": 0.22, "N": 0},
+		"I":  {"H": 0.56, "L": 0.22, "N": 0},
+		"A":  {"H": 0.56, "L": 0.22, "N": 0},
+	}
+	values := make(map[string]string)
+	for _, metric := range metrics[1:] {
+		k, v, ok := strings.Cut(metric, ":")
+		if !ok {
+			return 0, false
+		}
+		values[k] = v
+	}
+	changed := values["S"] == "C"
+	if changed {
+		weights["PR"]["L"], weights["PR"]["H"] = 0.68, 0.5
+	}
+	m := make(map[string]float64)
+	for k, w := range weights {
+		v, ok := w[values[k]]
+		if !ok {
+			return 0, false
+		}
+		m[k] = v
+	}
+	iss := 1 - (1-m["C"])*(1-m["I"])*(1-m["A"])
+	var impact float64
+	if changed {
+		impact = 7.52*(iss-0.029) - 3.25*math.Pow(iss-0.02, 15)
+	} else {
+		impact = 6.42 * iss
+	}
+	if impact <= 0 {
+		return 0, true
+	}
+	exploitability := 8.22 * m["AV"] * m["AC"] * m["PR"] * m["UI"]
+	score := impact + exploitability
+	if changed {
+		score *= 1.08
+	}
+	return roundUp(math.Min(score, 10)), true
+}
+
+// roundUp returns x rounded up to one decimal place as specified by
+// CVSS v3.1.
+func roundUp(x float64) float64 {
+	i := int(math.Round(x * 100000))
+	if i%10000 == 0 {
+		return float64(i) / 100000
+	}
+	return float64(i/10000+1) / 10
+}